@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lovoo/ipmi_exporter/collector"
+	"github.com/lovoo/ipmi_exporter/config"
+	"github.com/lovoo/ipmi_exporter/sel"
+
+	"github.com/prometheus/common/log"
+)
+
+// apiPrefix is the path of the JSON API serving snapshots of the BMCs at
+// apiPrefix<target>/<kind>.
+const apiPrefix = "/api/v1/targets/"
+
+// api serves JSON snapshots of the sensors, SEL, FRUs and BMC of the local
+// BMC, with the target "local", and of remote BMCs probed like the probe
+// endpoint does. The snapshots are read from the backend of the exporter of
+// the target with the parsers used by Collect.
+type api struct {
+	local  *collector.Exporter
+	prober *prober
+}
+
+// apiKinds read the snapshots of a kind.
+var apiKinds = map[string]func(e *collector.Exporter, target string) (interface{}, error){
+	"sensors": func(e *collector.Exporter, target string) (interface{}, error) {
+		return e.Sensors()
+	},
+	"sel": readSELSnapshot,
+	"fru": func(e *collector.Exporter, target string) (interface{}, error) {
+		return e.FRU()
+	},
+	"bmc": func(e *collector.Exporter, target string) (interface{}, error) {
+		return e.BMC()
+	},
+}
+
+// selSnapshot is the state and the records of a SEL.
+type selSnapshot struct {
+	Entries       int          `json:"entries"`
+	FullnessRatio float64      `json:"fullness_ratio"`
+	LastCleared   *time.Time   `json:"last_cleared,omitempty"`
+	Records       []*sel.Event `json:"records"`
+}
+
+func readSELSnapshot(e *collector.Exporter, target string) (interface{}, error) {
+	info, err := e.SEL()
+	if err != nil {
+		return nil, err
+	}
+	output, err := e.SELRecords()
+	if err != nil {
+		return nil, err
+	}
+	s := &selSnapshot{
+		Entries:       info.Entries,
+		FullnessRatio: info.Fullness,
+		Records:       sel.Parse(target, output),
+	}
+	if !info.LastCleared.IsZero() {
+		s.LastCleared = &info.LastCleared
+	}
+	if s.Records == nil {
+		s.Records = []*sel.Event{}
+	}
+	return s, nil
+}
+
+func (a *api) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, apiPrefix), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		writeAPIError(w, http.StatusNotFound, "want "+apiPrefix+"<target>/<sensors|sel|fru|bmc>")
+		return
+	}
+	target := parts[0]
+	read, ok := apiKinds[parts[1]]
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "unknown snapshot "+parts[1]+", want sensors, sel, fru or bmc")
+		return
+	}
+
+	exporter := a.local
+	moduleName := r.URL.Query().Get("module")
+	if target != config.LocalTarget || moduleName != "" {
+		var (
+			status int
+			err    error
+		)
+		exporter, status, err = a.prober.lookup(target, moduleName)
+		if err != nil {
+			writeAPIError(w, status, err.Error())
+			return
+		}
+	}
+
+	v, err := read(exporter, target)
+	if err != nil {
+		log.With("target", target).With("module", moduleName).Errorf("Error reading %s: %v", parts[1], err)
+		writeAPIError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeAPIResponse(w, http.StatusOK, v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, msg string) {
+	writeAPIResponse(w, status, map[string]string{"error": msg})
+}
+
+func writeAPIResponse(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("Error encoding API response: %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+// Command ipmi_simulator runs a simulated BMC answering IPMI over LAN
+// requests from a scenario file, e.g. for testing against ipmitool:
+//
+//	ipmi_simulator -scenario simulator/testdata/supermicro.json -listen 127.0.0.1:6230
+//	ipmitool -I lanplus -H 127.0.0.1 -p 6230 -U ADMIN -P ADMIN sensor
+package main
+
+import (
+	"flag"
+
+	"github.com/lovoo/ipmi_exporter/simulator"
+
+	"github.com/prometheus/common/log"
+)
+
+var (
+	listenAddress = flag.String("listen", "127.0.0.1:623", "UDP address to answer RMCP requests on.")
+	scenarioFile  = flag.String("scenario", "", "Path to the scenario of the simulated BMC.")
+)
+
+func main() {
+	flag.Parse()
+
+	if *scenarioFile == "" {
+		log.Fatal("No scenario given, use -scenario")
+	}
+	scenario, err := simulator.LoadScenario(*scenarioFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sim, err := simulator.New(scenario)
+	if err != nil {
+		log.Fatalf("Invalid scenario %s: %v", *scenarioFile, err)
+	}
+
+	log.Infof("Simulating %s on %s", sim, *listenAddress)
+	if err := sim.ListenAndServe(*listenAddress); err != nil {
+		log.Fatal(err)
+	}
+}
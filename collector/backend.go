@@ -0,0 +1,251 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/log"
+)
+
+// Backend runs IPMI commands against a node and returns their output as
+// printed by ipmitool.
+type Backend interface {
+	Output(args ...string) ([]byte, error)
+}
+
+// IPMITool is a Backend calling the ipmitool binary. Without a Host the
+// local BMC is queried, otherwise the remote BMC is queried over Interface.
+//
+// Passwords are never passed on the command line where they would be visible
+// to other users: PasswordFile is passed with -f, and Password is passed to
+// ipmitool through the IPMI_PASSWORD environment variable with -E.
+type IPMITool struct {
+	Path string
+
+	Host         string
+	Port         string
+	Interface    string
+	Privilege    string
+	User         string
+	PasswordFile string
+	Password     string
+	// Timeout kills ipmitool if a command runs longer, e.g. because the
+	// BMC stopped answering. Commands are not limited if zero.
+	Timeout time.Duration
+	// SDRCache is a file caching the sensor data records of the BMC,
+	// passed to the sensor and sdr commands with -S so they read only the
+	// sensors from the BMC. It is dumped from the BMC if it is missing or
+	// older than SDRCacheMaxAge. The records are read with every command
+	// if empty.
+	SDRCache       string
+	SDRCacheMaxAge time.Duration
+
+	// Trace records the command lines, exit status and standard error of
+	// the commands if set. The password is redacted from the command lines
+	// and the standard error, other secrets must be added to the trace with
+	// Redact when it is attached.
+	Trace *Trace
+}
+
+// Output runs ipmitool with the given arguments and returns its standard
+// output. The standard error is appended to the error of failed commands,
+// with the password redacted.
+func (t *IPMITool) Output(args ...string) ([]byte, error) {
+	if t.SDRCache != "" && len(args) > 0 && (args[0] == "sensor" || args[0] == "sdr") {
+		// Without a cache, ipmitool reads the records from the BMC.
+		if err := t.dumpSDR(); err != nil {
+			t.Trace.Printf("Could not dump the sensor data records to %s: %v", t.SDRCache, err)
+		} else {
+			args = append([]string{"-S", t.SDRCache}, args...)
+		}
+	}
+	return t.run(args...)
+}
+
+// SDRCached returns the time the sensor data records were dumped to the
+// SDRCache, or the zero time if they are not cached.
+func (t *IPMITool) SDRCached() time.Time {
+	if t.SDRCache == "" {
+		return time.Time{}
+	}
+	fi, err := os.Stat(t.SDRCache)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+// dumpSDR dumps the sensor data records to the SDRCache unless it is younger
+// than SDRCacheMaxAge. The records are dumped to a temporary file first, so
+// concurrent commands read a complete cache.
+func (t *IPMITool) dumpSDR() error {
+	if cached := t.SDRCached(); !cached.IsZero() && time.Since(cached) < t.SDRCacheMaxAge {
+		return nil
+	}
+	f, err := ioutil.TempFile(filepath.Dir(t.SDRCache), filepath.Base(t.SDRCache)+".")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	f.Close()
+	defer os.Remove(tmp)
+	if _, err := t.run("sdr", "dump", tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.SDRCache)
+}
+
+func (t *IPMITool) run(args ...string) ([]byte, error) {
+	ctx := context.Background()
+	if t.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.Timeout)
+		defer cancel()
+	}
+	cmd := t.command(ctx, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if t.Trace != nil {
+		line := strings.Join(cmd.Args, " ")
+		if cmd.Env != nil && t.PasswordFile == "" && t.Password != "" {
+			line = "IPMI_PASSWORD=" + redacted + " " + line
+		}
+		t.Trace.Printf("Executing %s", line)
+	}
+
+	start := time.Now()
+	out, err := cmd.Output()
+	// ipmitool may print the password, e.g. when quoting the command
+	// line, so it is redacted before the error leaves the backend.
+	msg := strings.TrimSpace(stderr.String())
+	if t.Password != "" {
+		msg = strings.Replace(msg, t.Password, redacted, -1)
+	}
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %v", t.Timeout)
+	}
+	if err != nil && msg != "" {
+		err = fmt.Errorf("%v: %s", err, msg)
+	}
+	if t.Trace != nil {
+		status := "exit status 0"
+		if err != nil {
+			status = err.Error()
+		}
+		t.Trace.Printf("Finished after %v with %s", time.Since(start), status)
+		if msg != "" {
+			t.Trace.Printf("Standard error:")
+			t.Trace.Output([]byte(msg))
+		}
+	}
+	return out, err
+}
+
+func (t *IPMITool) command(ctx context.Context, args ...string) *exec.Cmd {
+	if t.Host == "" {
+		return exec.CommandContext(ctx, t.Path, args...)
+	}
+
+	var opts []string
+	if t.Interface != "" {
+		opts = append(opts, "-I", t.Interface)
+	}
+	opts = append(opts, "-H", t.Host)
+	if t.Port != "" {
+		opts = append(opts, "-p", t.Port)
+	}
+	if t.User != "" {
+		opts = append(opts, "-U", t.User)
+	}
+	if t.Privilege != "" {
+		opts = append(opts, "-L", t.Privilege)
+	}
+	env := os.Environ()
+	if t.PasswordFile != "" {
+		opts = append(opts, "-f", t.PasswordFile)
+	} else if t.Password != "" {
+		opts = append(opts, "-E")
+		env = append(env, "IPMI_PASSWORD="+t.Password)
+	}
+
+	cmd := exec.CommandContext(ctx, t.Path, append(opts, args...)...)
+	cmd.Env = env
+	return cmd
+}
+
+// Replay is a Backend serving outputs recorded by a Recorder. The output of a
+// command is read from a file named after its arguments in Dir, see
+// FixtureName.
+type Replay struct {
+	Dir string
+}
+
+// Output returns the recorded output of the command with the given arguments.
+func (r *Replay) Output(args ...string) ([]byte, error) {
+	name := filepath.Join(r.Dir, FixtureName(args))
+
+	msg, err := ioutil.ReadFile(name + ".err")
+	if err == nil {
+		out, _ := ioutil.ReadFile(name + ".out")
+		return out, errors.New(strings.TrimSpace(string(msg)))
+	}
+
+	out, err := ioutil.ReadFile(name + ".out")
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no recorded output for %q", strings.Join(args, " "))
+	}
+	return out, err
+}
+
+// Recorder is a Backend passing commands to another backend and recording
+// their outputs in Dir for later use by Replay.
+type Recorder struct {
+	Backend Backend
+	Dir     string
+}
+
+// Output runs the command on the wrapped backend and records the result.
+func (r *Recorder) Output(args ...string) ([]byte, error) {
+	out, err := r.Backend.Output(args...)
+	if rerr := r.record(args, out, err); rerr != nil {
+		log.With("command", strings.Join(args, " ")).Errorf("could not record output: %v", rerr)
+	}
+	return out, err
+}
+
+func (r *Recorder) record(args []string, out []byte, cmdErr error) error {
+	if err := os.MkdirAll(r.Dir, 0755); err != nil {
+		return err
+	}
+	name := filepath.Join(r.Dir, FixtureName(args))
+
+	if err := ioutil.WriteFile(name+".out", out, 0644); err != nil {
+		return err
+	}
+	if cmdErr != nil {
+		return ioutil.WriteFile(name+".err", []byte(cmdErr.Error()+"\n"), 0644)
+	}
+	if err := os.Remove(name + ".err"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+var fixtureNameRegex = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// FixtureName returns the base name of the files recording the output of the
+// command with the given arguments, e.g. "raw_0x06_0x01" for "raw 0x06 0x01".
+// The standard output is stored with the suffix ".out"; failed commands
+// additionally have their error message stored with the suffix ".err".
+func FixtureName(args []string) string {
+	return fixtureNameRegex.ReplaceAllString(strings.Join(args, " "), "_")
+}
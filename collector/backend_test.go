@@ -0,0 +1,236 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeBackend map[string]string
+
+func (f fakeBackend) Output(args ...string) ([]byte, error) {
+	out, ok := f[strings.Join(args, " ")]
+	if !ok {
+		return nil, errors.New("exit status 1")
+	}
+	return []byte(out), nil
+}
+
+func TestFixtureName(t *testing.T) {
+	for args, want := range map[string]string{
+		"sensor":                             "sensor",
+		"mc info":                            "mc_info",
+		"raw 0x06 0x52 0x07 0x78 0x01 0x97":  "raw_0x06_0x52_0x07_0x78_0x01_0x97",
+		"sdr type 'Power Supply'":            "sdr_type_Power_Supply_",
+		"-c sdr elist full":                  "-c_sdr_elist_full",
+		"sel get 0x0001":                     "sel_get_0x0001",
+		"raw 0x06 0x52 0x07 0x78 0x01 0x97 ": "raw_0x06_0x52_0x07_0x78_0x01_0x97",
+	} {
+		if got := FixtureName(strings.Fields(args)); got != want {
+			t.Errorf("%q: want %q, got %q", args, want, got)
+		}
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipmi_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	recorder := &Recorder{
+		Backend: fakeBackend{"sensor": "CPU1 Temp | 33.000 | degrees C | ok\n"},
+		Dir:     dir,
+	}
+	if _, err := recorder.Output("sensor"); err != nil {
+		t.Fatalf("recording sensor failed: %v", err)
+	}
+	if _, err := recorder.Output("raw", "0x06", "0x01"); err == nil {
+		t.Fatal("want error for failing command")
+	}
+
+	replay := &Replay{Dir: dir}
+	out, err := replay.Output("sensor")
+	if err != nil {
+		t.Fatalf("replaying sensor failed: %v", err)
+	}
+	if string(out) != "CPU1 Temp | 33.000 | degrees C | ok\n" {
+		t.Errorf("unexpected replayed output %q", out)
+	}
+
+	_, err = replay.Output("raw", "0x06", "0x01")
+	if err == nil || err.Error() != "exit status 1" {
+		t.Errorf("want recorded error, got %v", err)
+	}
+
+	if _, err := replay.Output("mc", "info"); err == nil {
+		t.Error("want error for command without recording")
+	}
+
+	// A successful recording replaces a previously recorded error.
+	recorder.Backend = fakeBackend{"raw 0x06 0x01": "20 01"}
+	if _, err := recorder.Output("raw", "0x06", "0x01"); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := replay.Output("raw", "0x06", "0x01"); err != nil || string(out) != "20 01" {
+		t.Errorf("want re-recorded output, got %q, %v", out, err)
+	}
+}
+
+func TestIPMIToolCommand(t *testing.T) {
+	local := (&IPMITool{Path: "ipmitool"}).command(context.Background(), "sensor")
+	if strings.Join(local.Args, " ") != "ipmitool sensor" || local.Env != nil {
+		t.Errorf("unexpected local command %q", local.Args)
+	}
+
+	for _, tc := range []struct {
+		tool IPMITool
+		args string
+		env  string
+	}{
+		{
+			IPMITool{Path: "ipmitool", Host: "10.0.0.1", Interface: "lanplus", User: "ADMIN", PasswordFile: "/etc/ipmi/pass"},
+			"ipmitool -I lanplus -H 10.0.0.1 -U ADMIN -f /etc/ipmi/pass sensor",
+			"",
+		},
+		{
+			IPMITool{Path: "ipmitool", Host: "bmc1", Port: "6230", Interface: "lanplus", Privilege: "USER", User: "monitor", Password: "s3cret"},
+			"ipmitool -I lanplus -H bmc1 -p 6230 -U monitor -L USER -E sensor",
+			"IPMI_PASSWORD=s3cret",
+		},
+	} {
+		cmd := tc.tool.command(context.Background(), "sensor")
+		args := strings.Join(cmd.Args, " ")
+		if args != tc.args {
+			t.Errorf("want command %q, got %q", tc.args, args)
+		}
+		if strings.Contains(args, "s3cret") {
+			t.Errorf("password on the command line: %q", args)
+		}
+		if tc.env != "" && cmd.Env[len(cmd.Env)-1] != tc.env {
+			t.Errorf("want %s in the environment of %q", tc.env, args)
+		}
+	}
+}
+
+func TestIPMIToolTrace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipmitool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "ipmitool")
+	err = ioutil.WriteFile(script, []byte("#!/bin/sh\necho \"Error: session failed for $IPMI_PASSWORD\" >&2\nexit 1\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trace := NewTrace()
+	trace.Redact("s3cret")
+	tool := &IPMITool{Path: script, Host: "bmc1", User: "monitor", Password: "s3cret", Trace: trace}
+	_, err = tool.Output("sensor")
+	if err == nil || err.Error() != "exit status 1: Error: session failed for <redacted>" {
+		t.Errorf("want error with redacted standard error, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	trace.WriteTo(&buf)
+	for _, want := range []string{
+		"Executing IPMI_PASSWORD=<redacted> " + script + " -H bmc1 -U monitor -E sensor",
+		"with exit status 1: Error: session failed for <redacted>",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("want %q in trace:\n%s", want, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), "s3cret") {
+		t.Errorf("password in trace:\n%s", buf.String())
+	}
+}
+
+func TestIPMIToolTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipmitool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "ipmitool")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\nexec sleep 10\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err = (&IPMITool{Path: script, Timeout: 100 * time.Millisecond}).Output("sensor")
+	if err == nil || err.Error() != "timed out after 100ms" {
+		t.Errorf("want timeout, got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("want ipmitool killed after the timeout, returned after %v", d)
+	}
+}
+
+func TestIPMIToolSDRCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipmitool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "ipmitool")
+	calls := filepath.Join(dir, "calls")
+	err = ioutil.WriteFile(script, []byte(`#!/bin/sh
+echo "$@" >> `+calls+`
+if [ "$1" = sdr ] && [ "$2" = dump ]; then
+	echo records > "$3"
+fi
+`), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := filepath.Join(dir, "bmc1.sdr")
+	tool := &IPMITool{Path: script, SDRCache: cache, SDRCacheMaxAge: time.Hour}
+	if !tool.SDRCached().IsZero() {
+		t.Error("want no SDR cache before the first command")
+	}
+	for _, args := range [][]string{{"sensor"}, {"sdr", "elist"}, {"mc", "info"}} {
+		if _, err := tool.Output(args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf, err := ioutil.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(buf)), "\n")
+	want := []string{"sdr dump", "-S " + cache + " sensor", "-S " + cache + " sdr elist", "mc info"}
+	if len(lines) != len(want) {
+		t.Fatalf("want commands %q, got %q", want, lines)
+	}
+	for i := range want {
+		if !strings.HasPrefix(lines[i], want[i]) {
+			t.Errorf("want command %q, got %q", want[i], lines[i])
+		}
+	}
+	if cached := tool.SDRCached(); time.Since(cached) > time.Minute {
+		t.Errorf("want SDR cached now, got %v", cached)
+	}
+
+	// Expired caches are dumped again.
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(cache, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tool.Output("sensor"); err != nil {
+		t.Fatal(err)
+	}
+	if cached := tool.SDRCached(); !cached.After(old) {
+		t.Errorf("want SDR dumped again, cached at %v", cached)
+	}
+}
@@ -0,0 +1,80 @@
+package collector
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CollectorBoot exposes the boot device override of the system boot options.
+const CollectorBoot = "boot"
+
+var bootOptionsInfo = newDesc(
+	"boot_options", "info",
+	"Boot device override of the system boot options",
+	[]string{"device", "persistent", "efi"},
+)
+
+// bootDevices are the boot device selectors of the boot flags.
+var bootDevices = map[byte]string{
+	0x0: "No override",
+	0x1: "PXE",
+	0x2: "Hard Drive",
+	0x3: "Hard Drive, Safe Mode",
+	0x4: "Diagnostic Partition",
+	0x5: "CD/DVD",
+	0x6: "BIOS Setup",
+	0x7: "Remote Floppy",
+	0x8: "Remote CD/DVD",
+	0x9: "Primary Remote Media",
+	0xb: "Remote Hard Drive",
+	0xf: "Floppy",
+}
+
+func init() {
+	optionalCollectors[CollectorBoot] = &optionalCollector{
+		descs:   []*prometheus.Desc{bootOptionsInfo},
+		collect: collectBoot,
+	}
+}
+
+// bootFlags is the boot flags parameter of Get System Boot Options.
+type bootFlags struct {
+	device     string
+	persistent bool
+	efi        bool
+}
+
+func parseBootFlags(output []byte) (*bootFlags, error) {
+	b, err := parseRaw(output)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse boot flags: %v", err)
+	}
+	if len(b) < 7 || b[1]&0x7f != 0x05 {
+		return nil, fmt.Errorf("want boot flags parameter, got %q", output)
+	}
+	flags := b[2:]
+	// Without the valid bit the flags are ignored by the BIOS.
+	if flags[0]&0x80 == 0 {
+		return &bootFlags{device: bootDevices[0]}, nil
+	}
+	return &bootFlags{
+		device:     lookupName(bootDevices, flags[1]>>2&0x0f),
+		persistent: flags[0]&0x40 != 0,
+		efi:        flags[0]&0x20 != 0,
+	}, nil
+}
+
+func collectBoot(e *Exporter, ch chan<- prometheus.Metric) error {
+	output, err := e.ipmiOutput("raw 0x00 0x09 0x05 0x00 0x00")
+	if err != nil {
+		return err
+	}
+	f, err := parseBootFlags(output)
+	if err != nil {
+		return err
+	}
+	ch <- prometheus.MustNewConstMetric(bootOptionsInfo, prometheus.GaugeValue, 1, f.device, strconv.FormatBool(f.persistent), strconv.FormatBool(f.efi))
+	return nil
+}
@@ -0,0 +1,104 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SensorPart is the name of the sensor reading in the parts of a Collection.
+const SensorPart = "sensor"
+
+// errDisabled is the result of an optional collector the BMC does not
+// support.
+var errDisabled = errors.New("disabled as unsupported by the BMC")
+
+var (
+	up = newDesc(
+		"", "up",
+		"Indicates if the sensors of the BMC could be read in the last collection",
+		nil,
+	)
+	collectorSuccess = newDesc(
+		"collector", "success",
+		"Indicates if an optional collector succeeded in the last collection, not exposed for disabled collectors",
+		[]string{"collector"},
+	)
+	collectorDisabled = newDesc(
+		"collector", "disabled",
+		"Indicates if an optional collector is disabled as unsupported by the BMC",
+		[]string{"collector"},
+	)
+)
+
+// Collection is the result of a collection of the metrics of an exporter.
+type Collection struct {
+	Start    time.Time
+	Duration time.Duration
+	// Parts are the errors of the parts of the collection, nil if the part
+	// succeeded, by SensorPart or the name of an optional collector.
+	Parts map[string]error
+	// SDRCached is the time the sensor data records read were cached,
+	// zero if the backend does not cache them.
+	SDRCached time.Time
+	// Trace of the collection if Exporter.TraceCollections is set.
+	Trace *Trace
+}
+
+// sdrCacher is implemented by backends caching the sensor data records.
+type sdrCacher interface {
+	SDRCached() time.Time
+}
+
+// Err returns an error describing the failed parts, or nil if all parts
+// succeeded.
+func (c *Collection) Err() error {
+	var failed []string
+	for name, err := range c.Parts {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	sort.Strings(failed)
+	if len(failed) == 1 {
+		return errors.New(failed[0])
+	}
+	return fmt.Errorf("%d parts failed, %s", len(failed), failed[0])
+}
+
+// collect sends the results of the parts of the collection as metrics. The
+// success of disabled collectors is not exposed, so they do not alert as
+// failed forever on BMCs lacking their commands.
+func (c *Collection) collect(ch chan<- prometheus.Metric) {
+	for name, err := range c.Parts {
+		value := boolValue(err == nil)
+		if name == SensorPart {
+			ch <- prometheus.MustNewConstMetric(up, prometheus.GaugeValue, value)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(collectorDisabled, prometheus.GaugeValue, boolValue(err == errDisabled), name)
+		if err != errDisabled {
+			ch <- prometheus.MustNewConstMetric(collectorSuccess, prometheus.GaugeValue, value, name)
+		}
+	}
+}
+
+// LastCollection returns the result of the last completed collection, or
+// nil if the exporter has not been collected yet.
+func (e *Exporter) LastCollection() *Collection {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.last
+}
+
+func (e *Exporter) setLastCollection(c *Collection) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.last = c
+}
@@ -5,10 +5,15 @@ import (
 	"encoding/binary"
 	"encoding/csv"
 	"encoding/hex"
-	"os/exec"
+	"fmt"
+	"math"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/lovoo/ipmi_exporter/logging"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
@@ -18,46 +23,96 @@ type metric struct {
 	metricsname string
 	value       float64
 	unit        string
+	family      string
 }
 
 // Exporter implements the prometheus.Collector interface. It exposes the metrics
 // of a ipmi node.
 type Exporter struct {
-	IPMIBinary string
+	Backend Backend
+	// Profile is the name of the vendor profile to use. If empty, the
+	// profile is selected by the manufacturer ID reported by the BMC.
+	Profile string
+	// Profiles are the vendor profiles available for selection.
+	Profiles []*Profile
+	// Collectors are the names of the optional collectors to run, see
+	// CollectorNames.
+	Collectors []string
+	// OnCollect is called with the result of each collection, e.g. to show
+	// it on a status page.
+	OnCollect func(*Collection)
+	// Trace records the commands and parse decisions of the collections if
+	// set.
+	Trace *Trace
+	// TraceCollections records a trace of each collection in its
+	// Collection. The collections are run one at a time then.
+	TraceCollections bool
+	// Logger logs the errors of the exporter, usually with the target and
+	// module as fields. Errors repeated by a command are logged at most once
+	// per logging.RepeatInterval.
+	Logger log.Logger
 
 	namespace string
-}
 
-var rawSensors = [][]string{
-	{"InputPowerPSU1", " raw 0x06 0x52 0x07 0x78 0x01 0x97", "W", "enabled"},
-	{"InputPowerPSU2", " raw 0x06 0x52 0x07 0x7a 0x01 0x97", "W", "enabled"},
+	mu       sync.Mutex
+	profile  *Profile
+	disabled map[string]bool
+	energy   *EnergyMeter
+	last     *Collection
+	errors   *logging.Limiter
+	// collecting serializes the traced collections, whose trace is trace.
+	// The trace is guarded by traceMu as e.g. vendorProfile traces with mu
+	// held.
+	collecting sync.Mutex
+	traceMu    sync.Mutex
+	trace      *Trace
 }
 
-// NewExporter instantiates a new ipmi Exporter.
-func NewExporter(ipmiBinary string) *Exporter {
+// NewExporter instantiates a new ipmi Exporter running its commands on the
+// given backend.
+func NewExporter(backend Backend) *Exporter {
 	return &Exporter{
-		IPMIBinary: ipmiBinary,
-		namespace:  "ipmi",
+		Backend:   backend,
+		Profiles:  DefaultProfiles(),
+		Logger:    log.Base(),
+		namespace: "ipmi",
+		disabled:  make(map[string]bool),
+		errors:    logging.NewLimiter(),
 	}
 }
 
-func ipmiOutput(cmd string) ([]byte, error) {
-	parts := strings.Fields(cmd)
-	out, err := exec.Command(parts[0], parts[1:]...).Output()
+// ipmiOutput runs cmd on the backend. Its errors are logged here and
+// returned as loggedError, so callers only log errors of their own.
+func (e *Exporter) ipmiOutput(cmd string) ([]byte, error) {
+	e.tracef("Running %q", cmd)
+	out, err := e.Backend.Output(strings.Fields(cmd)...)
 	if err != nil {
-		log.Errorf("error while calling ipmitool: %v", err)
+		e.tracef("Command %q failed: %v", cmd, err)
+		e.logError(cmd, cmd, err)
+		err = loggedError{err}
+	} else {
+		e.errors.Reset(cmd)
 	}
+	e.Trace.Output(out)
+	e.collectionTrace().Output(out)
 	return out, err
 }
 
+// logError logs an error of cmd, or of parsing its output, unless it is
+// repeated for key. Commands reset their key when they succeed, so errors of
+// parsing their output use a different key.
+func (e *Exporter) logError(cmd, key string, err error) {
+	if logger, ok := e.errors.Allow(e.Logger.With("command", cmd), key, err); ok {
+		logger.Error(err)
+	}
+}
+
 func convertValue(strfloat string, strunit string) (value float64, err error) {
 	if strfloat != "na" {
 		if strunit == "discrete" {
 			strfloat = strings.Replace(strfloat, "0x", "", -1)
-			parsedValue, err := strconv.ParseUint(strfloat, 16, 32)
-			if err != nil {
-				log.Errorf("could not translate hex: %v, %v", parsedValue, err)
-			}
+			var parsedValue uint64
+			parsedValue, err = strconv.ParseUint(strfloat, 16, 32)
 			value = float64(parsedValue)
 		} else {
 			value, err = strconv.ParseFloat(strfloat, 64)
@@ -66,17 +121,18 @@ func convertValue(strfloat string, strunit string) (value float64, err error) {
 	return value, err
 }
 
+// convertOutput converts the sensors of ipmitool sensor. Sensors whose value
+// cannot be parsed read 0, the error is the first of these errors.
 func convertOutput(result [][]string) (metrics []metric, err error) {
 	for _, res := range result {
-		var value float64
 		var currentMetric metric
 
 		for n := range res {
 			res[n] = strings.TrimSpace(res[n])
 		}
-		value, err = convertValue(res[1], res[2])
-		if err != nil {
-			log.Errorf("could not parse ipmi output: %s", err)
+		value, verr := convertValue(res[1], res[2])
+		if verr != nil && err == nil {
+			err = fmt.Errorf("could not parse value %q of sensor %q: %v", res[1], res[0], verr)
 		}
 
 		currentMetric.value = value
@@ -91,15 +147,14 @@ func convertOutput(result [][]string) (metrics []metric, err error) {
 // Convert raw IPMI tool output to decimal numbers
 func convertRawOutput(result [][]string) (metrics []metric, err error) {
 	for _, res := range result {
-		var value []byte
 		var currentMetric metric
 
 		for n := range res {
 			res[n] = strings.TrimSpace(res[n])
 		}
-		value, err := hex.DecodeString(res[1])
-		if err != nil {
-			log.Errorf("could not parse ipmi output: %s", err)
+		value, derr := hex.DecodeString(res[1])
+		if derr != nil && err == nil {
+			err = fmt.Errorf("could not parse output %q of raw command %s: %v", res[1], res[0], derr)
 		}
 		r, _ := binary.Uvarint(value)
 		currentMetric.value = float64(r)
@@ -117,8 +172,7 @@ func splitOutput(impiOutput []byte) ([][]string, error) {
 	r.Comment = '#'
 	result, err := r.ReadAll()
 	if err != nil {
-		log.Errorf("could not parse ipmi output: %v", err)
-		return result, err
+		return result, fmt.Errorf("could not parse ipmi output: %v", err)
 	}
 
 	keys := make(map[string]int)
@@ -144,77 +198,221 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- intrusion
 	ch <- powersupply
 	ch <- current
+	ch <- energy
+	ch <- up
+	ch <- collectorSuccess
+	ch <- collectorDisabled
+	for _, d := range psuDescs {
+		ch <- d
+	}
+	for _, c := range optionalCollectors {
+		for _, d := range c.descs {
+			ch <- d
+		}
+	}
 }
 
 // Collect collects all the registered stats metrics from the ipmi node.
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	output, err := ipmiOutput(e.IPMIBinary + " sensor")
-	if err != nil {
-		log.Errorln(err)
+	c := &Collection{Start: time.Now(), Parts: map[string]error{}}
+	if e.TraceCollections {
+		e.collecting.Lock()
+		defer e.collecting.Unlock()
+		c.Trace = NewTrace()
+		e.setCollectionTrace(c.Trace)
+		defer e.setCollectionTrace(nil)
+	}
+	metrics, err := e.metrics()
+	c.Parts[SensorPart] = err
+	for _, res := range metrics {
+		pushFamily(ch, res.family, res)
+	}
+	e.collectPSUs(ch, e.vendorProfile())
+	e.collectOptional(ch, c)
+	if m := e.energyMeter(); m != nil {
+		m.collect(ch)
+	}
+	c.Duration = time.Since(c.Start)
+	if b, ok := e.Backend.(sdrCacher); ok {
+		c.SDRCached = b.SDRCached()
 	}
+	c.collect(ch)
+	e.setLastCollection(c)
+	if e.OnCollect != nil {
+		e.OnCollect(c)
+	}
+}
+
+// metrics reads the sensors and the raw commands of the vendor profile and
+// returns the metrics of a known family. The error is the first error of
+// reading the sensors.
+func (e *Exporter) metrics() ([]metric, error) {
+	profile := e.vendorProfile()
+
+	output, sensorErr := e.ipmiOutput("sensor")
 	splitted, err := splitOutput(output)
-	if err != nil {
-		log.Errorln(err)
+	if err != nil && sensorErr == nil {
+		e.logError("sensor", "sensor/parse", err)
+		sensorErr = err
 	}
 	convertedOutput, err := convertOutput(splitted)
-	if err != nil {
-		log.Errorln(err)
+	if err != nil && sensorErr == nil {
+		e.logError("sensor", "sensor/parse", err)
+		sensorErr = err
 	}
 
-	psRegex := regexp.MustCompile("PS(.*) Status")
-
+	var metrics []metric
+	intrusionIndex := -1
 	for _, res := range convertedOutput {
-		push := func(m *prometheus.Desc) {
-			ch <- prometheus.MustNewConstMetric(m, prometheus.GaugeValue, res.value, res.metricsname)
+		res.family = profile.family(res.metricsname, res.unit)
+		if res.family == "" {
+			e.tracef("Sensor %q with unit %q matches no family of profile %s, skipping it", res.metricsname, res.unit, profile.Name)
+			continue
 		}
-		switch strings.ToLower(res.unit) {
-		case "degrees c":
-			push(temperatures)
-		case "volts":
-			push(voltages)
-		case "rpm":
-			push(fanspeed)
-		case "watts":
-			push(powersupply)
-		case "amps":
-			push(current)
+		e.tracef("Sensor %q with unit %q reads %v, family %s", res.metricsname, res.unit, res.value, res.family)
+		// The intrusion metric has no sensor label, so several intrusion
+		// sensors are combined into the highest reading.
+		if res.family == FamilyIntrusion {
+			if intrusionIndex >= 0 {
+				metrics[intrusionIndex].value = math.Max(metrics[intrusionIndex].value, res.value)
+				continue
+			}
+			intrusionIndex = len(metrics)
 		}
+		metrics = append(metrics, res)
+	}
+
+	return append(metrics, e.rawMetrics(profile)...), sensorErr
+}
+
+func pushFamily(ch chan<- prometheus.Metric, family string, res metric) {
+	if family == FamilyIntrusion {
+		ch <- prometheus.MustNewConstMetric(intrusion, prometheus.GaugeValue, res.value)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(familyDescs[family], prometheus.GaugeValue, res.value, res.metricsname)
+}
 
-		if matches := psRegex.MatchString(res.metricsname); matches {
-			push(powersupply)
-		} else if strings.HasSuffix(res.metricsname, "Chassis Intru") {
-			ch <- prometheus.MustNewConstMetric(intrusion, prometheus.GaugeValue, res.value)
+// vendorProfile returns the profile used for the ipmi node. Unless a profile
+// is configured, it is selected once by the manufacturer ID of the BMC.
+func (e *Exporter) vendorProfile() *Profile {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.profile != nil {
+		return e.profile
+	}
+
+	if e.Profile != "" {
+		if p := findProfile(e.Profiles, e.Profile); p != nil {
+			e.tracef("Using configured vendor profile %s", p.Name)
+			e.profile = p
+			return p
 		}
+		e.Logger.With("profile", e.Profile).Errorf("Unknown vendor profile, using %s", GenericProfile)
+		e.profile = profileForManufacturer(e.Profiles, 0)
+		return e.profile
 	}
 
-	e.collectRaws(ch)
+	// Without a manufacturer ID, try again on the next scrape.
+	output, err := e.ipmiOutput("mc info")
+	if err != nil {
+		e.tracef("Could not read the manufacturer ID, using profile %s for this collection", GenericProfile)
+		return profileForManufacturer(e.Profiles, 0)
+	}
+	id, err := parseManufacturerID(output)
+	if err != nil {
+		e.logError("mc info", "mc info/parse", err)
+		e.tracef("Could not parse the manufacturer ID, using profile %s for this collection", GenericProfile)
+		return profileForManufacturer(e.Profiles, 0)
+	}
+	e.profile = profileForManufacturer(e.Profiles, id)
+	e.tracef("Using vendor profile %s for manufacturer ID %d", e.profile.Name, id)
+	e.Logger.With("profile", e.profile.Name).Infof("Using vendor profile for manufacturer ID %d", id)
+	return e.profile
 }
 
-// Collect some Supermicro X8-specific metrics with raw commands
-func (e *Exporter) collectRaws(ch chan<- prometheus.Metric) {
+// Collect the OEM metrics of the vendor profile with raw commands
+func (e *Exporter) rawMetrics(profile *Profile) []metric {
 	results := [][]string{}
-	for i, command := range rawSensors {
-		if command[3] == "enabled" {
-			output, err := ipmiOutput(e.IPMIBinary + command[1])
-			if err != nil {
-				log.Infof("Error detected on quering %v. Disabling this sensor.", command[1])
-				rawSensors[i][3] = "disabled"
-				log.Errorln(err)
-				continue
-			}
-
-			results = append(results, []string{command[0], string(output), command[2]})
+	families := map[string]string{}
+	for _, command := range profile.RawCommands {
+		if e.rawDisabled(command.Name) {
+			e.tracef("Skipping raw command %s disabled after an earlier error", command.Name)
+			continue
+		}
+		output, err := e.ipmiOutput(command.Command)
+		if err != nil {
+			e.tracef("Disabling raw command %s", command.Name)
+			e.Logger.With("command", command.Command).Infof("Disabling raw command %s after an error", command.Name)
+			e.disableRaw(command.Name)
+			continue
 		}
+
+		results = append(results, []string{command.Name, string(output), command.Unit})
+		families[command.Name] = command.Family
 	}
 
 	convertedRawOutput, err := convertRawOutput(results)
 	if err != nil {
-		log.Errorln(err)
+		e.logError("raw", "raw/parse", err)
+	}
+	for i := range convertedRawOutput {
+		convertedRawOutput[i].family = families[convertedRawOutput[i].metricsname]
+	}
+	return convertedRawOutput
+}
+
+func (e *Exporter) rawDisabled(name string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.disabled[name]
+}
+
+func (e *Exporter) disableRaw(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.disabled[name] = true
+}
+
+// loggedError is an error that has already been logged with its command.
+type loggedError struct{ error }
+
+// unsupportedError is an error that does not go away on a retry, e.g. a
+// register in a format the exporter cannot decode.
+type unsupportedError string
+
+func (e unsupportedError) Error() string { return string(e) }
+
+var completionCodeRegex = regexp.MustCompile(`rsp=0x([0-9a-fA-F]{2})\)`)
+
+// unsupportedMessages are printed by ipmitool for the completion codes of
+// requests the BMC does not implement: 0xc1, 0xc9 and 0xcc.
+var unsupportedMessages = []string{
+	"Invalid command",
+	"Parameter out of range",
+	"Invalid data field in request",
+}
+
+// unsupported reports whether err tells that the BMC does not support a
+// command, as opposed to errors that may go away on the next scrape like
+// timeouts, lost sessions or a busy BMC.
+func unsupported(err error) bool {
+	if _, ok := err.(unsupportedError); ok {
+		return true
+	}
+	msg := err.Error()
+	if m := completionCodeRegex.FindStringSubmatch(msg); m != nil {
+		switch strings.ToLower(m[1]) {
+		case "c1", "c9", "cc":
+			return true
+		}
+		return false
 	}
-	for _, res := range convertedRawOutput {
-		push := func(m *prometheus.Desc) {
-			ch <- prometheus.MustNewConstMetric(m, prometheus.GaugeValue, res.value, res.metricsname)
+	for _, s := range unsupportedMessages {
+		if strings.Contains(msg, s) {
+			return true
 		}
-		push(powersupply)
 	}
+	return false
 }
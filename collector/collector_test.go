@@ -1,18 +1,23 @@
 package collector
 
 import (
-	"fmt"
+	"bytes"
+	"errors"
+	"flag"
 	"io/ioutil"
-	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/log"
 )
 
+var update = flag.Bool("update", false, "update the golden files of the tests")
+
 func TestCollector(t *testing.T) {
-	f, err := os.Open("testdata/ipmi_output1.txt")
-	if err != nil {
-		t.Fatalf("cannot open test data: %v", err)
-	}
-	buf, err := ioutil.ReadAll(f)
+	buf, err := ioutil.ReadFile("testdata/fixtures/supermicro/sensor.out")
 	if err != nil {
 		t.Fatalf("reading test data failed: %v", err)
 	}
@@ -22,5 +27,246 @@ func TestCollector(t *testing.T) {
 		t.Fatalf("parsing output failed: %v", err)
 	}
 
-	fmt.Println(res)
+	if len(res) != 66 {
+		t.Fatalf("want 66 sensors, got %d", len(res))
+	}
+	if len(res[0]) != 10 {
+		t.Errorf("want 10 columns, got %d", len(res[0]))
+	}
+	// Duplicate sensor names get a running number appended.
+	if res[61][0] != "HDD Status2" {
+		t.Errorf("want renamed duplicate sensor, got %q", res[61][0])
+	}
+}
+
+// TestCollectGolden runs the exporter against the recorded outputs in each
+// directory of testdata/fixtures and compares the exposed metrics to the
+// metrics.prom file of the directory. Run the tests with -update to rewrite
+// the golden files after an intended change.
+func TestCollectGolden(t *testing.T) {
+	dirs, err := filepath.Glob("testdata/fixtures/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) == 0 {
+		t.Fatal("no fixtures found")
+	}
+
+	for _, dir := range dirs {
+		exporter := NewExporter(&Replay{Dir: dir})
+		exporter.Collectors = CollectorNames()
+		got, err := collectText(exporter)
+		if err != nil {
+			t.Errorf("%s: collecting metrics failed: %v", dir, err)
+			continue
+		}
+
+		golden := filepath.Join(dir, "metrics.prom")
+		if *update {
+			if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Errorf("%s: reading golden file failed: %v", dir, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: metrics differ from %s:\n--- got:\n%s\n--- want:\n%s", dir, golden, got, want)
+		}
+	}
+}
+
+// collectText registers c in a new registry and returns the gathered metrics
+// in the text exposition format.
+func collectText(c prometheus.Collector) ([]byte, error) {
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(c); err != nil {
+		return nil, err
+	}
+	mfs, err := reg.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	for _, mf := range mfs {
+		if _, err := expfmt.MetricFamilyToText(&buf, mf); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// collectionMetrics exposes the metrics of a collection.
+type collectionMetrics struct{ c *Collection }
+
+func (m collectionMetrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- up
+	ch <- collectorSuccess
+	ch <- collectorDisabled
+}
+
+func (m collectionMetrics) Collect(ch chan<- prometheus.Metric) { m.c.collect(ch) }
+
+func TestCollectionMetrics(t *testing.T) {
+	out, err := collectText(collectionMetrics{&Collection{Parts: map[string]error{
+		SensorPart:        nil,
+		CollectorSEL:      errors.New("exit status 1: Unable to establish IPMI v2 / RMCP+ session"),
+		CollectorWatchdog: errDisabled,
+		CollectorLAN:      nil,
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"ipmi_up 1",
+		`ipmi_collector_success{collector="sel"} 0`,
+		`ipmi_collector_success{collector="lan"} 1`,
+		`ipmi_collector_disabled{collector="watchdog"} 1`,
+		`ipmi_collector_disabled{collector="sel"} 0`,
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("want %s in metrics:\n%s", want, out)
+		}
+	}
+	if strings.Contains(string(out), `ipmi_collector_success{collector="watchdog"}`) {
+		t.Errorf("want no success of the disabled collector:\n%s", out)
+	}
+}
+
+func TestLastCollection(t *testing.T) {
+	exporter := NewExporter(&Replay{Dir: "testdata/fixtures/supermicro"})
+	exporter.Collectors = []string{CollectorSEL}
+	if exporter.LastCollection() != nil {
+		t.Fatal("want no collection before the first scrape")
+	}
+	var observed *Collection
+	exporter.OnCollect = func(c *Collection) {
+		observed = c
+	}
+	if _, err := collectText(exporter); err != nil {
+		t.Fatal(err)
+	}
+	c := exporter.LastCollection()
+	if c == nil || observed != c {
+		t.Errorf("want last collection %v passed to OnCollect, got %v", c, observed)
+	}
+	if err := c.Err(); err != nil {
+		t.Errorf("want successful collection, got %v", err)
+	}
+	if _, ok := c.Parts[CollectorSEL]; !ok || len(c.Parts) != 2 {
+		t.Errorf("want sensor and sel parts, got %v", c.Parts)
+	}
+
+	exporter = NewExporter(&Replay{Dir: "testdata/fixtures/missing"})
+	exporter.Collectors = []string{CollectorSEL}
+	for i := 0; i < 2; i++ {
+		if _, err := collectText(exporter); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c = exporter.LastCollection()
+	if c.Parts[SensorPart] == nil || c.Parts[CollectorSEL] == nil || c.Parts[CollectorSEL] == errDisabled {
+		t.Errorf("want failed sensor and sel parts, got %v", c.Parts)
+	}
+	if c.Err() == nil {
+		t.Error("want collection error")
+	}
+}
+
+func TestTrace(t *testing.T) {
+	exporter := NewExporter(&Replay{Dir: "testdata/fixtures/supermicro"})
+	exporter.Collectors = []string{CollectorSEL}
+	exporter.Trace = NewTrace()
+	if _, err := collectText(exporter); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	exporter.Trace.WriteTo(&buf)
+	for _, want := range []string{
+		`Running "mc info"`,
+		"Using vendor profile supermicro for manufacturer ID 10876",
+		`  | CPU1 Temp        | 33.000     | degrees C`,
+		`Sensor "CPU1 Temp" with unit "degrees C" reads 33, family temperature`,
+		"Disabling raw command InputPowerPSU2",
+		"Running the sel collector",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("want %q in trace:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestTraceCollections(t *testing.T) {
+	exporter := NewExporter(&Replay{Dir: "testdata/fixtures/supermicro"})
+	exporter.TraceCollections = true
+	var traces []*Trace
+	exporter.OnCollect = func(c *Collection) {
+		traces = append(traces, c.Trace)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := collectText(exporter); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(traces) != 2 || traces[0] == traces[1] {
+		t.Fatalf("want a trace per collection, got %v", traces)
+	}
+
+	var first, second bytes.Buffer
+	traces[0].WriteTo(&first)
+	traces[1].WriteTo(&second)
+	if !strings.Contains(first.String(), `Running "mc info"`) {
+		t.Errorf("want the vendor profile detection in the first trace:\n%s", first.String())
+	}
+	if strings.Contains(second.String(), `Running "mc info"`) || !strings.Contains(second.String(), `Running "sensor"`) {
+		t.Errorf("want only the commands of the second collection in its trace:\n%s", second.String())
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	exporter := NewExporter(&Replay{Dir: "testdata/fixtures/missing"})
+	exporter.Collectors = []string{CollectorSEL}
+	exporter.Logger = log.NewLogger(&buf).With("target", "node1")
+	for i := 0; i < 3; i++ {
+		if _, err := collectText(exporter); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := strings.Count(buf.String(), "command=sensor"); n != 1 {
+		t.Errorf("want the repeated sensor error logged once, got %d times:\n%s", n, buf.String())
+	}
+	if n := strings.Count(buf.String(), `command="sel info"`); n != 1 {
+		t.Errorf("want the failing sel command logged in 1 line, got %d:\n%s", n, buf.String())
+	}
+	if strings.Contains(buf.String(), "collector=sel") {
+		t.Errorf("want the command error not logged again by the collector:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "target=node1") {
+		t.Errorf("want %q in log:\n%s", "target=node1", buf.String())
+	}
+
+	buf.Reset()
+	exporter = NewExporter(backendFunc(func(args ...string) ([]byte, error) {
+		if strings.Join(args, " ") == "sel info" {
+			return []byte("Entries : many\n"), nil
+		}
+		return nil, nil
+	}))
+	exporter.Profile = GenericProfile
+	exporter.Collectors = []string{CollectorSEL}
+	exporter.Logger = log.NewLogger(&buf)
+	for i := 0; i < 3; i++ {
+		if _, err := collectText(exporter); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := strings.Count(buf.String(), "The collector failed"); n != 1 {
+		t.Errorf("want the repeated parse error logged once, got %d times:\n%s", n, buf.String())
+	}
 }
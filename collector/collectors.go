@@ -0,0 +1,125 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// optionalCollector collects metrics in addition to the sensors if it is
+// enabled in Exporter.Collectors.
+type optionalCollector struct {
+	descs   []*prometheus.Desc
+	collect func(e *Exporter, ch chan<- prometheus.Metric) error
+}
+
+// optionalCollectors are the optional collectors by name.
+var optionalCollectors = map[string]*optionalCollector{}
+
+// CollectorNames returns the sorted names of the optional collectors.
+func CollectorNames() []string {
+	var names []string
+	for name := range optionalCollectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidateCollectors returns an error if one of names is not an optional
+// collector.
+func ValidateCollectors(names []string) error {
+	for _, name := range names {
+		if _, ok := optionalCollectors[name]; !ok {
+			return fmt.Errorf("unknown collector %q, valid collectors are %s", name, strings.Join(CollectorNames(), ", "))
+		}
+	}
+	return nil
+}
+
+// collectOptional runs the enabled optional collectors and records their
+// results in the collection. A collector whose commands the BMC rejects as
+// unsupported is not run again, like the raw commands of the vendor profiles.
+// Other errors are retried on the next collection. Errors of the commands are
+// logged by ipmiOutput, so only the errors of parsing their output are logged
+// here.
+func (e *Exporter) collectOptional(ch chan<- prometheus.Metric, collection *Collection) {
+	for _, name := range e.Collectors {
+		c, ok := optionalCollectors[name]
+		if !ok {
+			continue
+		}
+		key := "collector/" + name
+		if e.rawDisabled(key) {
+			e.tracef("Skipping the %s collector disabled as unsupported", name)
+			collection.Parts[name] = errDisabled
+			continue
+		}
+		e.tracef("Running the %s collector", name)
+		err := c.collect(e, ch)
+		collection.Parts[name] = err
+		switch {
+		case err == nil:
+			e.errors.Reset(key)
+		case unsupported(err):
+			e.tracef("The %s collector is not supported, disabling it: %v", name, err)
+			e.Logger.With("collector", name).Infof("Disabling the unsupported collector: %v", err)
+			e.disableRaw(key)
+		default:
+			e.tracef("The %s collector failed, retrying on the next collection: %v", name, err)
+			if _, ok := err.(loggedError); ok {
+				continue
+			}
+			if logger, ok := e.errors.Allow(e.Logger.With("collector", name), key, err); ok {
+				logger.Errorf("The collector failed: %v", err)
+			}
+		}
+	}
+}
+
+// parseRaw parses the hex bytes printed by `ipmitool raw`.
+func parseRaw(output []byte) ([]byte, error) {
+	fields := strings.Fields(string(output))
+	b := make([]byte, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseUint(f, 16, 8)
+		if err != nil {
+			return nil, err
+		}
+		b[i] = byte(v)
+	}
+	return b, nil
+}
+
+// parseFields parses the "key : value" lines printed by ipmitool. Values
+// continued on lines without a key are joined with newlines.
+func parseFields(output []byte) map[string]string {
+	values := map[string]string{}
+	var key string
+	for _, line := range strings.Split(string(output), "\n") {
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		k, v := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if k == "" {
+			if key != "" {
+				values[key] += "\n" + v
+			}
+			continue
+		}
+		key = k
+		values[key] = v
+	}
+	return values
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
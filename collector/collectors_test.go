@@ -0,0 +1,199 @@
+package collector
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+func TestValidateCollectors(t *testing.T) {
+	if err := ValidateCollectors([]string{"boot", "lan", "sel", "users", "watchdog"}); err != nil {
+		t.Error(err)
+	}
+	if err := ValidateCollectors([]string{"watchdog", "nope"}); err == nil {
+		t.Error("want error for unknown collector")
+	}
+}
+
+func TestCollectOptionalErrors(t *testing.T) {
+	calls := map[string]int{}
+	errs := map[string]error{
+		"sel info":      errors.New("exit status 1: Unable to establish IPMI v2 / RMCP+ session"),
+		"raw 0x06 0x25": errors.New("exit status 1: Unable to send RAW command (channel=0x0 netfn=0x6 lun=0x0 cmd=0x25 rsp=0xc1): Invalid command"),
+	}
+	e := NewExporter(backendFunc(func(args ...string) ([]byte, error) {
+		cmd := strings.Join(args, " ")
+		calls[cmd]++
+		return nil, errs[cmd]
+	}))
+	e.Logger = log.NewNopLogger()
+	e.Collectors = []string{CollectorSEL, CollectorWatchdog}
+
+	collect := func() *Collection {
+		c := &Collection{Parts: map[string]error{}}
+		e.collectOptional(make(chan prometheus.Metric, 10), c)
+		return c
+	}
+	c := collect()
+	if c.Parts[CollectorSEL] == nil || c.Parts[CollectorWatchdog] == nil {
+		t.Fatalf("want failed sel and watchdog parts, got %v", c.Parts)
+	}
+	c = collect()
+	if c.Parts[CollectorSEL] == nil || c.Parts[CollectorSEL] == errDisabled {
+		t.Errorf("want the transient sel error retried, got %v", c.Parts[CollectorSEL])
+	}
+	if c.Parts[CollectorWatchdog] != errDisabled {
+		t.Errorf("want the unsupported watchdog collector disabled, got %v", c.Parts[CollectorWatchdog])
+	}
+	if calls["sel info"] != 2 || calls["raw 0x06 0x25"] != 1 {
+		t.Errorf("unexpected commands %v", calls)
+	}
+}
+
+func TestCollectUsersFailingSlot(t *testing.T) {
+	replay := &Replay{Dir: "testdata/fixtures/supermicro"}
+	e := NewExporter(backendFunc(func(args ...string) ([]byte, error) {
+		if strings.Join(args, " ") == "raw 0x06 0x44 0x01 0x03" {
+			return nil, errors.New("exit status 1: Unable to send RAW command (channel=0x0 netfn=0x6 lun=0x0 cmd=0x44 rsp=0xcc): Invalid data field in request")
+		}
+		return replay.Output(args...)
+	}))
+	e.Logger = log.NewNopLogger()
+	e.Collectors = []string{CollectorUsers}
+
+	for i := 0; i < 2; i++ {
+		out, err := collectText(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, user := range []string{`name="ADMIN"`, `name="root"`} {
+			if !strings.Contains(string(out), user) {
+				t.Errorf("want user %s after the failing slot, got:\n%s", user, out)
+			}
+		}
+		if strings.Contains(string(out), `name="monitor"`) {
+			t.Errorf("want failing user 3 skipped, got:\n%s", out)
+		}
+		err = e.LastCollection().Parts[CollectorUsers]
+		if err == nil || err == errDisabled {
+			t.Errorf("want the failing slot reported, got %v", err)
+		}
+	}
+}
+
+func TestParseBootFlags(t *testing.T) {
+	for output, want := range map[string]bootFlags{
+		" 01 05 e0 08 00 00 00":  {device: "Hard Drive", persistent: true, efi: true},
+		" 01 05 a0 14 00 00 00":  {device: "CD/DVD", efi: true},
+		" 01 85 80 1c 00 00 00":  {device: "Remote Floppy"},
+		" 01 05 80 34 00 00 00":  {device: "Reserved (0x0d)"},
+		" 01 05 40 04 00 00 00 ": {device: "No override"},
+	} {
+		got, err := parseBootFlags([]byte(output))
+		if err != nil {
+			t.Errorf("%q: %v", output, err)
+			continue
+		}
+		if *got != want {
+			t.Errorf("%q: want %+v, got %+v", output, want, *got)
+		}
+	}
+	if _, err := parseBootFlags([]byte(" 01 04 00")); err == nil {
+		t.Error("want error for other parameter")
+	}
+}
+
+func TestParseSELInfo(t *testing.T) {
+	info, err := ParseSELInfo([]byte(`SEL Information
+Version          : 1.5 (v1.5, v2 compliant)
+Entries          : 3
+Free Space       : 16336 bytes
+Percent Used     : 0%
+Last Add Time    : 04/13/2017 10:27:40
+Last Del Time    : 04/13/2017 10:20:00
+Overflow         : false
+Supported Cmds   : 'Partial Add' 'Reserve'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2017, 4, 13, 10, 20, 0, 0, time.Local)
+	if info.Entries != 3 || info.Fullness != 48.0/16384 || !info.LastCleared.Equal(want) {
+		t.Errorf("unexpected SEL info %+v", info)
+	}
+	if _, err := ParseSELInfo([]byte("Entries : 3\nFree Space : unknown\n")); err == nil {
+		t.Error("want error without free space")
+	}
+}
+
+func TestSensors(t *testing.T) {
+	e := NewExporter(&Replay{Dir: "testdata/fixtures/supermicro"})
+	sensors, err := e.Sensors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sensors) != 66 {
+		t.Fatalf("want 66 sensors, got %d", len(sensors))
+	}
+	s := sensors[0]
+	if s.Name != "CPU1 Temp" || s.Type != FamilyTemperature || s.Unit != "degrees C" || s.State != "ok" {
+		t.Errorf("unexpected sensor %+v", s)
+	}
+	if s.Value == nil || *s.Value != 33 {
+		t.Errorf("want value 33, got %v", s.Value)
+	}
+	if th := s.Thresholds; th.UpperCritical == nil || *th.UpperCritical != 82 || th.LowerNonRecoverable == nil {
+		t.Errorf("unexpected thresholds %+v", th)
+	}
+	for _, s := range sensors {
+		if s.Name == "HDD Status" && (s.Value != nil || s.Thresholds.UpperCritical != nil) {
+			t.Errorf("want no value and thresholds for %+v", s)
+		}
+	}
+}
+
+func TestParseFRU(t *testing.T) {
+	e := NewExporter(&Replay{Dir: "testdata/fixtures/supermicro"})
+	frus, err := e.FRU()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frus) != 2 {
+		t.Fatalf("want 2 FRUs, got %+v", frus)
+	}
+	if frus[0].Description != "Builtin FRU Device (ID 0)" || !reflect.DeepEqual(frus[0].Fields["Product Name"], []string{"SYS-6017R-WRF"}) {
+		t.Errorf("unexpected FRU %+v", frus[0])
+	}
+	if want := []string{"1U", "rack 4"}; !reflect.DeepEqual(frus[0].Fields["Product Extra"], want) {
+		t.Errorf("want repeated field values %q, got %q", want, frus[0].Fields["Product Extra"])
+	}
+	if !reflect.DeepEqual(frus[1].Fields["Board Product"], []string{"PWS-504P-1R"}) {
+		t.Errorf("unexpected FRU %+v", frus[1])
+	}
+}
+
+func TestBMC(t *testing.T) {
+	e := NewExporter(&Replay{Dir: "testdata/fixtures/dell"})
+	bmc, err := e.BMC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := BMC{
+		DeviceID:         "32",
+		FirmwareRevision: "2.70",
+		IPMIVersion:      "2.0",
+		ManufacturerID:   674,
+		ManufacturerName: "DELL Inc",
+		ProductID:        "256 (0x0100)",
+		ProductName:      "Unknown (0x100)",
+		Profile:          "dell",
+	}
+	if *bmc != want {
+		t.Errorf("want %+v, got %+v", want, *bmc)
+	}
+}
@@ -0,0 +1,174 @@
+package collector
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxEnergyGap is the number of poll intervals after which a missing reading
+// is not interpolated, e.g. while the exporter or the BMC was down.
+const maxEnergyGap = 5
+
+// EnergyMeter integrates the power readings of an ipmi node into energy
+// counters. Power readings are the metrics in watts of the sensors and raw
+// commands. They are polled in the background, independent of scrapes, and
+// integrated with the trapezoidal rule.
+type EnergyMeter struct {
+	// Interval between two power readings.
+	Interval time.Duration
+	// StateFile persists the counters across restarts if set.
+	StateFile string
+
+	exporter *Exporter
+	now      func() time.Time
+
+	mu     sync.Mutex
+	joules map[string]float64
+	last   map[string]powerReading
+}
+
+type powerReading struct {
+	time  time.Time
+	watts float64
+}
+
+// energyState is the content of the state file.
+type energyState struct {
+	Joules map[string]float64 `json:"joules"`
+}
+
+// StartEnergyMeter starts polling the power readings of the ipmi node every
+// interval until stop is closed, and exposes the energy counters with the
+// metrics of the exporter.
+func (e *Exporter) StartEnergyMeter(interval time.Duration, stateFile string, stop <-chan struct{}) *EnergyMeter {
+	m := &EnergyMeter{
+		Interval:  interval,
+		StateFile: stateFile,
+		exporter:  e,
+		now:       time.Now,
+		joules:    map[string]float64{},
+		last:      map[string]powerReading{},
+	}
+	if err := m.load(); err != nil {
+		e.Logger.With("file", stateFile).Errorf("could not load energy counters: %v", err)
+	}
+
+	e.mu.Lock()
+	e.energy = m
+	e.mu.Unlock()
+
+	go m.run(stop)
+	return m
+}
+
+func (e *Exporter) energyMeter() *EnergyMeter {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.energy
+}
+
+func (m *EnergyMeter) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	for {
+		m.Poll()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll reads the power of the ipmi node once and adds the energy consumed
+// since the previous reading to the counters.
+func (m *EnergyMeter) Poll() {
+	var readings []metric
+	metrics, _ := m.exporter.metrics()
+	for _, res := range metrics {
+		if isPower(res.unit) {
+			readings = append(readings, res)
+		}
+	}
+	m.add(m.now(), readings)
+	if err := m.save(); err != nil {
+		m.exporter.Logger.With("file", m.StateFile).Errorf("could not save energy counters: %v", err)
+	}
+}
+
+func (m *EnergyMeter) add(now time.Time, readings []metric) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range readings {
+		if _, ok := m.joules[r.metricsname]; !ok {
+			m.joules[r.metricsname] = 0
+		}
+		if prev, ok := m.last[r.metricsname]; ok {
+			dt := now.Sub(prev.time)
+			if dt > 0 && dt <= maxEnergyGap*m.Interval {
+				m.joules[r.metricsname] += (prev.watts + r.value) / 2 * dt.Seconds()
+			}
+		}
+		m.last[r.metricsname] = powerReading{time: now, watts: r.value}
+	}
+}
+
+func (m *EnergyMeter) collect(ch chan<- prometheus.Metric) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, joules := range m.joules {
+		ch <- prometheus.MustNewConstMetric(energy, prometheus.CounterValue, joules, name)
+	}
+}
+
+func (m *EnergyMeter) load() error {
+	if m.StateFile == "" {
+		return nil
+	}
+	buf, err := ioutil.ReadFile(m.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state energyState
+	if err := json.Unmarshal(buf, &state); err != nil {
+		return err
+	}
+	for name, joules := range state.Joules {
+		m.joules[name] = joules
+	}
+	return nil
+}
+
+func (m *EnergyMeter) save() error {
+	if m.StateFile == "" {
+		return nil
+	}
+	m.mu.Lock()
+	buf, err := json.Marshal(&energyState{Joules: m.joules})
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := m.StateFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.StateFile)
+}
+
+func isPower(unit string) bool {
+	switch strings.ToLower(unit) {
+	case "watts", "w":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package collector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEnergyMeter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipmi_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state := filepath.Join(dir, "energy.json")
+
+	start := time.Unix(1500000000, 0)
+	now := start
+	newMeter := func() *EnergyMeter {
+		m := &EnergyMeter{
+			Interval:  10 * time.Second,
+			StateFile: state,
+			exporter:  NewExporter(&Replay{Dir: "testdata/fixtures/supermicro"}),
+			now:       func() time.Time { return now },
+			joules:    map[string]float64{},
+			last:      map[string]powerReading{},
+		}
+		if err := m.load(); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	m := newMeter()
+	m.Poll()
+	now = now.Add(10 * time.Second)
+	m.Poll()
+	// InputPowerPSU1 reads 0x5e = 94 W, InputPowerPSU2 is disabled as
+	// its raw command fails.
+	if len(m.joules) != 1 || m.joules["InputPowerPSU1"] != 940 {
+		t.Fatalf("want 940 J for InputPowerPSU1, got %v", m.joules)
+	}
+
+	// Power changes linearly between readings.
+	now = now.Add(10 * time.Second)
+	m.add(now, []metric{{metricsname: "InputPowerPSU1", value: 106, unit: "W"}})
+	if m.joules["InputPowerPSU1"] != 1940 {
+		t.Errorf("want 1940 J, got %v", m.joules["InputPowerPSU1"])
+	}
+
+	// Gaps are not interpolated.
+	now = now.Add(time.Hour)
+	m.add(now, []metric{{metricsname: "InputPowerPSU1", value: 106, unit: "W"}})
+	if m.joules["InputPowerPSU1"] != 1940 {
+		t.Errorf("want gap to be skipped, got %v J", m.joules["InputPowerPSU1"])
+	}
+
+	// The counters survive restarts.
+	if err := m.save(); err != nil {
+		t.Fatal(err)
+	}
+	m = newMeter()
+	if m.joules["InputPowerPSU1"] != 1940 {
+		t.Errorf("want 1940 J after restart, got %v", m.joules)
+	}
+	m.Poll()
+	now = now.Add(10 * time.Second)
+	m.Poll()
+	if m.joules["InputPowerPSU1"] != 2880 {
+		t.Errorf("want 2880 J, got %v", m.joules["InputPowerPSU1"])
+	}
+}
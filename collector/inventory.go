@@ -0,0 +1,193 @@
+package collector
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Sensor is a sensor reading printed by ipmitool sensor.
+type Sensor struct {
+	Name string `json:"name"`
+	// Type is the family the vendor profile maps the sensor to, empty if
+	// the sensor is not exported as metric.
+	Type  string   `json:"type"`
+	Unit  string   `json:"unit"`
+	Value *float64 `json:"value"`
+	// State is the status of threshold sensors, e.g. "ok" or "cr", or the
+	// hex state of discrete sensors.
+	State      string     `json:"state"`
+	Thresholds Thresholds `json:"thresholds"`
+}
+
+// Thresholds are the thresholds of a sensor, nil if not set.
+type Thresholds struct {
+	LowerNonRecoverable *float64 `json:"lower_non_recoverable"`
+	LowerCritical       *float64 `json:"lower_critical"`
+	LowerNonCritical    *float64 `json:"lower_non_critical"`
+	UpperNonCritical    *float64 `json:"upper_non_critical"`
+	UpperCritical       *float64 `json:"upper_critical"`
+	UpperNonRecoverable *float64 `json:"upper_non_recoverable"`
+}
+
+// FRU is a field replaceable unit printed by ipmitool fru print.
+type FRU struct {
+	// Description is the FRU device description, e.g.
+	// "Builtin FRU Device (ID 0)".
+	Description string `json:"description"`
+	// Fields are the values of the fields by name. Fields like
+	// "Product Extra" may be repeated, so each has a list of values.
+	Fields map[string][]string `json:"fields"`
+}
+
+// BMC describes the BMC of an ipmi node as printed by ipmitool mc info.
+type BMC struct {
+	DeviceID         string `json:"device_id"`
+	FirmwareRevision string `json:"firmware_revision"`
+	IPMIVersion      string `json:"ipmi_version"`
+	ManufacturerID   int    `json:"manufacturer_id"`
+	ManufacturerName string `json:"manufacturer_name"`
+	ProductID        string `json:"product_id"`
+	ProductName      string `json:"product_name"`
+	// Profile is the vendor profile used for the BMC.
+	Profile string `json:"profile"`
+}
+
+// Sensors reads the sensors like Collect does, including the sensors that are
+// not exported as metrics.
+func (e *Exporter) Sensors() ([]Sensor, error) {
+	profile := e.vendorProfile()
+	output, err := e.ipmiOutput("sensor")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := splitOutput(output)
+	if err != nil {
+		return nil, err
+	}
+
+	sensors := make([]Sensor, 0, len(rows))
+	for _, row := range rows {
+		for i := range row {
+			row[i] = strings.TrimSpace(row[i])
+		}
+		for len(row) < 10 {
+			row = append(row, "na")
+		}
+		s := Sensor{
+			Name:  row[0],
+			Type:  profile.family(row[0], row[2]),
+			Unit:  row[2],
+			State: row[3],
+			Thresholds: Thresholds{
+				LowerNonRecoverable: threshold(row[4]),
+				LowerCritical:       threshold(row[5]),
+				LowerNonCritical:    threshold(row[6]),
+				UpperNonCritical:    threshold(row[7]),
+				UpperCritical:       threshold(row[8]),
+				UpperNonRecoverable: threshold(row[9]),
+			},
+		}
+		if row[1] != "na" {
+			if v, err := convertValue(row[1], row[2]); err == nil {
+				s.Value = &v
+			}
+		}
+		sensors = append(sensors, s)
+	}
+	return sensors, nil
+}
+
+func threshold(s string) *float64 {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
+
+// SEL reads the state of the system event log like the sel collector does.
+func (e *Exporter) SEL() (*SELInfo, error) {
+	output, err := e.ipmiOutput("sel info")
+	if err != nil {
+		return nil, err
+	}
+	return ParseSELInfo(output)
+}
+
+// SELRecords reads the records of the system event log as printed by
+// ipmitool sel elist, to be parsed by the sel package.
+func (e *Exporter) SELRecords() ([]byte, error) {
+	return e.ipmiOutput("sel elist")
+}
+
+// FRU reads the field replaceable units.
+func (e *Exporter) FRU() ([]FRU, error) {
+	output, err := e.ipmiOutput("fru print")
+	if err != nil {
+		return nil, err
+	}
+	return ParseFRU(output), nil
+}
+
+// ParseFRU parses the output of ipmitool fru print, which prints the fields of
+// each FRU device in a block separated by empty lines.
+func ParseFRU(output []byte) []FRU {
+	frus := []FRU{}
+	for _, block := range strings.Split(string(output), "\n\n") {
+		fields := parseFieldValues([]byte(block))
+		description, ok := fields["FRU Device Description"]
+		if !ok {
+			continue
+		}
+		delete(fields, "FRU Device Description")
+		frus = append(frus, FRU{Description: description[0], Fields: fields})
+	}
+	return frus
+}
+
+// parseFieldValues parses "key : value" lines like parseFields, but keeps
+// every value of a repeated key.
+func parseFieldValues(output []byte) map[string][]string {
+	values := map[string][]string{}
+	var key string
+	for _, line := range strings.Split(string(output), "\n") {
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		k, v := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if k == "" {
+			if key != "" {
+				last := len(values[key]) - 1
+				values[key][last] += "\n" + v
+			}
+			continue
+		}
+		key = k
+		values[key] = append(values[key], v)
+	}
+	return values
+}
+
+// BMC reads the device information of the BMC.
+func (e *Exporter) BMC() (*BMC, error) {
+	output, err := e.ipmiOutput("mc info")
+	if err != nil {
+		return nil, err
+	}
+	id, err := parseManufacturerID(output)
+	if err != nil {
+		return nil, err
+	}
+	fields := parseFields(output)
+	return &BMC{
+		DeviceID:         fields["Device ID"],
+		FirmwareRevision: fields["Firmware Revision"],
+		IPMIVersion:      fields["IPMI Version"],
+		ManufacturerID:   id,
+		ManufacturerName: fields["Manufacturer Name"],
+		ProductID:        fields["Product ID"],
+		ProductName:      fields["Product Name"],
+		Profile:          e.vendorProfile().Name,
+	}, nil
+}
@@ -0,0 +1,190 @@
+package collector
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CollectorLAN exposes the configuration of the LAN channel for security
+// audits.
+const CollectorLAN = "lan"
+
+var (
+	lanInfo = newDesc(
+		"lan", "info",
+		"Configuration of the LAN channel",
+		[]string{"channel", "ip_source", "ip_address", "mac_address", "vlan_id"},
+	)
+
+	lanVLANEnabled = newDesc(
+		"lan", "vlan_enabled",
+		"Indicates if 802.1q VLAN tagging is enabled",
+		[]string{"channel"},
+	)
+
+	lanCipherSuiteEnabled = newDesc(
+		"lan", "cipher_suite_enabled",
+		"Indicates if an RMCP+ cipher suite is enabled",
+		[]string{"channel", "id"},
+	)
+
+	lanCipherSuiteZeroEnabled = newDesc(
+		"lan", "cipher_suite_zero_enabled",
+		"Indicates if cipher suite 0, which requires no authentication, is enabled",
+		[]string{"channel"},
+	)
+
+	lanAuthTypeNoneEnabled = newDesc(
+		"lan", "auth_type_none_enabled",
+		"Indicates if IPMI v1.5 sessions without authentication are enabled for any privilege level",
+		[]string{"channel"},
+	)
+
+	lanAnonymousLogin = newDesc(
+		"lan", "anonymous_login_enabled",
+		"Indicates if anonymous login is enabled",
+		[]string{"channel"},
+	)
+
+	lanNullUsernames = newDesc(
+		"lan", "null_usernames_enabled",
+		"Indicates if users with a null user name exist",
+		[]string{"channel"},
+	)
+
+	lanARPResponses = newDesc(
+		"lan", "arp_responses_enabled",
+		"Indicates if the BMC answers ARP requests",
+		[]string{"channel"},
+	)
+
+	lanGratuitousARP = newDesc(
+		"lan", "gratuitous_arp_enabled",
+		"Indicates if the BMC sends gratuitous ARPs",
+		[]string{"channel"},
+	)
+)
+
+func init() {
+	optionalCollectors[CollectorLAN] = &optionalCollector{
+		descs: []*prometheus.Desc{
+			lanInfo,
+			lanVLANEnabled,
+			lanCipherSuiteEnabled,
+			lanCipherSuiteZeroEnabled,
+			lanAuthTypeNoneEnabled,
+			lanAnonymousLogin,
+			lanNullUsernames,
+			lanARPResponses,
+			lanGratuitousARP,
+		},
+		collect: collectLAN,
+	}
+}
+
+// lanConfig is the LAN configuration printed by `ipmitool lan print`.
+type lanConfig struct {
+	ipSource     string
+	ipAddress    string
+	macAddress   string
+	vlanID       string
+	cipherSuites map[int]bool
+	authTypeNone bool
+	arpResponses bool
+	gratuitous   bool
+}
+
+func parseLANConfig(output []byte) (*lanConfig, error) {
+	values := parseFields(output)
+	c := &lanConfig{
+		ipSource:     values["IP Address Source"],
+		ipAddress:    values["IP Address"],
+		macAddress:   values["MAC Address"],
+		vlanID:       values["802.1q VLAN ID"],
+		cipherSuites: map[int]bool{},
+	}
+	if c.ipSource == "" && c.ipAddress == "" {
+		return nil, fmt.Errorf("no LAN configuration found in %q", output)
+	}
+
+	for _, level := range strings.Split(values["Auth Type Enable"], "\n") {
+		if i := strings.Index(level, ":"); i >= 0 {
+			level = level[i+1:]
+		}
+		for _, t := range strings.Fields(level) {
+			if t == "NONE" {
+				c.authTypeNone = true
+			}
+		}
+	}
+
+	// The privilege levels are listed in the order of the cipher suites,
+	// with X for suites that cannot be used.
+	privs := strings.SplitN(values["Cipher Suite Priv Max"], "\n", 2)[0]
+	if ids := values["RMCP+ Cipher Suites"]; ids != "" && ids != "None" {
+		for i, s := range strings.Split(ids, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil {
+				return nil, fmt.Errorf("could not parse cipher suites %q", ids)
+			}
+			c.cipherSuites[id] = i >= len(privs) || privs[i] != 'X'
+		}
+	}
+
+	arp := values["BMC ARP Control"]
+	c.arpResponses = strings.Contains(arp, "ARP Responses Enabled")
+	c.gratuitous = strings.Contains(arp, "Gratuitous ARP Enabled")
+	return c, nil
+}
+
+// authCapabilities is the output of `ipmitool channel authcap`.
+type authCapabilities struct {
+	anonymousLogin bool
+	nullUsernames  bool
+}
+
+func parseAuthCapabilities(output []byte) (*authCapabilities, error) {
+	values := parseFields(output)
+	anonymous, ok1 := values["Anonymous login enabled"]
+	null, ok2 := values["Null user names exist"]
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("no authentication capabilities found in %q", output)
+	}
+	return &authCapabilities{anonymousLogin: anonymous == "yes", nullUsernames: null == "yes"}, nil
+}
+
+func collectLAN(e *Exporter, ch chan<- prometheus.Metric) error {
+	channel := strconv.Itoa(e.vendorProfile().lanChannel())
+	output, err := e.ipmiOutput("lan print " + channel)
+	if err != nil {
+		return err
+	}
+	c, err := parseLANConfig(output)
+	if err != nil {
+		return err
+	}
+	output, err = e.ipmiOutput("channel authcap " + channel + " 4")
+	if err != nil {
+		return err
+	}
+	caps, err := parseAuthCapabilities(output)
+	if err != nil {
+		return err
+	}
+
+	ch <- prometheus.MustNewConstMetric(lanInfo, prometheus.GaugeValue, 1, channel, c.ipSource, c.ipAddress, c.macAddress, c.vlanID)
+	ch <- prometheus.MustNewConstMetric(lanVLANEnabled, prometheus.GaugeValue, boolValue(c.vlanID != "" && c.vlanID != "Disabled"), channel)
+	for id, enabled := range c.cipherSuites {
+		ch <- prometheus.MustNewConstMetric(lanCipherSuiteEnabled, prometheus.GaugeValue, boolValue(enabled), channel, strconv.Itoa(id))
+	}
+	ch <- prometheus.MustNewConstMetric(lanCipherSuiteZeroEnabled, prometheus.GaugeValue, boolValue(c.cipherSuites[0]), channel)
+	ch <- prometheus.MustNewConstMetric(lanAuthTypeNoneEnabled, prometheus.GaugeValue, boolValue(c.authTypeNone), channel)
+	ch <- prometheus.MustNewConstMetric(lanAnonymousLogin, prometheus.GaugeValue, boolValue(caps.anonymousLogin), channel)
+	ch <- prometheus.MustNewConstMetric(lanNullUsernames, prometheus.GaugeValue, boolValue(caps.nullUsernames), channel)
+	ch <- prometheus.MustNewConstMetric(lanARPResponses, prometheus.GaugeValue, boolValue(c.arpResponses), channel)
+	ch <- prometheus.MustNewConstMetric(lanGratuitousARP, prometheus.GaugeValue, boolValue(c.gratuitous), channel)
+	return nil
+}
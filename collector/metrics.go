@@ -1,49 +1,111 @@
 package collector
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
 
 const namespace = "ipmi"
 
+// MetricInfo describes a metric the exporter can expose, e.g. to generate
+// dashboards for it.
+type MetricInfo struct {
+	Name   string
+	Help   string
+	Labels []string
+	// Family is the metric family of the sensors exposed as the metric.
+	Family string
+	// Collector is the optional collector exposing the metric, empty for
+	// metrics exposed without enabling a collector.
+	Collector string
+}
+
+// descInfos are the metrics of the descriptors created with newDesc.
+var descInfos = map[*prometheus.Desc]MetricInfo{}
+
+// newDesc returns the descriptor of a metric of the exporter and records it
+// for Metrics.
+func newDesc(subsystem, name, help string, labels []string) *prometheus.Desc {
+	fqName := prometheus.BuildFQName(namespace, subsystem, name)
+	d := prometheus.NewDesc(fqName, help, labels, nil)
+	descInfos[d] = MetricInfo{Name: fqName, Help: help, Labels: labels}
+	return d
+}
+
+// Metrics returns the metrics the exporter can expose, sorted by name.
+func Metrics() []MetricInfo {
+	families := map[*prometheus.Desc]string{}
+	for family, d := range familyDescs {
+		families[d] = family
+	}
+	collectors := map[*prometheus.Desc]string{}
+	for name, c := range optionalCollectors {
+		for _, d := range c.descs {
+			collectors[d] = name
+		}
+	}
+
+	metrics := make([]MetricInfo, 0, len(descInfos))
+	for d, info := range descInfos {
+		info.Family = families[d]
+		info.Collector = collectors[d]
+		metrics = append(metrics, info)
+	}
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Name < metrics[j].Name })
+	return metrics
+}
+
 var (
-	temperatures = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "temperatures"),
+	temperatures = newDesc(
+		"", "temperatures",
 		"Contains the collected temperatures from IPMI",
 		[]string{"sensor"},
-		nil,
 	)
 
-	fanspeed = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "fan_speed"),
+	fanspeed = newDesc(
+		"", "fan_speed",
 		"Fan Speed in RPM",
 		[]string{"fan"},
-		nil,
 	)
 
-	voltages = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "voltages"),
+	voltages = newDesc(
+		"", "voltages",
 		"Contains the voltages from IPMI",
 		[]string{"sensor"},
-		nil,
 	)
 
-	current = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "current"),
+	current = newDesc(
+		"", "current",
 		"Contains the current from IPMI",
 		[]string{"sensor"},
-		nil,
 	)
 
-	intrusion = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "intrusion_status"),
+	intrusion = newDesc(
+		"", "intrusion_status",
 		"Indicates if a chassis is open",
 		nil,
-		nil,
 	)
 
-	powersupply = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "power_supply_status"),
+	powersupply = newDesc(
+		"", "power_supply_status",
 		"Indicates if a power supply is operational",
 		[]string{"PSU"},
-		nil,
+	)
+
+	energy = newDesc(
+		"power", "energy_joules_total",
+		"Energy consumed, integrated from the power readings",
+		[]string{"PSU"},
 	)
 )
+
+// familyDescs maps the metric families of the profiles to their descriptors.
+var familyDescs = map[string]*prometheus.Desc{
+	FamilyTemperature: temperatures,
+	FamilyVoltage:     voltages,
+	FamilyFanSpeed:    fanspeed,
+	FamilyCurrent:     current,
+	FamilyPowerSupply: powersupply,
+	FamilyIntrusion:   intrusion,
+}
@@ -0,0 +1,209 @@
+package collector
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PMBus data formats of the registers.
+const (
+	// PMBusLinear11 is a two byte value with a 5 bit exponent and an 11
+	// bit mantissa.
+	PMBusLinear11 = "linear11"
+	// PMBusLinear16 is a two byte mantissa with the exponent given by the
+	// VOUT_MODE register.
+	PMBusLinear16 = "linear16"
+)
+
+// pmbusVoutMode is the VOUT_MODE register holding the exponent of linear16
+// values.
+const pmbusVoutMode = 0x20
+
+// PSU is a power supply whose PMBus registers are read with the Master
+// Write-Read command through the BMC.
+type PSU struct {
+	// Index identifies the power supply in the psu label.
+	Index int `json:"index"`
+	// Bus is the private bus ID of the PMBus, e.g. "0x07".
+	Bus string `json:"bus"`
+	// Address is the 8 bit slave address of the power supply, e.g. "0x78".
+	Address string `json:"address"`
+}
+
+// PMBusRegister maps a PMBus register to an ipmi_psu_* metric.
+type PMBusRegister struct {
+	Metric   string `json:"metric"`
+	Register string `json:"register"`
+	Format   string `json:"format"`
+}
+
+// DefaultPMBusRegisters are the standard PMBus registers read from power
+// supplies of profiles without their own register map.
+var DefaultPMBusRegisters = []PMBusRegister{
+	{Metric: "input_voltage_volts", Register: "0x88", Format: PMBusLinear11},
+	{Metric: "input_current_amps", Register: "0x89", Format: PMBusLinear11},
+	{Metric: "output_voltage_volts", Register: "0x8b", Format: PMBusLinear16},
+	{Metric: "output_current_amps", Register: "0x8c", Format: PMBusLinear11},
+	{Metric: "temperature_celsius", Register: "0x8d", Format: PMBusLinear11},
+	{Metric: "fan_speed_rpm", Register: "0x90", Format: PMBusLinear11},
+	{Metric: "output_power_watts", Register: "0x96", Format: PMBusLinear11},
+	{Metric: "input_power_watts", Register: "0x97", Format: PMBusLinear11},
+}
+
+var psuDescs = map[string]*prometheus.Desc{}
+
+func init() {
+	for name, help := range map[string]string{
+		"input_voltage_volts":  "Input voltage of the power supply",
+		"input_current_amps":   "Input current of the power supply",
+		"output_voltage_volts": "Output voltage of the power supply",
+		"output_current_amps":  "Output current of the power supply",
+		"temperature_celsius":  "Temperature of the power supply",
+		"fan_speed_rpm":        "Fan speed of the power supply in RPM",
+		"output_power_watts":   "Output power of the power supply",
+		"input_power_watts":    "Input power of the power supply",
+	} {
+		psuDescs[name] = newDesc(
+			"psu", name,
+			help,
+			[]string{"psu"},
+		)
+	}
+}
+
+func (r *PMBusRegister) validate() error {
+	if _, ok := psuDescs[r.Metric]; !ok {
+		return fmt.Errorf("unknown PSU metric %q", r.Metric)
+	}
+	if _, err := strconv.ParseUint(r.Register, 0, 8); err != nil {
+		return fmt.Errorf("invalid PMBus register %q", r.Register)
+	}
+	switch r.Format {
+	case PMBusLinear11, PMBusLinear16:
+	default:
+		return fmt.Errorf("unknown PMBus format %q", r.Format)
+	}
+	return nil
+}
+
+func (p *PSU) validate() error {
+	for _, v := range []string{p.Bus, p.Address} {
+		if _, err := strconv.ParseUint(v, 0, 8); err != nil {
+			return fmt.Errorf("PSU %d: invalid bus or address %q", p.Index, v)
+		}
+	}
+	return nil
+}
+
+// command returns the ipmitool command reading n bytes of a register.
+func (p *PSU) command(register string, n int) string {
+	args := []string{"raw", "0x06", "0x52"}
+	for _, v := range []string{p.Bus, p.Address, strconv.Itoa(n), register} {
+		b, _ := strconv.ParseUint(v, 0, 8)
+		args = append(args, fmt.Sprintf("0x%02x", b))
+	}
+	return strings.Join(args, " ")
+}
+
+// collectPSUs reads the PMBus registers of the power supplies of the profile.
+// Registers the BMC or the PSU does not support are not queried again, other
+// errors like a PSU being replaced are retried on the next collection.
+func (e *Exporter) collectPSUs(ch chan<- prometheus.Metric, profile *Profile) {
+	registers := profile.PMBusRegisters
+	if len(registers) == 0 {
+		registers = DefaultPMBusRegisters
+	}
+	for _, psu := range profile.PSUs {
+		index := strconv.Itoa(psu.Index)
+		var (
+			voutExp  int
+			voutErr  error
+			voutRead bool
+		)
+
+		for _, r := range registers {
+			key := fmt.Sprintf("psu%d/%s", psu.Index, r.Metric)
+			if e.rawDisabled(key) {
+				e.tracef("Skipping %s of PSU %d disabled after an earlier error", r.Metric, psu.Index)
+				continue
+			}
+
+			var value float64
+			raw, err := e.readPMBus(&psu, r.Register, 2)
+			if err == nil {
+				word := uint16(raw[0]) | uint16(raw[1])<<8
+				switch r.Format {
+				case PMBusLinear11:
+					value = linear11(word)
+				case PMBusLinear16:
+					if !voutRead {
+						voutExp, voutErr = e.voutExponent(&psu)
+						voutRead = true
+					}
+					err = voutErr
+					value = float64(word) * math.Pow(2, float64(voutExp))
+				}
+			}
+			if err != nil && !unsupported(err) {
+				e.tracef("Could not read %s of PSU %d, retrying on the next collection: %v", r.Metric, psu.Index, err)
+				continue
+			}
+			if err != nil {
+				e.tracef("Disabling %s of PSU %d: %v", r.Metric, psu.Index, err)
+				e.Logger.With("collector", "psu").With("psu", psu.Index).With("metric", r.Metric).Infof("Disabling the unsupported PSU sensor: %v", err)
+				e.disableRaw(key)
+				continue
+			}
+			e.tracef("PSU %d reads %s %v", psu.Index, r.Metric, value)
+			ch <- prometheus.MustNewConstMetric(psuDescs[r.Metric], prometheus.GaugeValue, value, index)
+		}
+	}
+}
+
+func (e *Exporter) voutExponent(psu *PSU) (int, error) {
+	mode, err := e.readPMBus(psu, fmt.Sprintf("0x%02x", pmbusVoutMode), 1)
+	if err != nil {
+		return 0, err
+	}
+	if mode[0]>>5 != 0 {
+		return 0, unsupportedError(fmt.Sprintf("PSU %d: unsupported VOUT_MODE 0x%02x", psu.Index, mode[0]))
+	}
+	return signExtend(int(mode[0]&0x1f), 5), nil
+}
+
+// readPMBus reads n bytes of a register with Master Write-Read.
+func (e *Exporter) readPMBus(psu *PSU, register string, n int) ([]byte, error) {
+	cmd := psu.command(register, n)
+	output, err := e.ipmiOutput(cmd)
+	if err != nil {
+		return nil, err
+	}
+	b, err := parseRaw(output)
+	if err == nil && len(b) != n {
+		err = fmt.Errorf("want %d bytes, got %q", n, output)
+	}
+	if err != nil {
+		err = fmt.Errorf("PSU %d: could not parse register %s: %v", psu.Index, register, err)
+		e.logError(cmd, cmd+"/parse", err)
+		return nil, err
+	}
+	return b, nil
+}
+
+// linear11 decodes a PMBus LINEAR11 value.
+func linear11(word uint16) float64 {
+	exp := signExtend(int(word>>11), 5)
+	mantissa := signExtend(int(word&0x7ff), 11)
+	return float64(mantissa) * math.Pow(2, float64(exp))
+}
+
+func signExtend(v int, bits uint) int {
+	if v&(1<<(bits-1)) != 0 {
+		return v - 1<<bits
+	}
+	return v
+}
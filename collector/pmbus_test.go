@@ -0,0 +1,105 @@
+package collector
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+func TestLinear11(t *testing.T) {
+	for word, want := range map[uint16]float64{
+		0xf398: 230,    // exponent -2, mantissa 920
+		0x0063: 99,     // exponent 0
+		0x28b4: 5760,   // exponent 5, mantissa 180
+		0xdffc: -0.125, // exponent -5, mantissa -4
+		0xffff: -0.5,   // exponent -1, mantissa -1
+	} {
+		if got := linear11(word); got != want {
+			t.Errorf("0x%04x: want %v, got %v", word, want, got)
+		}
+	}
+}
+
+func TestPSUCommand(t *testing.T) {
+	psu := &PSU{Index: 1, Bus: "0x7", Address: "0x78"}
+	if got := psu.command("0x8B", 2); got != "raw 0x06 0x52 0x07 0x78 0x02 0x8b" {
+		t.Errorf("unexpected command %q", got)
+	}
+	if err := (&PMBusRegister{Metric: "efficiency", Register: "0x88", Format: PMBusLinear11}).validate(); err == nil {
+		t.Error("want error for unknown metric")
+	}
+	if err := (&PMBusRegister{Metric: "fan_speed_rpm", Register: "0x90", Format: "direct"}).validate(); err == nil {
+		t.Error("want error for unsupported format")
+	}
+}
+
+type backendFunc func(args ...string) ([]byte, error)
+
+func (f backendFunc) Output(args ...string) ([]byte, error) { return f(args...) }
+
+func TestCollectPSUsErrors(t *testing.T) {
+	const (
+		power = "raw 0x06 0x52 0x07 0x78 0x02 0x97"
+		fan   = "raw 0x06 0x52 0x07 0x78 0x02 0x90"
+	)
+	calls := map[string]int{}
+	errs := map[string]error{
+		power: errors.New("exit status 1: Unable to send RAW command (channel=0x0 netfn=0x6 lun=0x0 cmd=0x52 rsp=0x83): Unknown (0x83)"),
+		fan:   errors.New("exit status 1: Unable to send RAW command (channel=0x0 netfn=0x6 lun=0x0 cmd=0x52 rsp=0xc1): Invalid command"),
+	}
+	e := NewExporter(backendFunc(func(args ...string) ([]byte, error) {
+		cmd := strings.Join(args, " ")
+		calls[cmd]++
+		if err := errs[cmd]; err != nil {
+			return nil, err
+		}
+		return []byte(" 98 f3\n"), nil
+	}))
+	e.Logger = log.NewNopLogger()
+	profile := &Profile{
+		PSUs: []PSU{{Index: 1, Bus: "0x07", Address: "0x78"}},
+		PMBusRegisters: []PMBusRegister{
+			{Metric: "input_power_watts", Register: "0x97", Format: PMBusLinear11},
+			{Metric: "fan_speed_rpm", Register: "0x90", Format: PMBusLinear11},
+		},
+	}
+
+	ch := make(chan prometheus.Metric, 10)
+	e.collectPSUs(ch, profile)
+	if len(ch) != 0 {
+		t.Fatalf("want no metrics, got %d", len(ch))
+	}
+
+	// The PSU answers again after a transient error, the unsupported
+	// register is not queried again.
+	delete(errs, power)
+	e.collectPSUs(ch, profile)
+	e.collectPSUs(ch, profile)
+	if len(ch) != 2 {
+		t.Errorf("want input power read twice, got %d metrics", len(ch))
+	}
+	if calls[power] != 3 || calls[fan] != 1 {
+		t.Errorf("want power read 3 times and fan speed once, got %v", calls)
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	for msg, want := range map[string]bool{
+		"exit status 1: Unable to send RAW command (channel=0x0 netfn=0x6 lun=0x0 cmd=0x52 rsp=0xc1): Invalid command":                  true,
+		"exit status 1: Unable to send RAW command (channel=0x0 netfn=0x6 lun=0x0 cmd=0x52 rsp=0xcc): Invalid data field in request":    true,
+		"exit status 1: Unable to send RAW command (channel=0x0 netfn=0x6 lun=0x0 cmd=0x52 rsp=0xc3): Timeout while processing command": false,
+		"exit status 1: Get Watchdog Timer command failed: Parameter out of range":                                                      true,
+		"exit status 1: Unable to establish IPMI v2 / RMCP+ session":                                                                    false,
+		"timed out after 5s": false,
+	} {
+		if got := unsupported(errors.New(msg)); got != want {
+			t.Errorf("%q: want %v, got %v", msg, want, got)
+		}
+	}
+	if !unsupported(unsupportedError("PSU 1: unsupported VOUT_MODE 0x80")) {
+		t.Error("want unsupportedError to be unsupported")
+	}
+}
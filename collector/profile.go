@@ -0,0 +1,279 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Metric families a vendor sensor can be mapped to.
+const (
+	FamilyTemperature = "temperature"
+	FamilyVoltage     = "voltage"
+	FamilyFanSpeed    = "fan_speed"
+	FamilyCurrent     = "current"
+	FamilyPowerSupply = "power_supply"
+	FamilyIntrusion   = "intrusion"
+)
+
+// GenericProfile is the name of the profile used when the BMC vendor is not
+// known.
+const GenericProfile = "generic"
+
+// unitFamilies maps the sensor units reported by ipmitool to metric families.
+// It is used for sensors which are not matched by a profile.
+var unitFamilies = map[string]string{
+	"degrees c": FamilyTemperature,
+	"volts":     FamilyVoltage,
+	"rpm":       FamilyFanSpeed,
+	"watts":     FamilyPowerSupply,
+	"amps":      FamilyCurrent,
+}
+
+// Profile describes how the sensors and OEM commands of a BMC vendor map to
+// the metric families exposed by the exporter.
+type Profile struct {
+	Name            string          `json:"name"`
+	ManufacturerIDs []int           `json:"manufacturer_ids"`
+	Sensors         []SensorMapping `json:"sensors"`
+	RawCommands     []RawCommand    `json:"raw_commands"`
+	// PSUs are the power supplies read over PMBus.
+	PSUs []PSU `json:"psus"`
+	// PMBusRegisters replace DefaultPMBusRegisters if set.
+	PMBusRegisters []PMBusRegister `json:"pmbus_registers"`
+	// LANChannel is the number of the LAN channel, 1 by default.
+	LANChannel int `json:"lan_channel"`
+}
+
+// SensorMapping assigns all sensors whose name matches Pattern to a metric
+// family.
+type SensorMapping struct {
+	Pattern string `json:"pattern"`
+	Family  string `json:"family"`
+
+	re *regexp.Regexp
+}
+
+// RawCommand is an OEM command whose response is exposed as a metric of the
+// given family.
+type RawCommand struct {
+	Name    string `json:"name"`
+	Command string `json:"command"`
+	Unit    string `json:"unit"`
+	Family  string `json:"family"`
+}
+
+var builtinProfiles = []*Profile{
+	{
+		Name: GenericProfile,
+		Sensors: []SensorMapping{
+			{Pattern: "PS(.*) Status", Family: FamilyPowerSupply},
+			{Pattern: "Chassis Intru$", Family: FamilyIntrusion},
+		},
+	},
+	{
+		Name:            "supermicro",
+		ManufacturerIDs: []int{10876, 47488},
+		Sensors: []SensorMapping{
+			{Pattern: "PS(.*) Status", Family: FamilyPowerSupply},
+			{Pattern: "Chassis Intru$", Family: FamilyIntrusion},
+		},
+		// Supermicro X8 boards only expose the PSU input power via PMBus.
+		RawCommands: []RawCommand{
+			{Name: "InputPowerPSU1", Command: "raw 0x06 0x52 0x07 0x78 0x01 0x97", Unit: "W", Family: FamilyPowerSupply},
+			{Name: "InputPowerPSU2", Command: "raw 0x06 0x52 0x07 0x7a 0x01 0x97", Unit: "W", Family: FamilyPowerSupply},
+		},
+		PSUs: []PSU{
+			{Index: 1, Bus: "0x07", Address: "0x78"},
+			{Index: 2, Bus: "0x07", Address: "0x7a"},
+		},
+	},
+	{
+		Name:            "dell",
+		ManufacturerIDs: []int{674},
+		Sensors: []SensorMapping{
+			{Pattern: "^PS[0-9]+ Status$", Family: FamilyPowerSupply},
+			{Pattern: "^Intrusion$", Family: FamilyIntrusion},
+		},
+	},
+	{
+		Name:            "hpe",
+		ManufacturerIDs: []int{11, 47196},
+		Sensors: []SensorMapping{
+			{Pattern: "^Power Supply [0-9]+$", Family: FamilyPowerSupply},
+			{Pattern: "(?i)^(chassis )?intrusion$", Family: FamilyIntrusion},
+		},
+	},
+	{
+		Name:            "lenovo",
+		ManufacturerIDs: []int{2, 19046, 20301},
+		Sensors: []SensorMapping{
+			{Pattern: "^PSU[0-9]+( Status)?$", Family: FamilyPowerSupply},
+			{Pattern: "(?i)^(chassis )?intrusion$", Family: FamilyIntrusion},
+		},
+	},
+	{
+		Name:            "fujitsu",
+		ManufacturerIDs: []int{10368},
+		Sensors: []SensorMapping{
+			{Pattern: "^PSU[0-9]+$", Family: FamilyPowerSupply},
+			{Pattern: "(?i)^(chassis )?intrusion$", Family: FamilyIntrusion},
+		},
+	},
+}
+
+func init() {
+	for _, p := range builtinProfiles {
+		if err := p.compile(); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// DefaultProfiles returns the vendor profiles built into the exporter.
+func DefaultProfiles() []*Profile {
+	return append([]*Profile(nil), builtinProfiles...)
+}
+
+// LoadProfiles reads all *.json files in dir as vendor profiles and returns
+// them together with the built-in profiles. A profile read from dir replaces
+// the built-in profile of the same name.
+func LoadProfiles(dir string) ([]*Profile, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := DefaultProfiles()
+	for _, file := range files {
+		p, err := loadProfile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		replaced := false
+		for i := range profiles {
+			if profiles[i].Name == p.Name {
+				profiles[i] = p
+				replaced = true
+			}
+		}
+		if !replaced {
+			profiles = append(profiles, p)
+		}
+	}
+	return profiles, nil
+}
+
+func loadProfile(file string) (*Profile, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Profile
+	if err := json.Unmarshal(buf, &p); err != nil {
+		return nil, fmt.Errorf("could not parse profile %s: %v", file, err)
+	}
+	if p.Name == "" {
+		return nil, fmt.Errorf("profile %s has no name", file)
+	}
+	if err := p.compile(); err != nil {
+		return nil, fmt.Errorf("invalid profile %s: %v", file, err)
+	}
+	return &p, nil
+}
+
+func (p *Profile) compile() error {
+	for i := range p.Sensors {
+		m := &p.Sensors[i]
+		if _, ok := familyDescs[m.Family]; !ok {
+			return fmt.Errorf("unknown metric family %q", m.Family)
+		}
+		re, err := regexp.Compile(m.Pattern)
+		if err != nil {
+			return err
+		}
+		m.re = re
+	}
+	for _, c := range p.RawCommands {
+		if _, ok := familyDescs[c.Family]; !ok {
+			return fmt.Errorf("unknown metric family %q", c.Family)
+		}
+	}
+	for i := range p.PSUs {
+		if err := p.PSUs[i].validate(); err != nil {
+			return err
+		}
+	}
+	for i := range p.PMBusRegisters {
+		if err := p.PMBusRegisters[i].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Profile) lanChannel() int {
+	if p.LANChannel == 0 {
+		return 1
+	}
+	return p.LANChannel
+}
+
+// family returns the metric family of the sensor with the given name and
+// unit. An empty string is returned for sensors that are not exported.
+func (p *Profile) family(name, unit string) string {
+	for _, m := range p.Sensors {
+		if m.re.MatchString(name) {
+			return m.Family
+		}
+	}
+	return unitFamilies[strings.ToLower(unit)]
+}
+
+// findProfile returns the profile with the given name or nil.
+func findProfile(profiles []*Profile, name string) *Profile {
+	for _, p := range profiles {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// profileForManufacturer returns the profile matching the IANA manufacturer
+// ID of a BMC, falling back to the generic profile.
+func profileForManufacturer(profiles []*Profile, id int) *Profile {
+	for _, p := range profiles {
+		for _, m := range p.ManufacturerIDs {
+			if m == id {
+				return p
+			}
+		}
+	}
+	if p := findProfile(profiles, GenericProfile); p != nil {
+		return p
+	}
+	return findProfile(builtinProfiles, GenericProfile)
+}
+
+// parseManufacturerID extracts the manufacturer ID from the output of
+// `ipmitool mc info`.
+func parseManufacturerID(mcInfo []byte) (int, error) {
+	s := bufio.NewScanner(bytes.NewReader(mcInfo))
+	for s.Scan() {
+		parts := strings.SplitN(s.Text(), ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != "Manufacturer ID" {
+			continue
+		}
+		return strconv.Atoi(strings.TrimSpace(parts[1]))
+	}
+	return 0, fmt.Errorf("no manufacturer ID in mc info output")
+}
@@ -0,0 +1,146 @@
+package collector
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/prometheus/common/log"
+)
+
+func TestParseManufacturerID(t *testing.T) {
+	buf, err := ioutil.ReadFile("testdata/fixtures/supermicro/mc_info.out")
+	if err != nil {
+		t.Fatalf("cannot read test data: %v", err)
+	}
+
+	id, err := parseManufacturerID(buf)
+	if err != nil {
+		t.Fatalf("parsing mc info failed: %v", err)
+	}
+	if id != 10876 {
+		t.Errorf("want manufacturer ID 10876, got %d", id)
+	}
+
+	if _, err := parseManufacturerID([]byte("Device ID : 32\n")); err == nil {
+		t.Error("want error for output without manufacturer ID")
+	}
+}
+
+func TestProfileForManufacturer(t *testing.T) {
+	profiles := DefaultProfiles()
+	for id, want := range map[int]string{
+		10876: "supermicro",
+		674:   "dell",
+		47196: "hpe",
+		19046: "lenovo",
+		10368: "fujitsu",
+		1:     GenericProfile,
+	} {
+		if got := profileForManufacturer(profiles, id).Name; got != want {
+			t.Errorf("manufacturer %d: want profile %s, got %s", id, want, got)
+		}
+	}
+}
+
+func TestVendorProfileRetry(t *testing.T) {
+	mcInfo := "Device ID : 32\n"
+	e := NewExporter(backendFunc(func(args ...string) ([]byte, error) {
+		return []byte(mcInfo), nil
+	}))
+	e.Logger = log.NewNopLogger()
+
+	if got := e.vendorProfile().Name; got != GenericProfile {
+		t.Errorf("want profile %s without manufacturer ID, got %s", GenericProfile, got)
+	}
+	mcInfo = "Manufacturer ID : 674\n"
+	if got := e.vendorProfile().Name; got != "dell" {
+		t.Errorf("want the detection retried, got profile %s", got)
+	}
+}
+
+func TestProfileFamily(t *testing.T) {
+	profiles := DefaultProfiles()
+	for _, tc := range []struct {
+		profile, sensor, unit, want string
+	}{
+		{"supermicro", "PS1 Status", "discrete", FamilyPowerSupply},
+		{"supermicro", "Chassis Intru", "discrete", FamilyIntrusion},
+		{"supermicro", "CPU1 Temp", "degrees C", FamilyTemperature},
+		{"supermicro", "VBAT", "Volts", FamilyVoltage},
+		{"dell", "PS2 Status", "discrete", FamilyPowerSupply},
+		{"dell", "Intrusion", "discrete", FamilyIntrusion},
+		{"hpe", "Power Supply 1", "discrete", FamilyPowerSupply},
+		{"hpe", "Power Supplies", "discrete", ""},
+		{"hpe", "Chassis Intrusion", "discrete", FamilyIntrusion},
+		{"hpe", "Intrusion Count", "discrete", ""},
+		{"lenovo", "PSU1", "discrete", FamilyPowerSupply},
+		{"fujitsu", "FAN1 SYS", "RPM", FamilyFanSpeed},
+		{"generic", "Drive 0", "discrete", ""},
+	} {
+		p := findProfile(profiles, tc.profile)
+		if got := p.family(tc.sensor, tc.unit); got != tc.want {
+			t.Errorf("%s %q: want family %q, got %q", tc.profile, tc.sensor, tc.want, got)
+		}
+	}
+}
+
+func TestIntrusionSensors(t *testing.T) {
+	p := &Profile{
+		Name:    "test",
+		Sensors: []SensorMapping{{Pattern: "(?i)intrusion", Family: FamilyIntrusion}},
+	}
+	if err := p.compile(); err != nil {
+		t.Fatal(err)
+	}
+	e := NewExporter(backendFunc(func(args ...string) ([]byte, error) {
+		return []byte("Front Intrusion  | 0x0        | discrete   | 0x0080| na        | na        | na        | na        | na        | na        \n" +
+			"Rear Intrusion   | 0x1        | discrete   | 0x0180| na        | na        | na        | na        | na        | na        \n"), nil
+	}))
+	e.Logger = log.NewNopLogger()
+	e.Profile = p.Name
+	e.Profiles = []*Profile{p}
+
+	metrics, err := e.metrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 1 {
+		t.Fatalf("want the intrusion sensors combined into 1 metric, got %d", len(metrics))
+	}
+	if metrics[0].value != 1 {
+		t.Errorf("want the highest intrusion reading 1, got %v", metrics[0].value)
+	}
+}
+
+func TestLoadProfiles(t *testing.T) {
+	profiles, err := LoadProfiles("testdata/profiles")
+	if err != nil {
+		t.Fatalf("loading profiles failed: %v", err)
+	}
+	if len(profiles) != len(builtinProfiles)+1 {
+		t.Errorf("want %d profiles, got %d", len(builtinProfiles)+1, len(profiles))
+	}
+
+	dell := findProfile(profiles, "dell")
+	if got := dell.family("Inlet Temp", "degrees C"); got != FamilyTemperature {
+		t.Errorf("want overridden dell profile, got family %q", got)
+	}
+	if len(dell.Sensors) != 3 {
+		t.Errorf("want 3 dell sensor mappings, got %d", len(dell.Sensors))
+	}
+
+	acme := profileForManufacturer(profiles, 4242)
+	if acme.Name != "acme" {
+		t.Fatalf("want acme profile for manufacturer 4242, got %s", acme.Name)
+	}
+	if len(acme.RawCommands) != 1 || acme.RawCommands[0].Family != FamilyPowerSupply {
+		t.Errorf("unexpected raw commands %+v", acme.RawCommands)
+	}
+}
+
+func TestProfileUnknownFamily(t *testing.T) {
+	p := &Profile{Name: "broken", Sensors: []SensorMapping{{Pattern: ".*", Family: "nope"}}}
+	if err := p.compile(); err == nil {
+		t.Error("want error for unknown metric family")
+	}
+}
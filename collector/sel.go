@@ -0,0 +1,99 @@
+package collector
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CollectorSEL exposes the number of entries and the fullness of the system
+// event log.
+const CollectorSEL = "sel"
+
+// selRecordSize is the size of a SEL record in bytes.
+const selRecordSize = 16
+
+var (
+	selEntries = newDesc(
+		"sel", "entries",
+		"Number of entries in the system event log",
+		nil,
+	)
+	selFullness = newDesc(
+		"sel", "fullness_ratio",
+		"Used fraction of the space of the system event log",
+		nil,
+	)
+	selLastCleared = newDesc(
+		"sel", "last_cleared_timestamp_seconds",
+		"Time entries were last deleted from or cleared in the system event log",
+		nil,
+	)
+)
+
+func init() {
+	optionalCollectors[CollectorSEL] = &optionalCollector{
+		descs:   []*prometheus.Desc{selEntries, selFullness, selLastCleared},
+		collect: collectSEL,
+	}
+}
+
+// SELInfo is the state of the system event log reported by ipmitool sel info.
+type SELInfo struct {
+	Entries int
+	// Fullness is the used fraction of the SEL space.
+	Fullness float64
+	// LastCleared is the last time entries were deleted or the SEL was
+	// cleared, zero if not available.
+	LastCleared time.Time
+}
+
+// ParseSELInfo parses the output of ipmitool sel info. The fullness is
+// computed from the allocation units if the BMC supports Get SEL Allocation
+// Info, otherwise from the free space.
+func ParseSELInfo(output []byte) (*SELInfo, error) {
+	fields := parseFields(output)
+	entries, err := strconv.Atoi(fields["Entries"])
+	if err != nil {
+		return nil, fmt.Errorf("could not parse SEL entries %q", fields["Entries"])
+	}
+	info := &SELInfo{Entries: entries}
+
+	units, uerr := strconv.Atoi(fields["# of Alloc Units"])
+	free, ferr := strconv.Atoi(fields["# Free Units"])
+	freeBytes, berr := strconv.Atoi(strings.TrimSuffix(fields["Free Space"], " bytes"))
+	switch {
+	case uerr == nil && ferr == nil && units > 0:
+		info.Fullness = float64(units-free) / float64(units)
+	case berr == nil && entries*selRecordSize+freeBytes > 0:
+		used := entries * selRecordSize
+		info.Fullness = float64(used) / float64(used+freeBytes)
+	default:
+		return nil, fmt.Errorf("could not parse SEL free space %q", fields["Free Space"])
+	}
+
+	if t, err := time.ParseInLocation("01/02/2006 15:04:05", fields["Last Del Time"], time.Local); err == nil {
+		info.LastCleared = t
+	}
+	return info, nil
+}
+
+func collectSEL(e *Exporter, ch chan<- prometheus.Metric) error {
+	output, err := e.ipmiOutput("sel info")
+	if err != nil {
+		return err
+	}
+	info, err := ParseSELInfo(output)
+	if err != nil {
+		return err
+	}
+	ch <- prometheus.MustNewConstMetric(selEntries, prometheus.GaugeValue, float64(info.Entries))
+	ch <- prometheus.MustNewConstMetric(selFullness, prometheus.GaugeValue, info.Fullness)
+	if !info.LastCleared.IsZero() {
+		ch <- prometheus.MustNewConstMetric(selLastCleared, prometheus.GaugeValue, float64(info.LastCleared.Unix()))
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redacted replaces the secrets in a Trace.
+const redacted = "<redacted>"
+
+// Trace records the commands run during a collection, their output and the
+// decisions of the parsers to debug a collection. Secrets added with Redact
+// are replaced in all lines.
+type Trace struct {
+	mu      sync.Mutex
+	start   time.Time
+	buf     bytes.Buffer
+	secrets []string
+	now     func() time.Time
+}
+
+// NewTrace returns an empty trace starting now.
+func NewTrace() *Trace {
+	return &Trace{start: time.Now(), now: time.Now}
+}
+
+// Redact adds a secret that is never written to the trace.
+func (t *Trace) Redact(secret string) {
+	if t == nil || secret == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.secrets = append(t.secrets, secret)
+}
+
+// Printf adds a line prefixed with the time since the start of the trace. A
+// nil trace discards all lines.
+func (t *Trace) Printf(format string, args ...interface{}) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	line := fmt.Sprintf(format, args...)
+	for _, s := range t.secrets {
+		line = strings.Replace(line, s, redacted, -1)
+	}
+	fmt.Fprintf(&t.buf, "[%8.3fs] %s\n", t.now().Sub(t.start).Seconds(), line)
+}
+
+// Output adds the output of a command indented below the previous line.
+func (t *Trace) Output(output []byte) {
+	if t == nil {
+		return
+	}
+	out := strings.TrimRight(string(output), "\n")
+	if out == "" {
+		t.Printf("  (no output)")
+		return
+	}
+	for _, line := range strings.Split(out, "\n") {
+		t.Printf("  | %s", line)
+	}
+}
+
+// WriteTo writes the lines of the trace to w.
+func (t *Trace) WriteTo(w io.Writer) (int64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n, err := w.Write(t.buf.Bytes())
+	return int64(n), err
+}
+
+func (e *Exporter) tracef(format string, args ...interface{}) {
+	e.Trace.Printf(format, args...)
+	e.collectionTrace().Printf(format, args...)
+}
+
+// collectionTrace returns the trace of the running collection, nil unless
+// TraceCollections is set.
+func (e *Exporter) collectionTrace() *Trace {
+	e.traceMu.Lock()
+	defer e.traceMu.Unlock()
+	return e.trace
+}
+
+func (e *Exporter) setCollectionTrace(t *Trace) {
+	e.traceMu.Lock()
+	defer e.traceMu.Unlock()
+	e.trace = t
+}
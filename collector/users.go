@@ -0,0 +1,159 @@
+package collector
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CollectorUsers exposes the user accounts of the BMC for security audits.
+const CollectorUsers = "users"
+
+var (
+	bmcUserInfo = newDesc(
+		"bmc", "user_info",
+		"User account of the BMC",
+		[]string{"id", "name", "privilege", "enabled", "channel"},
+	)
+
+	bmcUserDefaultName = newDesc(
+		"bmc", "user_default_name_enabled",
+		"Indicates if an enabled user account has a vendor default name like ADMIN or root",
+		[]string{"id", "name"},
+	)
+)
+
+// defaultUserNames are the names of the factory default accounts of the BMC
+// vendors.
+var defaultUserNames = map[string]bool{
+	"ADMIN":         true,
+	"admin":         true,
+	"Administrator": true,
+	"root":          true,
+	"USERID":        true,
+}
+
+var userPrivileges = map[byte]string{
+	0x1: "CALLBACK",
+	0x2: "USER",
+	0x3: "OPERATOR",
+	0x4: "ADMINISTRATOR",
+	0x5: "OEM",
+	0xf: "NO ACCESS",
+}
+
+func init() {
+	optionalCollectors[CollectorUsers] = &optionalCollector{
+		descs:   []*prometheus.Desc{bmcUserInfo, bmcUserDefaultName},
+		collect: collectUsers,
+	}
+}
+
+// userAccess is the answer to Get User Access.
+type userAccess struct {
+	maxIDs    int
+	enabled   string
+	privilege string
+}
+
+func parseUserAccess(output []byte) (*userAccess, error) {
+	b, err := parseRaw(output)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse user access: %v", err)
+	}
+	if len(b) != 4 {
+		return nil, fmt.Errorf("want 4 bytes of user access, got %q", output)
+	}
+	a := &userAccess{
+		maxIDs:    int(b[0] & 0x3f),
+		privilege: lookupName(userPrivileges, b[3]&0x0f),
+	}
+	switch b[1] >> 6 {
+	case 1:
+		a.enabled = "true"
+	case 2:
+		a.enabled = "false"
+	default:
+		a.enabled = "unspecified"
+	}
+	return a, nil
+}
+
+// parseUserName parses the answer to Get User Name.
+func parseUserName(output []byte) (string, error) {
+	b, err := parseRaw(output)
+	if err != nil {
+		return "", fmt.Errorf("could not parse user name: %v", err)
+	}
+	if len(b) != 16 {
+		return "", fmt.Errorf("want 16 bytes of user name, got %q", output)
+	}
+	return strings.TrimRight(string(b), "\x00"), nil
+}
+
+// readUser reads the access and name of a user slot of the channel.
+func (e *Exporter) readUser(channel, id int) (*userAccess, string, error) {
+	cmd := fmt.Sprintf("raw 0x06 0x44 0x%02x 0x%02x", channel, id)
+	output, err := e.ipmiOutput(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+	access, err := parseUserAccess(output)
+	if err != nil {
+		e.logError(cmd, cmd+"/parse", err)
+		return nil, "", loggedError{err}
+	}
+
+	cmd = fmt.Sprintf("raw 0x06 0x46 0x%02x", id)
+	output, err = e.ipmiOutput(cmd)
+	if err != nil {
+		return access, "", err
+	}
+	name, err := parseUserName(output)
+	if err != nil {
+		e.logError(cmd, cmd+"/parse", err)
+		return access, "", loggedError{err}
+	}
+	return access, name, nil
+}
+
+// collectUsers reads the user slots of the LAN channel. The number of slots
+// is read from the first one, so it fails if the first slot cannot be read.
+// Other slots that cannot be read are skipped and reported in the error.
+func collectUsers(e *Exporter, ch chan<- prometheus.Metric) error {
+	channel := e.vendorProfile().lanChannel()
+	label := strconv.Itoa(channel)
+
+	var failed []string
+	for id, max := 1, 1; id <= max; id++ {
+		access, name, err := e.readUser(channel, id)
+		if access != nil {
+			max = access.maxIDs
+		}
+		if err != nil {
+			if id == 1 {
+				return err
+			}
+			e.tracef("Skipping user %d: %v", id, err)
+			failed = append(failed, strconv.Itoa(id))
+			continue
+		}
+		if name == "" && access.enabled != "true" {
+			continue
+		}
+
+		idLabel := strconv.Itoa(id)
+		ch <- prometheus.MustNewConstMetric(bmcUserInfo, prometheus.GaugeValue, 1, idLabel, name, access.privilege, access.enabled, label)
+		if defaultUserNames[name] {
+			ch <- prometheus.MustNewConstMetric(bmcUserDefaultName, prometheus.GaugeValue, boolValue(access.enabled == "true"), idLabel, name)
+		}
+	}
+	if len(failed) > 0 {
+		// The errors were logged by slot, and must not disable the
+		// collector as unsupported.
+		return loggedError{fmt.Errorf("could not read users %s", strings.Join(failed, ", "))}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package collector
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CollectorWatchdog exposes the state of the BMC watchdog timer.
+const CollectorWatchdog = "watchdog"
+
+var (
+	watchdogRunning = newDesc(
+		"watchdog", "running",
+		"Indicates if the watchdog timer is running",
+		nil,
+	)
+
+	watchdogInfo = newDesc(
+		"watchdog", "info",
+		"Configuration of the watchdog timer",
+		[]string{"timer_use", "timeout_action", "pretimeout_interrupt"},
+	)
+
+	watchdogInitialCountdown = newDesc(
+		"watchdog", "initial_countdown_seconds",
+		"Configured timeout of the watchdog timer",
+		nil,
+	)
+
+	watchdogCurrentCountdown = newDesc(
+		"watchdog", "current_countdown_seconds",
+		"Time left until the watchdog timer expires",
+		nil,
+	)
+
+	watchdogPretimeout = newDesc(
+		"watchdog", "pretimeout_seconds",
+		"Time before the timeout at which the pre-timeout interrupt is raised",
+		nil,
+	)
+)
+
+var watchdogTimerUses = map[byte]string{
+	1: "BIOS FRB2",
+	2: "BIOS/POST",
+	3: "OS Load",
+	4: "SMS/OS",
+	5: "OEM",
+}
+
+var watchdogTimeoutActions = map[byte]string{
+	0: "No action",
+	1: "Hard Reset",
+	2: "Power Down",
+	3: "Power Cycle",
+}
+
+var watchdogPretimeoutInterrupts = map[byte]string{
+	0: "None",
+	1: "SMI",
+	2: "NMI / Diagnostic Interrupt",
+	3: "Messaging Interrupt",
+}
+
+func init() {
+	optionalCollectors[CollectorWatchdog] = &optionalCollector{
+		descs: []*prometheus.Desc{
+			watchdogRunning,
+			watchdogInfo,
+			watchdogInitialCountdown,
+			watchdogCurrentCountdown,
+			watchdogPretimeout,
+		},
+		collect: collectWatchdog,
+	}
+}
+
+// watchdog is the answer to Get Watchdog Timer.
+type watchdog struct {
+	running             bool
+	timerUse            string
+	timeoutAction       string
+	pretimeoutInterrupt string
+	pretimeout          float64
+	initialCountdown    float64
+	currentCountdown    float64
+}
+
+func parseWatchdog(output []byte) (*watchdog, error) {
+	b, err := parseRaw(output)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse watchdog timer: %v", err)
+	}
+	if len(b) != 8 {
+		return nil, fmt.Errorf("want 8 bytes of watchdog timer, got %q", output)
+	}
+	return &watchdog{
+		running:             b[0]&0x40 != 0,
+		timerUse:            lookupName(watchdogTimerUses, b[0]&0x07),
+		timeoutAction:       lookupName(watchdogTimeoutActions, b[1]&0x07),
+		pretimeoutInterrupt: lookupName(watchdogPretimeoutInterrupts, b[1]>>4&0x07),
+		pretimeout:          float64(b[2]),
+		// The countdowns are given in 100 ms.
+		initialCountdown: float64(uint16(b[4])|uint16(b[5])<<8) / 10,
+		currentCountdown: float64(uint16(b[6])|uint16(b[7])<<8) / 10,
+	}, nil
+}
+
+func collectWatchdog(e *Exporter, ch chan<- prometheus.Metric) error {
+	output, err := e.ipmiOutput("raw 0x06 0x25")
+	if err != nil {
+		return err
+	}
+	w, err := parseWatchdog(output)
+	if err != nil {
+		return err
+	}
+
+	ch <- prometheus.MustNewConstMetric(watchdogRunning, prometheus.GaugeValue, boolValue(w.running))
+	ch <- prometheus.MustNewConstMetric(watchdogInfo, prometheus.GaugeValue, 1, w.timerUse, w.timeoutAction, w.pretimeoutInterrupt)
+	ch <- prometheus.MustNewConstMetric(watchdogInitialCountdown, prometheus.GaugeValue, w.initialCountdown)
+	ch <- prometheus.MustNewConstMetric(watchdogCurrentCountdown, prometheus.GaugeValue, w.currentCountdown)
+	ch <- prometheus.MustNewConstMetric(watchdogPretimeout, prometheus.GaugeValue, w.pretimeout)
+	return nil
+}
+
+func lookupName(names map[byte]string, v byte) string {
+	if name, ok := names[v]; ok {
+		return name
+	}
+	return fmt.Sprintf("Reserved (0x%02x)", v)
+}
@@ -0,0 +1,633 @@
+// Package config implements the configuration file of the exporter, which
+// defines the modules used to probe remote BMCs.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultModule is the name of the module used if a probe names none.
+const DefaultModule = "default"
+
+// LocalTarget names the BMC of the host running the exporter in the targets
+// of SEL. It takes precedence over a named target with the same name.
+const LocalTarget = "local"
+
+var (
+	// ErrTargetNotAllowed is returned for targets outside of the allowed
+	// targets of a module.
+	ErrTargetNotAllowed = errors.New("target not allowed")
+	// ErrUnknownTarget is returned for targets that are not defined in the
+	// configuration if a module only allows named targets.
+	ErrUnknownTarget = errors.New("unknown target")
+)
+
+var labelNameRE = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+// lookupIP resolves host names of targets, replaced in tests.
+var lookupIP = net.LookupIP
+
+// Config is the configuration of the exporter.
+type Config struct {
+	Modules map[string]*Module `json:"modules"`
+	// Targets are BMCs probed by a symbolic name instead of their address.
+	Targets map[string]*Target `json:"targets"`
+	// Credentials replace the credentials of the modules for the targets
+	// they match. The first matching entry is used.
+	Credentials []*CredentialOverride `json:"credentials"`
+	// Discovery configures the discovery of BMCs by scanning subnets.
+	Discovery *Discovery `json:"discovery"`
+	// SEL configures the forwarding of new system event log records.
+	SEL *SEL `json:"sel"`
+
+	mu         sync.RWMutex
+	discovered map[string]*Target
+}
+
+// Module configures how remote BMCs are probed.
+type Module struct {
+	Credentials
+	// Interface is the ipmitool interface, lanplus by default.
+	Interface string `json:"interface"`
+	// Privilege is the privilege level of the session, e.g. USER or
+	// ADMINISTRATOR.
+	Privilege string `json:"privilege"`
+	// Timeout of an ipmitool command, no timeout by default.
+	Timeout Duration `json:"timeout"`
+	// AllowedTargets are the CIDR ranges and host name patterns of the
+	// addresses probed with the module besides the named targets. Host
+	// names matching no pattern must resolve to addresses in the CIDR
+	// ranges. Only named targets are probed if empty.
+	AllowedTargets []string `json:"allowed_targets"`
+	// NamedTargetsOnly rejects targets not defined in Targets.
+	NamedTargetsOnly bool `json:"named_targets_only"`
+	// Collectors are the optional collectors enabled for the module. The
+	// collectors given on the command line are used if nil.
+	Collectors []string `json:"collectors"`
+}
+
+// Target is a BMC with a symbolic name.
+type Target struct {
+	// Address of the BMC as host or host:port.
+	Address string `json:"address"`
+	// Module used to probe the BMC, exposed by service discovery.
+	Module string `json:"module"`
+	// Labels attached to the target by service discovery, e.g. rack or
+	// site.
+	Labels map[string]string `json:"labels"`
+}
+
+// Discovery configures the discovery of BMCs. The addresses of Subnets are
+// scanned every Interval with RMCP presence pings, and responding BMCs are
+// added to the targets, probed with Module and labeled with Labels.
+type Discovery struct {
+	Subnets []string `json:"subnets"`
+	// Port is the RMCP port scanned, 623 by default.
+	Port        string            `json:"port"`
+	Interval    Duration          `json:"interval"`
+	Timeout     Duration          `json:"timeout"`
+	Concurrency int               `json:"concurrency"`
+	Module      string            `json:"module"`
+	Labels      map[string]string `json:"labels"`
+}
+
+// SEL configures the tailing of the system event logs of Targets. New
+// records are forwarded to the webhook, to standard output if Stdout is set,
+// to the clients of the /events stream, and as alerts to Alertmanager if they
+// match one of its rules.
+type SEL struct {
+	// Targets are the named targets whose SEL is tailed, or LocalTarget.
+	Targets []string `json:"targets"`
+	// Interval between two reads of the SEL, one minute by default.
+	Interval Duration `json:"interval"`
+	// StateFile persists the last record forwarded per target across
+	// restarts if set.
+	StateFile    string        `json:"state_file"`
+	Webhook      *Webhook      `json:"webhook"`
+	Stdout       bool          `json:"stdout"`
+	Alertmanager *Alertmanager `json:"alertmanager"`
+	Clear        *SELClear     `json:"clear"`
+}
+
+// SELClear archives the SEL of a target to ArchiveDir and clears it when its
+// fullness reaches Threshold, so BMCs that stop logging when the SEL is full
+// keep logging. With DryRun the SEL is neither archived nor cleared.
+type SELClear struct {
+	// Threshold is the used fraction of the SEL space, 0.8 by default.
+	Threshold  float64 `json:"threshold"`
+	ArchiveDir string  `json:"archive_dir"`
+	DryRun     bool    `json:"dry_run"`
+}
+
+// Webhook is an HTTP endpoint receiving events as JSON POST requests.
+type Webhook struct {
+	URL string `json:"url"`
+	// Timeout of a request, ten seconds by default.
+	Timeout Duration `json:"timeout"`
+}
+
+// Alertmanager receives alerts for the SEL records matching its rules through
+// its v2 API at URL.
+type Alertmanager struct {
+	URL string `json:"url"`
+	// Timeout of a request, ten seconds by default.
+	Timeout Duration `json:"timeout"`
+	// ResolveAfter is the time after which alerts resolve unless a
+	// deasserted record resolves them earlier, one hour by default.
+	ResolveAfter Duration     `json:"resolve_after"`
+	Rules        []*AlertRule `json:"rules"`
+}
+
+// AlertRule raises the alert named Alert for SEL records whose sensor and
+// description match the regular expressions Sensor and Description, e.g.
+// "Memory" and "Uncorrectable ECC". Empty expressions match all records.
+type AlertRule struct {
+	Alert       string            `json:"alert"`
+	Sensor      string            `json:"sensor"`
+	Description string            `json:"description"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// maxDiscoveryHosts limits the number of addresses of a discovery subnet.
+const maxDiscoveryHosts = 1 << 16
+
+// Duration is a time.Duration given as string like "30s" in JSON.
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON formats the duration as string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// TargetGroup is a group of targets in the Prometheus HTTP and file service
+// discovery format.
+type TargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// Credentials of a BMC user. Passwords are never part of the configuration
+// itself but read from a file or an environment variable.
+type Credentials struct {
+	User string `json:"user"`
+	// PasswordFile is the path of a file containing the password, as
+	// used by `ipmitool -f`.
+	PasswordFile string `json:"password_file"`
+	// PasswordEnv is the name of an environment variable of the exporter
+	// containing the password.
+	PasswordEnv string `json:"password_env"`
+}
+
+// Password returns the password from the environment variable of c, or an
+// empty string if c has a password file or no password. It is used to pass
+// passwords to ipmitool, which reads password files itself.
+func (c Credentials) Password() (string, error) {
+	if c.PasswordEnv == "" {
+		return "", nil
+	}
+	p, ok := os.LookupEnv(c.PasswordEnv)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", c.PasswordEnv)
+	}
+	return p, nil
+}
+
+// Secret returns the password of c, read from the password file or the
+// environment variable.
+func (c Credentials) Secret() (string, error) {
+	if c.PasswordFile == "" {
+		return c.Password()
+	}
+	buf, err := ioutil.ReadFile(c.PasswordFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(buf), "\r\n"), nil
+}
+
+func (c Credentials) validate() error {
+	if c.PasswordFile != "" && c.PasswordEnv != "" {
+		return fmt.Errorf("password_file and password_env are mutually exclusive")
+	}
+	return nil
+}
+
+// CredentialOverride assigns credentials to the targets matching one of its
+// patterns. Patterns are either CIDR ranges matching IP addresses or glob
+// patterns as understood by path.Match matching host names and target names.
+type CredentialOverride struct {
+	Credentials
+	Targets []string `json:"targets"`
+}
+
+// Default returns the configuration used without a configuration file.
+func Default() *Config {
+	return &Config{
+		Modules: map[string]*Module{DefaultModule: {Interface: "lanplus"}},
+	}
+}
+
+// Load reads the configuration from a JSON file.
+func Load(file string) (*Config, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(buf, &c); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", file, err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration %s: %v", file, err)
+	}
+	return &c, nil
+}
+
+func (c *Config) validate() error {
+	if c.Modules == nil {
+		c.Modules = map[string]*Module{}
+	}
+	if _, ok := c.Modules[DefaultModule]; !ok {
+		c.Modules[DefaultModule] = &Module{}
+	}
+	for name, m := range c.Modules {
+		if m == nil {
+			return fmt.Errorf("module %s is empty", name)
+		}
+		if m.Interface == "" {
+			m.Interface = "lanplus"
+		}
+		if err := m.Credentials.validate(); err != nil {
+			return fmt.Errorf("module %s: %v", name, err)
+		}
+		if err := validatePatterns(m.AllowedTargets); err != nil {
+			return fmt.Errorf("module %s: %v", name, err)
+		}
+	}
+	for name, t := range c.Targets {
+		if t == nil || t.Address == "" {
+			return fmt.Errorf("target %s has no address", name)
+		}
+		if t.Module == "" {
+			t.Module = DefaultModule
+		}
+		if _, ok := c.Modules[t.Module]; !ok {
+			return fmt.Errorf("target %s: unknown module %s", name, t.Module)
+		}
+		if err := validateLabels(t.Labels); err != nil {
+			return fmt.Errorf("target %s: %v", name, err)
+		}
+	}
+	if d := c.Discovery; d != nil {
+		if err := d.validate(c); err != nil {
+			return fmt.Errorf("discovery: %v", err)
+		}
+	}
+	if s := c.SEL; s != nil {
+		if err := s.validate(c); err != nil {
+			return fmt.Errorf("sel: %v", err)
+		}
+	}
+	for i, o := range c.Credentials {
+		if err := o.Credentials.validate(); err != nil {
+			return fmt.Errorf("credentials %d: %v", i, err)
+		}
+		if err := validatePatterns(o.Targets); err != nil {
+			return fmt.Errorf("credentials %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func (d *Discovery) validate(c *Config) error {
+	if d.Port == "" {
+		d.Port = "623"
+	}
+	if d.Interval == 0 {
+		d.Interval = Duration(time.Hour)
+	}
+	if d.Timeout == 0 {
+		d.Timeout = Duration(time.Second)
+	}
+	if d.Concurrency <= 0 {
+		d.Concurrency = 64
+	}
+	if d.Module == "" {
+		d.Module = DefaultModule
+	}
+	if _, ok := c.Modules[d.Module]; !ok {
+		return fmt.Errorf("unknown module %s", d.Module)
+	}
+	for _, s := range d.Subnets {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return err
+		}
+		ones, bits := n.Mask.Size()
+		if bits-ones > 16 {
+			return fmt.Errorf("subnet %s has more than %d addresses", s, maxDiscoveryHosts)
+		}
+	}
+	return validateLabels(d.Labels)
+}
+
+func (s *SEL) validate(c *Config) error {
+	if s.Interval == 0 {
+		s.Interval = Duration(time.Minute)
+	}
+	for _, t := range s.Targets {
+		if _, ok := c.Targets[t]; !ok && t != LocalTarget {
+			return fmt.Errorf("unknown target %s", t)
+		}
+	}
+	if w := s.Webhook; w != nil {
+		if err := validateURL(w.URL); err != nil {
+			return fmt.Errorf("webhook: %v", err)
+		}
+		if w.Timeout == 0 {
+			w.Timeout = Duration(10 * time.Second)
+		}
+	}
+	if a := s.Alertmanager; a != nil {
+		if err := a.validate(); err != nil {
+			return fmt.Errorf("alertmanager: %v", err)
+		}
+	}
+	if cl := s.Clear; cl != nil {
+		if cl.Threshold == 0 {
+			cl.Threshold = 0.8
+		}
+		if cl.Threshold < 0 || cl.Threshold > 1 {
+			return fmt.Errorf("clear: threshold %v is not between 0 and 1", cl.Threshold)
+		}
+		if cl.ArchiveDir == "" && !cl.DryRun {
+			return fmt.Errorf("clear: no archive_dir")
+		}
+	}
+	return nil
+}
+
+func (a *Alertmanager) validate() error {
+	if err := validateURL(a.URL); err != nil {
+		return err
+	}
+	if a.Timeout == 0 {
+		a.Timeout = Duration(10 * time.Second)
+	}
+	if a.ResolveAfter == 0 {
+		a.ResolveAfter = Duration(time.Hour)
+	}
+	for i, r := range a.Rules {
+		if r == nil || r.Alert == "" {
+			return fmt.Errorf("rule %d has no alert name", i)
+		}
+		for _, re := range []string{r.Sensor, r.Description} {
+			if _, err := regexp.Compile(re); err != nil {
+				return fmt.Errorf("rule %s: %v", r.Alert, err)
+			}
+		}
+		if err := validateLabels(r.Labels); err != nil {
+			return fmt.Errorf("rule %s: %v", r.Alert, err)
+		}
+	}
+	return nil
+}
+
+func validateURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid URL %q", s)
+	}
+	return nil
+}
+
+func validateLabels(labels map[string]string) error {
+	for l := range labels {
+		if !labelNameRE.MatchString(l) || strings.HasPrefix(l, "__") || l == "module" {
+			return fmt.Errorf("invalid label name %q", l)
+		}
+	}
+	return nil
+}
+
+func validatePatterns(patterns []string) error {
+	for _, p := range patterns {
+		if strings.Contains(p, "/") {
+			if _, _, err := net.ParseCIDR(p); err != nil {
+				return err
+			}
+		} else if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q", p)
+		}
+	}
+	return nil
+}
+
+// Module returns the module with the given name. The default module is
+// returned for an empty name.
+func (c *Config) Module(name string) (*Module, bool) {
+	if name == "" {
+		name = DefaultModule
+	}
+	m, ok := c.Modules[name]
+	return m, ok
+}
+
+// Target returns the named target with the given name, either from Targets
+// or discovered.
+func (c *Config) Target(name string) (*Target, bool) {
+	if t, ok := c.Targets[name]; ok {
+		return t, true
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	t, ok := c.discovered[name]
+	return t, ok
+}
+
+// TargetLabels returns the labels of a named target and its module, or nil
+// for other targets.
+func (c *Config) TargetLabels(name string) map[string]string {
+	t, ok := c.Target(name)
+	if !ok {
+		return nil
+	}
+	labels := map[string]string{"module": t.Module}
+	for k, v := range t.Labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// SetDiscovered replaces the discovered targets. Targets with the name of a
+// configured target are ignored.
+func (c *Config) SetDiscovered(targets map[string]*Target) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.discovered = targets
+}
+
+// ServiceDiscovery returns a target group for each named target, labeled with
+// its module and labels. The targets are sorted by name.
+func (c *Config) ServiceDiscovery() []*TargetGroup {
+	c.mu.RLock()
+	targets := make(map[string]*Target, len(c.Targets)+len(c.discovered))
+	for name, t := range c.discovered {
+		targets[name] = t
+	}
+	c.mu.RUnlock()
+	for name, t := range c.Targets {
+		targets[name] = t
+	}
+
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	groups := make([]*TargetGroup, 0, len(names))
+	for _, name := range names {
+		t := targets[name]
+		labels := map[string]string{
+			"module":         t.Module,
+			"__param_module": t.Module,
+		}
+		for k, v := range t.Labels {
+			labels[k] = v
+		}
+		groups = append(groups, &TargetGroup{Targets: []string{name}, Labels: labels})
+	}
+	return groups
+}
+
+// WriteFileSD writes the service discovery target groups to file. The file is
+// replaced atomically so Prometheus never reads a partial file.
+func (c *Config) WriteFileSD(file string) error {
+	buf, err := json.MarshalIndent(c.ServiceDiscovery(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(buf, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// Resolve returns the address of target probed with module m. Targets are
+// either names of Targets or addresses given as host or host:port. Addresses
+// not allowed by m are rejected with ErrTargetNotAllowed, and addresses if m
+// only allows named targets with ErrUnknownTarget.
+//
+// Host names allowed by a CIDR range are replaced by the checked address, so
+// a name resolving to another address when ipmitool resolves it again cannot
+// bypass the ranges.
+func (c *Config) Resolve(m *Module, target string) (string, error) {
+	if t, ok := c.Target(target); ok {
+		return t.Address, nil
+	}
+	if m.NamedTargetsOnly {
+		return "", ErrUnknownTarget
+	}
+
+	host := Host(target)
+	if matchGlob(m.AllowedTargets, host) {
+		return target, nil
+	}
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		var err error
+		if ips, err = lookupIP(host); err != nil || len(ips) == 0 {
+			return "", ErrTargetNotAllowed
+		}
+	}
+	for _, ip := range ips {
+		if !matchCIDR(m.AllowedTargets, ip) {
+			return "", ErrTargetNotAllowed
+		}
+	}
+	if _, port, err := net.SplitHostPort(target); err == nil {
+		return net.JoinHostPort(ips[0].String(), port), nil
+	}
+	return ips[0].String(), nil
+}
+
+// CredentialsFor returns the credentials used for target with module m.
+func (c *Config) CredentialsFor(m *Module, target string) Credentials {
+	host := Host(target)
+	if t, ok := c.Target(target); ok {
+		host = Host(t.Address)
+	}
+	ip := net.ParseIP(host)
+	for _, o := range c.Credentials {
+		if matchGlob(o.Targets, target) || matchGlob(o.Targets, host) || (ip != nil && matchCIDR(o.Targets, ip)) {
+			return o.Credentials
+		}
+	}
+	return m.Credentials
+}
+
+// matchGlob reports whether name matches one of the glob patterns.
+func matchGlob(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if strings.Contains(p, "/") {
+			continue
+		}
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchCIDR reports whether ip is in one of the CIDR ranges of patterns.
+func matchCIDR(patterns []string, ip net.IP) bool {
+	for _, p := range patterns {
+		if !strings.Contains(p, "/") {
+			continue
+		}
+		if _, n, err := net.ParseCIDR(p); err == nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Host returns the host of a target given as host or host:port.
+func Host(target string) string {
+	if host, _, err := net.SplitHostPort(target); err == nil {
+		return host
+	}
+	return strings.Trim(target, "[]")
+}
@@ -0,0 +1,188 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	c, err := Load("testdata/ipmi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, ok := c.Module("")
+	if !ok || m.Interface != "lanplus" || m.User != "monitor" || m.Privilege != "USER" {
+		t.Errorf("unexpected default module %+v", m)
+	}
+	admin, ok := c.Module("admin")
+	if !ok || admin.Interface != "lan" || admin.PasswordEnv != "IPMI_ADMIN_PASSWORD" {
+		t.Errorf("unexpected admin module %+v", admin)
+	}
+	if _, ok := c.Module("nope"); ok {
+		t.Error("want no module nope")
+	}
+	if s := c.SEL; s == nil || time.Duration(s.Interval) != time.Minute || time.Duration(s.Webhook.Timeout) != 10*time.Second {
+		t.Errorf("unexpected sel defaults %+v", s)
+	}
+	if a := c.SEL.Alertmanager; a == nil || time.Duration(a.ResolveAfter) != time.Hour || len(a.Rules) != 1 {
+		t.Errorf("unexpected alertmanager configuration %+v", a)
+	}
+	if l := c.TargetLabels("node1"); l["module"] != "named" || l["rack"] != "r2" {
+		t.Errorf("unexpected labels of node1 %v", l)
+	}
+
+	for target, want := range map[string]string{
+		"10.1.2.3":                "lab",
+		"10.1.2.3:623":            "lab",
+		"10.2.0.1":                "monitor",
+		"bmc-lab-7":               "lab",
+		"bmc-lab-7:6230":          "lab",
+		"bmc1.rack2.example.com":  "rack2",
+		"bmc1.rack3.example.com":  "monitor",
+		"[fd00::1]:623":           "monitor",
+		"bmc1.rack2.example.com.": "monitor",
+	} {
+		if got := c.CredentialsFor(m, target).User; got != want {
+			t.Errorf("%s: want user %s, got %s", target, want, got)
+		}
+	}
+}
+
+func TestPassword(t *testing.T) {
+	os.Setenv("IPMI_TEST_PASSWORD", "s3cret")
+	defer os.Unsetenv("IPMI_TEST_PASSWORD")
+
+	p, err := Credentials{PasswordEnv: "IPMI_TEST_PASSWORD"}.Password()
+	if err != nil || p != "s3cret" {
+		t.Errorf("want password from environment, got %q, %v", p, err)
+	}
+	p, err = Credentials{PasswordFile: "/etc/ipmi_exporter/monitor.password"}.Password()
+	if err != nil || p != "" {
+		t.Errorf("want no password for password file, got %q, %v", p, err)
+	}
+	if _, err := (Credentials{PasswordEnv: "IPMI_TEST_MISSING"}).Password(); err == nil {
+		t.Error("want error for missing environment variable")
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	for _, c := range []string{
+		`{"modules": {"default": {"password_file": "a", "password_env": "B"}}}`,
+		`{"credentials": [{"targets": ["10.0.0.0/33"]}]}`,
+		`{"credentials": [{"targets": ["bmc["]}]}`,
+		`{"modules": {"default": null}}`,
+		`{"modules": []}`,
+		`{"targets": {"node1": {"address": "10.0.0.1", "module": "nope"}}}`,
+		`{"targets": {"node1": {"address": "10.0.0.1", "labels": {"__address__": "x"}}}}`,
+		`{"targets": {"node1": {}}}`,
+		`{"sel": {"targets": ["node1"]}}`,
+		`{"sel": {"webhook": {"url": "hooks.example.com/sel"}}}`,
+		`{"sel": {"alertmanager": {"url": "http://alertmanager:9093", "rules": [{"sensor": "Memory"}]}}}`,
+		`{"sel": {"clear": {"threshold": 80, "archive_dir": "/var/lib/ipmi_exporter/sel"}}}`,
+		`{"sel": {"clear": {}}}`,
+		`{"sel": {"alertmanager": {"url": "http://alertmanager:9093", "rules": [{"alert": "ECC", "sensor": "Memory ("}]}}}`,
+	} {
+		f, err := ioutil.TempFile("", "ipmi_exporter")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(c)
+		f.Close()
+		_, err = Load(f.Name())
+		os.Remove(f.Name())
+		if err == nil {
+			t.Errorf("want error for %s", c)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	c, err := Load("testdata/ipmi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(f func(string) ([]net.IP, error)) { lookupIP = f }(lookupIP)
+	lookupIP = func(host string) ([]net.IP, error) {
+		switch host {
+		case "bmc1.internal":
+			return []net.IP{net.ParseIP("10.3.0.1")}, nil
+		case "evil.example.com":
+			return []net.IP{net.ParseIP("10.3.0.2"), net.ParseIP("169.254.169.254")}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	for _, tc := range []struct {
+		module, target, address string
+		err                     error
+	}{
+		{"default", "192.168.1.1", "", ErrTargetNotAllowed},
+		{"default", "bmc1.internal", "", ErrTargetNotAllowed},
+		{"default", "lab-node", "192.168.1.5", nil},
+		{"restricted", "10.1.2.3:623", "10.1.2.3:623", nil},
+		{"restricted", "[10.1.2.3]:623", "10.1.2.3:623", nil},
+		{"restricted", "192.168.1.1", "", ErrTargetNotAllowed},
+		{"restricted", "bmc7.bmc.example.com", "bmc7.bmc.example.com", nil},
+		{"restricted", "bmc1.internal", "10.3.0.1", nil},
+		{"restricted", "bmc1.internal:6230", "10.3.0.1:6230", nil},
+		{"restricted", "evil.example.com", "", ErrTargetNotAllowed},
+		{"restricted", "unresolvable", "", ErrTargetNotAllowed},
+		{"restricted", "lab-node", "192.168.1.5", nil},
+		{"named", "node1", "10.1.9.9:623", nil},
+		{"named", "10.1.9.9", "", ErrUnknownTarget},
+	} {
+		m, _ := c.Module(tc.module)
+		address, err := c.Resolve(m, tc.target)
+		if address != tc.address || err != tc.err {
+			t.Errorf("%s with %s: want %q, %v, got %q, %v", tc.target, tc.module, tc.address, tc.err, address, err)
+		}
+	}
+
+	m, _ := c.Module("named")
+	for target, want := range map[string]string{"node1": "lab", "lab-node": "rack2"} {
+		if got := c.CredentialsFor(m, target).User; got != want {
+			t.Errorf("%s: want user %s, got %s", target, want, got)
+		}
+	}
+}
+
+func TestServiceDiscovery(t *testing.T) {
+	c, err := Load("testdata/ipmi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "ipmi_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "ipmi.json")
+	if err := c.WriteFileSD(file); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var groups []*TargetGroup
+	if err := json.Unmarshal(buf, &groups); err != nil {
+		t.Fatal(err)
+	}
+	want := []*TargetGroup{
+		{Targets: []string{"lab-node"}, Labels: map[string]string{"module": "default", "__param_module": "default"}},
+		{Targets: []string{"node1"}, Labels: map[string]string{"module": "named", "__param_module": "named", "rack": "r2", "site": "ham1"}},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("unexpected target groups %s", buf)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/lovoo/ipmi_exporter/dashboard"
+)
+
+// runDashboard implements the dashboard subcommand, which writes a Grafana
+// dashboard for the metrics exposed with the given flags, and returns the
+// exit code.
+func runDashboard(args []string) int {
+	fs := flag.NewFlagSet("dashboard", flag.ContinueOnError)
+	title := fs.String("title", "IPMI Overview", "Title of the dashboard")
+	flags := newMetricFlags(fs, "Temperature in degrees Celsius shown as threshold")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	opts := dashboard.Options{
+		Title:          *title,
+		Energy:         *flags.energy > 0,
+		MaxTemperature: *flags.maxTemp,
+	}
+	var err error
+	if opts.Collectors, err = flags.enabledCollectors(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	profiles, err := flags.selectedProfiles()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, p := range profiles {
+		if len(p.PSUs) > 0 {
+			opts.PSUs = true
+		}
+	}
+	if err := flags.write(dashboard.Generate(opts).WriteJSON); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,437 @@
+// Package dashboard generates Grafana dashboards for the metrics exposed by
+// the exporter.
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/lovoo/ipmi_exporter/collector"
+)
+
+// Options select the panels generated for the metrics an exporter exposes.
+type Options struct {
+	// Title is the title of the dashboard.
+	Title string
+	// Collectors are the enabled optional collectors.
+	Collectors []string
+	// PSUs is set if the vendor profiles read power supplies over PMBus.
+	PSUs bool
+	// Energy is set if the energy counters are enabled.
+	Energy bool
+	// MaxTemperature is the temperature in degrees Celsius shown as
+	// threshold of the temperatures.
+	MaxTemperature float64
+}
+
+// Dashboard is a Grafana dashboard in the format of Grafana 4, which newer
+// versions migrate on import.
+type Dashboard struct {
+	Inputs        []*Input   `json:"__inputs"`
+	Requires      []*Require `json:"__requires"`
+	Annotations   List       `json:"annotations"`
+	Editable      bool       `json:"editable"`
+	GraphTooltip  int        `json:"graphTooltip"`
+	HideControls  bool       `json:"hideControls"`
+	ID            *int       `json:"id"`
+	Links         []string   `json:"links"`
+	Rows          []*Row     `json:"rows"`
+	SchemaVersion int        `json:"schemaVersion"`
+	Style         string     `json:"style"`
+	Tags          []string   `json:"tags"`
+	Templating    List       `json:"templating"`
+	Time          Time       `json:"time"`
+	Timepicker    Timepicker `json:"timepicker"`
+	Timezone      string     `json:"timezone"`
+	Title         string     `json:"title"`
+	Version       int        `json:"version"`
+}
+
+// Input is a data source selected when importing the dashboard.
+type Input struct {
+	Name       string `json:"name"`
+	Label      string `json:"label"`
+	Type       string `json:"type"`
+	PluginID   string `json:"pluginId"`
+	PluginName string `json:"pluginName"`
+}
+
+// Require is a plugin required by the dashboard.
+type Require struct {
+	Type    string `json:"type"`
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// List is a list of annotations or template variables.
+type List struct {
+	List []*Variable `json:"list"`
+}
+
+// Time is the default time range.
+type Time struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Timepicker configures the refresh intervals.
+type Timepicker struct {
+	RefreshIntervals []string `json:"refresh_intervals"`
+}
+
+// Variable is a template variable filled by a Prometheus query.
+type Variable struct {
+	AllValue   string `json:"allValue"`
+	Datasource string `json:"datasource"`
+	Hide       int    `json:"hide"`
+	IncludeAll bool   `json:"includeAll"`
+	Label      string `json:"label"`
+	Multi      bool   `json:"multi"`
+	Name       string `json:"name"`
+	Query      string `json:"query"`
+	Refresh    int    `json:"refresh"`
+	Sort       int    `json:"sort"`
+	Type       string `json:"type"`
+}
+
+// Row is a row of panels.
+type Row struct {
+	Collapse  bool     `json:"collapse"`
+	Height    string   `json:"height"`
+	Panels    []*Panel `json:"panels"`
+	ShowTitle bool     `json:"showTitle"`
+	Title     string   `json:"title"`
+	TitleSize string   `json:"titleSize"`
+}
+
+// Panel is a graph or table panel.
+type Panel struct {
+	ID          int          `json:"id"`
+	Type        string       `json:"type"`
+	Title       string       `json:"title"`
+	Description string       `json:"description,omitempty"`
+	Datasource  string       `json:"datasource"`
+	Span        int          `json:"span"`
+	Targets     []*Target    `json:"targets"`
+	Fill        int          `json:"fill,omitempty"`
+	Linewidth   int          `json:"linewidth,omitempty"`
+	Lines       bool         `json:"lines,omitempty"`
+	Legend      *Legend      `json:"legend,omitempty"`
+	Thresholds  []*Threshold `json:"thresholds,omitempty"`
+	Yaxes       []*Axis      `json:"yaxes,omitempty"`
+	Transform   string       `json:"transform,omitempty"`
+}
+
+// Target is a Prometheus query of a panel.
+type Target struct {
+	Expr           string `json:"expr"`
+	Format         string `json:"format"`
+	Instant        bool   `json:"instant,omitempty"`
+	IntervalFactor int    `json:"intervalFactor"`
+	LegendFormat   string `json:"legendFormat,omitempty"`
+	RefID          string `json:"refId"`
+}
+
+// Legend configures the legend of a graph.
+type Legend struct {
+	Show    bool `json:"show"`
+	Current bool `json:"current"`
+	Max     bool `json:"max"`
+	Values  bool `json:"values"`
+}
+
+// Threshold is a threshold drawn into a graph.
+type Threshold struct {
+	ColorMode string  `json:"colorMode"`
+	Fill      bool    `json:"fill"`
+	Line      bool    `json:"line"`
+	Op        string  `json:"op"`
+	Value     float64 `json:"value"`
+}
+
+// Axis is a y axis of a graph.
+type Axis struct {
+	Format  string `json:"format"`
+	LogBase int    `json:"logBase"`
+	Show    bool   `json:"show"`
+}
+
+// datasource is the data source input of the dashboard.
+const datasource = "${DS_PROMETHEUS}"
+
+// selector selects the series of the targets and modules of the variables.
+const selector = `{instance=~"$target",module=~"$module"}`
+
+// familyTitles are the panel titles of the sensor families.
+var familyTitles = map[string]string{
+	collector.FamilyTemperature: "Temperatures",
+	collector.FamilyVoltage:     "Voltages",
+	collector.FamilyFanSpeed:    "Fan speeds",
+	collector.FamilyCurrent:     "Currents",
+	collector.FamilyPowerSupply: "Power supplies",
+	collector.FamilyIntrusion:   "Chassis intrusion",
+}
+
+// familyOrder is the order of the sensor family panels.
+var familyOrder = []string{
+	collector.FamilyTemperature,
+	collector.FamilyFanSpeed,
+	collector.FamilyVoltage,
+	collector.FamilyCurrent,
+	collector.FamilyPowerSupply,
+	collector.FamilyIntrusion,
+}
+
+// Generate returns a dashboard with panels for the metrics of the collector
+// registry exposed with the options.
+func Generate(o Options) *Dashboard {
+	g := &generator{}
+	metrics := collector.Metrics()
+
+	byFamily := map[string]collector.MetricInfo{}
+	for _, m := range metrics {
+		if m.Family != "" {
+			byFamily[m.Family] = m
+		}
+	}
+	sensors := &Row{Title: "Sensors"}
+	for _, family := range familyOrder {
+		m, ok := byFamily[family]
+		if !ok {
+			continue
+		}
+		p := g.graph(familyTitles[family], m, expr(m.Name), 6)
+		if family == collector.FamilyTemperature && o.MaxTemperature > 0 {
+			p.Thresholds = []*Threshold{{ColorMode: "critical", Fill: true, Line: true, Op: "gt", Value: o.MaxTemperature}}
+		}
+		sensors.Panels = append(sensors.Panels, p)
+	}
+	rows := []*Row{sensors}
+
+	if o.Energy {
+		m := metricInfo(metrics, "ipmi_power_energy_joules_total")
+		p := g.graph("Power", m, fmt.Sprintf("rate(%s%s[5m])", m.Name, selector), 12)
+		p.Yaxes[0].Format = "watt"
+		rows = append(rows, &Row{Title: "Energy", Panels: []*Panel{p}})
+	}
+
+	if o.PSUs {
+		row := &Row{Title: "Power supplies"}
+		for _, m := range metrics {
+			if m.Collector == "" && strings.HasPrefix(m.Name, "ipmi_psu_") {
+				row.Panels = append(row.Panels, g.graph(title(m.Name, "ipmi_psu_"), m, expr(m.Name), 3))
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	enabled := map[string]bool{}
+	for _, c := range o.Collectors {
+		enabled[c] = true
+	}
+	collectors := make([]string, 0, len(enabled))
+	for c := range enabled {
+		collectors = append(collectors, c)
+	}
+	sort.Strings(collectors)
+	for _, c := range collectors {
+		row := &Row{Title: collectorTitle(c)}
+		if c == collector.CollectorSEL {
+			// The entries are a gauge dropping when the SEL is cleared,
+			// which increase() would take for a counter reset.
+			m := metricInfo(metrics, "ipmi_sel_entries")
+			row.Panels = append(row.Panels, g.graph("New entries per hour", m, fmt.Sprintf("delta(%s%s[1h])", m.Name, selector), 4))
+		}
+		for _, m := range metrics {
+			if m.Collector != c {
+				continue
+			}
+			if strings.HasSuffix(m.Name, "_info") {
+				row.Panels = append(row.Panels, g.table(title(m.Name, "ipmi_"+c+"_"), m))
+				continue
+			}
+			p := g.graph(title(m.Name, "ipmi_"+c+"_"), m, expr(m.Name), 4)
+			if m.Name == "ipmi_sel_fullness_ratio" {
+				p.Thresholds = []*Threshold{{ColorMode: "warning", Fill: true, Line: true, Op: "gt", Value: 0.9}}
+			}
+			row.Panels = append(row.Panels, p)
+		}
+		rows = append(rows, row)
+	}
+
+	for _, r := range rows {
+		r.Height = "250px"
+		r.ShowTitle = true
+		r.TitleSize = "h6"
+	}
+	return &Dashboard{
+		Inputs: []*Input{{
+			Name:       "DS_PROMETHEUS",
+			Label:      "Prometheus",
+			Type:       "datasource",
+			PluginID:   "prometheus",
+			PluginName: "Prometheus",
+		}},
+		Requires: []*Require{
+			{Type: "grafana", ID: "grafana", Name: "Grafana", Version: "4.4.1"},
+			{Type: "panel", ID: "graph", Name: "Graph"},
+			{Type: "panel", ID: "table", Name: "Table"},
+			{Type: "datasource", ID: "prometheus", Name: "Prometheus", Version: "1.0.0"},
+		},
+		Annotations:   List{List: []*Variable{}},
+		Editable:      true,
+		Links:         []string{},
+		Rows:          rows,
+		SchemaVersion: 14,
+		Style:         "dark",
+		Tags:          []string{"ipmi"},
+		Templating: List{List: []*Variable{
+			variable("module", "Module", "label_values(ipmi_temperatures, module)"),
+			variable("target", "Target", `label_values(ipmi_temperatures{module=~"$module"}, instance)`),
+		}},
+		Time:       Time{From: "now-6h", To: "now"},
+		Timepicker: Timepicker{RefreshIntervals: []string{"30s", "1m", "5m", "15m", "30m", "1h"}},
+		Timezone:   "browser",
+		Title:      o.Title,
+		Version:    1,
+	}
+}
+
+func variable(name, label, query string) *Variable {
+	return &Variable{
+		AllValue:   ".*",
+		Datasource: datasource,
+		IncludeAll: true,
+		Label:      label,
+		Multi:      true,
+		Name:       name,
+		Query:      query,
+		Refresh:    2,
+		Sort:       1,
+		Type:       "query",
+	}
+}
+
+// generator numbers the panels of a dashboard.
+type generator struct {
+	id int
+}
+
+func (g *generator) panel(typ, title string, m collector.MetricInfo, span int, target *Target) *Panel {
+	g.id++
+	return &Panel{
+		ID:          g.id,
+		Type:        typ,
+		Title:       title,
+		Description: m.Help,
+		Datasource:  datasource,
+		Span:        span,
+		Targets:     []*Target{target},
+	}
+}
+
+func (g *generator) graph(title string, m collector.MetricInfo, query string, span int) *Panel {
+	legend := "{{instance}}"
+	for _, l := range m.Labels {
+		legend += " {{" + l + "}}"
+	}
+	p := g.panel("graph", title, m, span, &Target{
+		Expr:           query,
+		Format:         "time_series",
+		IntervalFactor: 2,
+		LegendFormat:   legend,
+		RefID:          "A",
+	})
+	p.Fill = 1
+	p.Linewidth = 1
+	p.Lines = true
+	p.Legend = &Legend{Show: true, Current: true, Max: true, Values: true}
+	p.Yaxes = []*Axis{
+		{Format: unit(m.Name), LogBase: 1, Show: true},
+		{Format: "short", LogBase: 1, Show: false},
+	}
+	return p
+}
+
+func (g *generator) table(title string, m collector.MetricInfo) *Panel {
+	p := g.panel("table", title, m, 12, &Target{
+		Expr:           expr(m.Name),
+		Format:         "table",
+		Instant:        true,
+		IntervalFactor: 1,
+		RefID:          "A",
+	})
+	p.Transform = "table"
+	return p
+}
+
+func expr(name string) string {
+	return name + selector
+}
+
+func metricInfo(metrics []collector.MetricInfo, name string) collector.MetricInfo {
+	for _, m := range metrics {
+		if m.Name == name {
+			return m
+		}
+	}
+	return collector.MetricInfo{Name: name}
+}
+
+// unitSuffixes are the metric name suffixes shown as unit of the y axis
+// instead of in the panel title.
+var unitSuffixes = []string{"_celsius", "_volts", "_amps", "_rpm", "_watts", "_seconds", "_ratio"}
+
+// title returns a panel title for a metric, e.g. "Input voltage" for
+// ipmi_psu_input_voltage_volts without the prefix ipmi_psu_.
+func title(name, prefix string) string {
+	t := strings.TrimPrefix(strings.TrimPrefix(name, prefix), "ipmi_")
+	for _, s := range unitSuffixes {
+		t = strings.TrimSuffix(t, s)
+	}
+	t = strings.Replace(t, "_", " ", -1)
+	return strings.ToUpper(t[:1]) + t[1:]
+}
+
+func collectorTitle(name string) string {
+	switch name {
+	case collector.CollectorSEL, collector.CollectorLAN:
+		return strings.ToUpper(name)
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// unit returns the Grafana unit of a metric by its name.
+func unit(name string) string {
+	switch {
+	case name == "ipmi_temperatures" || strings.HasSuffix(name, "_celsius"):
+		return "celsius"
+	case name == "ipmi_voltages" || strings.HasSuffix(name, "_volts"):
+		return "volt"
+	case name == "ipmi_current" || strings.HasSuffix(name, "_amps"):
+		return "amp"
+	case name == "ipmi_fan_speed" || strings.HasSuffix(name, "_rpm"):
+		return "rpm"
+	case strings.HasSuffix(name, "_watts"):
+		return "watt"
+	case strings.HasSuffix(name, "_timestamp_seconds"):
+		return "dateTimeAsIso"
+	case strings.HasSuffix(name, "_seconds"):
+		return "s"
+	case strings.HasSuffix(name, "_ratio"):
+		return "percentunit"
+	}
+	return "short"
+}
+
+// WriteJSON writes the dashboard in the JSON format imported by Grafana.
+func (d *Dashboard) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
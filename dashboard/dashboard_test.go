@@ -0,0 +1,95 @@
+package dashboard
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/lovoo/ipmi_exporter/collector"
+)
+
+var update = flag.Bool("update", false, "update the golden dashboard")
+
+// TestGolden compares the dashboard of all collectors to the dashboard
+// shipped with the exporter. Run the tests with -update to rewrite it.
+func TestGolden(t *testing.T) {
+	var buf bytes.Buffer
+	err := Generate(Options{
+		Title:          "IPMI Overview",
+		Collectors:     collector.CollectorNames(),
+		PSUs:           true,
+		Energy:         true,
+		MaxTemperature: 80,
+	}).WriteJSON(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("..", "dashboards", "grafana", "ipmi_overview.json")
+	if *update {
+		if err := ioutil.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("dashboard differs from %s, run the tests with -update", golden)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	d := Generate(Options{Collectors: []string{collector.CollectorSEL, collector.CollectorSEL}, MaxTemperature: 70})
+
+	var titles []string
+	ids := map[int]bool{}
+	for _, r := range d.Rows {
+		titles = append(titles, r.Title)
+		for _, p := range r.Panels {
+			if ids[p.ID] {
+				t.Errorf("duplicate panel id %d", p.ID)
+			}
+			ids[p.ID] = true
+		}
+	}
+	if want := []string{"Sensors", "SEL"}; !equal(titles, want) {
+		t.Errorf("want rows %v, got %v", want, titles)
+	}
+
+	temps := d.Rows[0].Panels[0]
+	if temps.Targets[0].Expr != `ipmi_temperatures{instance=~"$target",module=~"$module"}` {
+		t.Errorf("unexpected temperature query %s", temps.Targets[0].Expr)
+	}
+	if len(temps.Thresholds) != 1 || temps.Thresholds[0].Value != 70 {
+		t.Errorf("want temperature threshold 70, got %+v", temps.Thresholds)
+	}
+	if temps.Yaxes[0].Format != "celsius" {
+		t.Errorf("want unit celsius, got %s", temps.Yaxes[0].Format)
+	}
+
+	var buf bytes.Buffer
+	if err := d.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var v map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,241 @@
+// Package discovery finds BMCs by scanning subnets with RMCP presence pings.
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lovoo/ipmi_exporter/config"
+	"github.com/lovoo/ipmi_exporter/ipmi"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// BMC is a BMC found by a scan.
+type BMC struct {
+	Address string
+	// Channel is the channel the BMC answered Get Channel Authentication
+	// Capabilities on.
+	Channel byte
+	IPMIv20 bool
+	// Device is the answer to Get Device ID, or nil if the exporter has no
+	// credentials for the BMC or logging in failed.
+	Device *ipmi.DeviceID
+}
+
+// Target returns the target probing the BMC with the discovery module and
+// labels.
+func (b *BMC) Target(d *config.Discovery) *config.Target {
+	labels := map[string]string{}
+	for k, v := range d.Labels {
+		labels[k] = v
+	}
+	if b.Device != nil {
+		labels["manufacturer_id"] = fmt.Sprint(b.Device.ManufacturerID)
+		labels["product_id"] = fmt.Sprint(b.Device.ProductID)
+		labels["firmware"] = b.Device.FirmwareRevision
+	}
+	return &config.Target{Address: b.Address, Module: d.Module, Labels: labels}
+}
+
+// Scanner periodically scans the discovery subnets of a configuration and
+// adds the BMCs found to its targets.
+type Scanner struct {
+	// OnScan is called after each scan of Run, e.g. to update a service
+	// discovery file.
+	OnScan func()
+
+	config *config.Config
+
+	mu       sync.Mutex
+	bmcs     map[string]*BMC
+	lastScan time.Time
+	duration time.Duration
+
+	bmcsDesc     *prometheus.Desc
+	lastScanDesc *prometheus.Desc
+	durationDesc *prometheus.Desc
+}
+
+// NewScanner returns a scanner for the discovery configuration of c.
+func NewScanner(c *config.Config) *Scanner {
+	return &Scanner{
+		config: c,
+		bmcsDesc: prometheus.NewDesc(
+			"ipmi_exporter_discovery_bmcs",
+			"Number of BMCs found by the last discovery scan.",
+			nil, nil,
+		),
+		lastScanDesc: prometheus.NewDesc(
+			"ipmi_exporter_discovery_last_scan_timestamp_seconds",
+			"Time the last discovery scan finished.",
+			nil, nil,
+		),
+		durationDesc: prometheus.NewDesc(
+			"ipmi_exporter_discovery_scan_duration_seconds",
+			"Duration of the last discovery scan.",
+			nil, nil,
+		),
+	}
+}
+
+// Run scans the subnets every interval until stop is closed.
+func (s *Scanner) Run(stop <-chan struct{}) {
+	d := s.config.Discovery
+	for {
+		s.Scan()
+		if s.OnScan != nil {
+			s.OnScan()
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(time.Duration(d.Interval)):
+		}
+	}
+}
+
+// Scan scans the subnets once and replaces the discovered targets of the
+// configuration with the BMCs found.
+func (s *Scanner) Scan() map[string]*BMC {
+	d := s.config.Discovery
+	start := time.Now()
+
+	addrs := make(chan string)
+	found := make(chan *BMC)
+	var wg sync.WaitGroup
+	for i := 0; i < d.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for addr := range addrs {
+				if b := s.probe(addr); b != nil {
+					found <- b
+				}
+			}
+		}()
+	}
+	go func() {
+		for _, subnet := range d.Subnets {
+			for _, ip := range hosts(subnet) {
+				addrs <- net.JoinHostPort(ip.String(), d.Port)
+			}
+		}
+		close(addrs)
+		wg.Wait()
+		close(found)
+	}()
+
+	bmcs := map[string]*BMC{}
+	targets := map[string]*config.Target{}
+	for b := range found {
+		name := config.Host(b.Address)
+		if d.Port != "623" {
+			name = b.Address
+		}
+		bmcs[name] = b
+		targets[name] = b.Target(d)
+	}
+	s.config.SetDiscovered(targets)
+	log.Infof("Discovered %d BMCs in %v", len(bmcs), time.Since(start))
+
+	s.mu.Lock()
+	s.bmcs = bmcs
+	s.lastScan = time.Now()
+	s.duration = s.lastScan.Sub(start)
+	s.mu.Unlock()
+	return bmcs
+}
+
+// probe checks whether a BMC answers at addr and identifies it.
+func (s *Scanner) probe(addr string) *BMC {
+	timeout := time.Duration(s.config.Discovery.Timeout)
+	pong, err := ipmi.Ping(addr, timeout)
+	if err != nil || !pong.SupportsIPMI() {
+		return nil
+	}
+	b := &BMC{Address: addr}
+	caps, err := ipmi.GetChannelAuthCapabilities(addr, timeout)
+	if err != nil {
+		log.With("target", addr).Debugf("Error getting authentication capabilities: %v", err)
+		return b
+	}
+	b.Channel, b.IPMIv20 = caps.Channel, caps.IPMIv20
+
+	module, _ := s.config.Module(s.config.Discovery.Module)
+	creds := s.config.CredentialsFor(module, addr)
+	if creds.User == "" || !b.IPMIv20 {
+		return b
+	}
+	password, err := creds.Secret()
+	if err != nil {
+		log.With("target", addr).Errorf("Error reading password: %v", err)
+		return b
+	}
+	session, err := ipmi.Dial(addr, ipmi.Config{
+		Username:  creds.User,
+		Password:  password,
+		Privilege: ipmi.PrivilegeUser,
+		Timeout:   timeout,
+	})
+	if err != nil {
+		log.With("target", addr).Debugf("Error opening session: %v", err)
+		return b
+	}
+	defer session.Close()
+	if b.Device, err = session.GetDeviceID(); err != nil {
+		log.With("target", addr).Debugf("Error getting device ID: %v", err)
+	}
+	return b
+}
+
+// hosts returns the host addresses of a subnet, without the network and
+// broadcast addresses of IPv4 subnets larger than /31.
+func hosts(subnet string) []net.IP {
+	_, n, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil
+	}
+	var ips []net.IP
+	for ip := n.IP; n.Contains(ip); ip = next(ip) {
+		ips = append(ips, ip)
+	}
+	if ones, bits := n.Mask.Size(); bits == 8*net.IPv4len && bits-ones > 1 {
+		ips = ips[1 : len(ips)-1]
+	}
+	return ips
+}
+
+func next(ip net.IP) net.IP {
+	n := make(net.IP, len(ip))
+	copy(n, ip)
+	for i := len(n) - 1; i >= 0; i-- {
+		n[i]++
+		if n[i] != 0 {
+			break
+		}
+	}
+	return n
+}
+
+// Describe implements prometheus.Collector.
+func (s *Scanner) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.bmcsDesc
+	ch <- s.lastScanDesc
+	ch <- s.durationDesc
+}
+
+// Collect implements prometheus.Collector.
+func (s *Scanner) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastScan.IsZero() {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(s.bmcsDesc, prometheus.GaugeValue, float64(len(s.bmcs)))
+	ch <- prometheus.MustNewConstMetric(s.lastScanDesc, prometheus.GaugeValue, float64(s.lastScan.UnixNano())/1e9)
+	ch <- prometheus.MustNewConstMetric(s.durationDesc, prometheus.GaugeValue, s.duration.Seconds())
+}
@@ -0,0 +1,94 @@
+package discovery
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/lovoo/ipmi_exporter/config"
+	"github.com/lovoo/ipmi_exporter/simulator"
+)
+
+func TestHosts(t *testing.T) {
+	for subnet, want := range map[string][]string{
+		"10.0.0.0/30":   {"10.0.0.1", "10.0.0.2"},
+		"10.0.0.4/31":   {"10.0.0.4", "10.0.0.5"},
+		"10.0.0.7/32":   {"10.0.0.7"},
+		"10.0.0.255/30": {"10.0.0.253", "10.0.0.254"},
+		"fd00::/127":    {"fd00::", "fd00::1"},
+	} {
+		var got []string
+		for _, ip := range hosts(subnet) {
+			got = append(got, ip.String())
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: want %v, got %v", subnet, want, got)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	scenario, err := simulator.LoadScenario("../simulator/testdata/supermicro.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim, err := simulator.New(scenario)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go sim.Serve(conn)
+	_, port, _ := net.SplitHostPort(conn.LocalAddr().String())
+
+	os.Setenv("IPMI_TEST_PASSWORD", "secret")
+	defer os.Unsetenv("IPMI_TEST_PASSWORD")
+	f, err := ioutil.TempFile("", "ipmi_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprintf(f, `{
+		"modules": {"default": {"user": "monitor", "password_env": "IPMI_TEST_PASSWORD", "named_targets_only": true}},
+		"discovery": {"subnets": ["127.0.0.0/30"], "port": %q, "timeout": "200ms", "labels": {"site": "lab"}}
+	}`, port)
+	f.Close()
+	c, err := config.Load(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewScanner(c)
+	bmcs := s.Scan()
+	addr := "127.0.0.1:" + port
+	b, ok := bmcs[addr]
+	if len(bmcs) != 1 || !ok {
+		t.Fatalf("want BMC at %s, got %v", addr, bmcs)
+	}
+	if b.Channel != 1 || !b.IPMIv20 || b.Device == nil || b.Device.ManufacturerID != 10876 {
+		t.Errorf("unexpected BMC %+v", b)
+	}
+
+	m, _ := c.Module("")
+	if address, err := c.Resolve(m, addr); err != nil || address != addr {
+		t.Errorf("want discovered target to be allowed, got %q, %v", address, err)
+	}
+	groups := c.ServiceDiscovery()
+	want := map[string]string{
+		"module":          "default",
+		"__param_module":  "default",
+		"site":            "lab",
+		"manufacturer_id": "10876",
+		"product_id":      "2137",
+		"firmware":        "3.88",
+	}
+	if len(groups) != 1 || !reflect.DeepEqual(groups[0].Labels, want) {
+		t.Errorf("unexpected target groups %+v", groups)
+	}
+}
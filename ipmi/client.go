@@ -0,0 +1,421 @@
+package ipmi
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// DefaultPort is the UDP port of RMCP.
+const DefaultPort = "623"
+
+// ErrTimeout is returned when the BMC does not answer a request in time.
+var ErrTimeout = errors.New("ipmi: timeout waiting for response")
+
+// Config configures an RMCP+ session.
+type Config struct {
+	Username string
+	Password string
+	// Privilege is the privilege level requested for the session. It
+	// defaults to PrivilegeAdministrator.
+	Privilege byte
+	// CipherSuite is the ID of the cipher suite of the session. It defaults
+	// to cipher suite 3; cipher suite 0 can not be used.
+	CipherSuite byte
+	// Timeout is the time to wait for each response. It defaults to two
+	// seconds.
+	Timeout time.Duration
+	// Retries is the number of times a request is repeated after a timeout.
+	Retries int
+}
+
+func (c *Config) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return 2 * time.Second
+	}
+	return c.Timeout
+}
+
+// Session is an established RMCP+ session with a BMC.
+type Session struct {
+	conn  net.Conn
+	cfg   Config
+	keys  *SessionKeys
+	bmcID uint32
+	seq   uint32
+	rqSeq byte
+}
+
+// Dial establishes an RMCP+ session with the BMC at addr. The port defaults
+// to DefaultPort.
+func Dial(addr string, cfg Config) (*Session, error) {
+	if cfg.Privilege == 0 {
+		cfg.Privilege = PrivilegeAdministrator
+	}
+	if cfg.CipherSuite == 0 {
+		cfg.CipherSuite = 3
+	}
+	suite, ok := LookupCipherSuite(cfg.CipherSuite)
+	if !ok {
+		return nil, fmt.Errorf("ipmi: unsupported cipher suite %d", cfg.CipherSuite)
+	}
+
+	conn, err := net.Dial("udp", withPort(addr))
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{conn: conn, cfg: cfg}
+	if err := s.open(suite); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Session) open(suite CipherSuite) error {
+	h := &Handshake{
+		Suite:    suite,
+		Role:     s.cfg.Privilege | 0x10,
+		Username: s.cfg.Username,
+		Password: s.cfg.Password,
+	}
+	var id [4]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return err
+	}
+	h.ConsoleSessionID = binary.LittleEndian.Uint32(id[:]) | 1
+	if _, err := rand.Read(h.ConsoleRandom[:]); err != nil {
+		return err
+	}
+
+	req := &OpenSessionRequest{Tag: 1, MaxPrivilege: s.cfg.Privilege, ConsoleSessionID: h.ConsoleSessionID, Suite: suite}
+	b, err := s.exchangePayload(PayloadOpenSessionRequest, req.Marshal(), PayloadOpenSessionResponse)
+	if err != nil {
+		return err
+	}
+	resp, err := UnmarshalOpenSessionResponse(b)
+	if err != nil {
+		return err
+	}
+	if resp.Status != StatusOK {
+		return &StatusError{Stage: "open session", Status: resp.Status}
+	}
+	if resp.ConsoleSessionID != h.ConsoleSessionID {
+		return errors.New("ipmi: open session response for another session")
+	}
+	h.BMCSessionID = resp.BMCSessionID
+
+	rakp1 := &RAKP1{Tag: 2, BMCSessionID: h.BMCSessionID, ConsoleRandom: h.ConsoleRandom, Role: h.Role, Username: h.Username}
+	if b, err = s.exchangePayload(PayloadRAKP1, rakp1.Marshal(), PayloadRAKP2); err != nil {
+		return err
+	}
+	rakp2, err := UnmarshalRAKP2(b)
+	if err != nil {
+		return err
+	}
+	if rakp2.Status != StatusOK {
+		return &StatusError{Stage: "RAKP 2", Status: rakp2.Status}
+	}
+	h.BMCRandom = rakp2.BMCRandom
+	h.BMCGUID = rakp2.BMCGUID
+	if !hmac.Equal(rakp2.AuthCode, h.RAKP2AuthCode()) {
+		return ErrAuthFailed
+	}
+
+	rakp3 := &RAKP3{Tag: 3, BMCSessionID: h.BMCSessionID, AuthCode: h.RAKP3AuthCode()}
+	if b, err = s.exchangePayload(PayloadRAKP3, rakp3.Marshal(), PayloadRAKP4); err != nil {
+		return err
+	}
+	rakp4, err := UnmarshalRAKP4(b)
+	if err != nil {
+		return err
+	}
+	if rakp4.Status != StatusOK {
+		return &StatusError{Stage: "RAKP 4", Status: rakp4.Status}
+	}
+	if !hmac.Equal(rakp4.ICV, h.RAKP4ICV()) {
+		return errors.New("ipmi: invalid RAKP 4 integrity check value")
+	}
+
+	s.keys = h.Keys()
+	s.bmcID = h.BMCSessionID
+	_, err = s.Raw(NetFnApp, CmdSetSessionPrivilegeLevel, []byte{s.cfg.Privilege})
+	return err
+}
+
+// exchangePayload sends an unauthenticated session setup payload and
+// returns the payload of the answer of the expected type.
+func (s *Session) exchangePayload(typ byte, payload []byte, want byte) ([]byte, error) {
+	p := &SessionPacket{AuthType: AuthTypeRMCPPlus, PayloadType: typ, Payload: payload}
+	b, err := p.Marshal(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp *SessionPacket
+	err = exchange(s.conn, b, &s.cfg, func(b []byte) (bool, error) {
+		class, body, err := UnmarshalRMCP(b)
+		if err != nil || class != ClassIPMI {
+			return false, err
+		}
+		if resp, err = UnmarshalSession(body, noKeys); err != nil {
+			return false, err
+		}
+		return resp.PayloadType == want, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Payload, nil
+}
+
+// Raw sends a request to the BMC and returns the response data following
+// the completion code. A CompletionError is returned if the completion code
+// signals a failure.
+func (s *Session) Raw(netFn, cmd byte, data []byte) ([]byte, error) {
+	s.seq++
+	s.rqSeq = (s.rqSeq + 1) & 0x3f
+	req := &Message{RsAddr: BMCAddress, NetFn: netFn, RqAddr: ConsoleAddress, Seq: s.rqSeq, Cmd: cmd, Data: data}
+	p := &SessionPacket{
+		AuthType:      AuthTypeRMCPPlus,
+		PayloadType:   PayloadIPMI,
+		Encrypted:     s.keys.Encrypted(),
+		Authenticated: s.keys.Authenticated(),
+		SessionID:     s.bmcID,
+		Sequence:      s.seq,
+		Payload:       req.Marshal(),
+	}
+	b, err := p.Marshal(s.keys)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp *Message
+	keys := func(uint32) *SessionKeys { return s.keys }
+	err = exchange(s.conn, b, &s.cfg, func(b []byte) (bool, error) {
+		class, body, err := UnmarshalRMCP(b)
+		if err != nil || class != ClassIPMI {
+			return false, err
+		}
+		p, err := UnmarshalSession(body, keys)
+		if err != nil {
+			return false, err
+		}
+		if p.PayloadType != PayloadIPMI {
+			return false, nil
+		}
+		if resp, err = UnmarshalMessage(p.Payload); err != nil {
+			return false, err
+		}
+		return resp.Seq == req.Seq && resp.Cmd == cmd, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return completionData(resp, netFn, cmd)
+}
+
+// Close closes the session and the underlying connection.
+func (s *Session) Close() error {
+	id := make([]byte, 4)
+	binary.LittleEndian.PutUint32(id, s.bmcID)
+	_, err := s.Raw(NetFnApp, CmdCloseSession, id)
+	if cerr := s.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// DeviceID is the answer to Get Device ID.
+type DeviceID struct {
+	DeviceID          byte
+	DeviceRevision    byte
+	FirmwareRevision  string
+	IPMIVersion       string
+	ManufacturerID    uint32
+	ProductID         uint16
+	AdditionalSupport byte
+}
+
+// GetDeviceID returns the device ID of the BMC.
+func (s *Session) GetDeviceID() (*DeviceID, error) {
+	b, err := s.Raw(NetFnApp, CmdGetDeviceID, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 11 {
+		return nil, errors.New("ipmi: device ID too short")
+	}
+	return &DeviceID{
+		DeviceID:          b[0],
+		DeviceRevision:    b[1] & 0x0f,
+		FirmwareRevision:  fmt.Sprintf("%d.%02x", b[2]&0x7f, b[3]),
+		IPMIVersion:       fmt.Sprintf("%d.%d", b[4]&0x0f, b[4]>>4),
+		AdditionalSupport: b[5],
+		ManufacturerID:    uint32(b[6]) | uint32(b[7])<<8 | uint32(b[8]&0x0f)<<16,
+		ProductID:         binary.LittleEndian.Uint16(b[9:]),
+	}, nil
+}
+
+// Ping sends an RMCP presence ping to addr and returns the pong.
+func Ping(addr string, timeout time.Duration) (*Pong, error) {
+	conn, err := net.Dial("udp", withPort(addr))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	ping := &ASFMessage{Type: ASFPing, Tag: 0x01}
+	var pong *Pong
+	err = exchange(conn, ping.Marshal(), &Config{Timeout: timeout}, func(b []byte) (bool, error) {
+		class, body, err := UnmarshalRMCP(b)
+		if err != nil || class != ClassASF {
+			return false, err
+		}
+		m, err := UnmarshalASF(body)
+		if err != nil {
+			return false, err
+		}
+		if m.Type != ASFPong || m.Tag != ping.Tag {
+			return false, nil
+		}
+		pong, err = unmarshalPong(m.Data)
+		return err == nil, err
+	})
+	return pong, err
+}
+
+// AuthCapabilities is the answer to Get Channel Authentication
+// Capabilities.
+type AuthCapabilities struct {
+	Channel byte
+	// AuthTypes is the bit mask of the supported IPMI v1.5 authentication
+	// types.
+	AuthTypes        byte
+	IPMIv20          bool
+	KGSet            bool
+	PerMessageAuth   bool
+	UserLevelAuth    bool
+	NonNullUsernames bool
+	NullUsernames    bool
+	AnonymousLogin   bool
+	OEMID            uint32
+}
+
+// GetChannelAuthCapabilities sends the session-less Get Channel
+// Authentication Capabilities request for the channel the request is
+// received on.
+func GetChannelAuthCapabilities(addr string, timeout time.Duration) (*AuthCapabilities, error) {
+	conn, err := net.Dial("udp", withPort(addr))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req := &Message{
+		RsAddr: BMCAddress,
+		NetFn:  NetFnApp,
+		RqAddr: ConsoleAddress,
+		Cmd:    CmdGetChannelAuthCapabilities,
+		Data:   []byte{0x8e, PrivilegeAdministrator},
+	}
+	p := &SessionPacket{AuthType: AuthTypeNone, Payload: req.Marshal()}
+	b, err := p.Marshal(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp *Message
+	err = exchange(conn, b, &Config{Timeout: timeout}, func(b []byte) (bool, error) {
+		class, body, err := UnmarshalRMCP(b)
+		if err != nil || class != ClassIPMI {
+			return false, err
+		}
+		p, err := UnmarshalSession(body, noKeys)
+		if err != nil {
+			return false, err
+		}
+		if resp, err = UnmarshalMessage(p.Payload); err != nil {
+			return false, err
+		}
+		return resp.Cmd == req.Cmd, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	data, err := completionData(resp, req.NetFn, req.Cmd)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 {
+		return nil, errors.New("ipmi: authentication capabilities too short")
+	}
+	return &AuthCapabilities{
+		Channel:          data[0],
+		AuthTypes:        data[1] & 0x3f,
+		IPMIv20:          data[1]&0x80 != 0 && data[3]&0x02 != 0,
+		KGSet:            data[2]&0x20 != 0,
+		PerMessageAuth:   data[2]&0x10 == 0,
+		UserLevelAuth:    data[2]&0x08 == 0,
+		NonNullUsernames: data[2]&0x04 != 0,
+		NullUsernames:    data[2]&0x02 != 0,
+		AnonymousLogin:   data[2]&0x01 != 0,
+		OEMID:            uint32(data[4]) | uint32(data[5])<<8 | uint32(data[6])<<16,
+	}, nil
+}
+
+// exchange sends b on conn and reads packets until match accepts one. The
+// request is repeated on timeouts as configured.
+func exchange(conn net.Conn, b []byte, cfg *Config, match func([]byte) (bool, error)) error {
+	buf := make([]byte, 1024)
+	for attempt := 0; attempt <= cfg.Retries; attempt++ {
+		if _, err := conn.Write(b); err != nil {
+			return err
+		}
+		if err := conn.SetReadDeadline(time.Now().Add(cfg.timeout())); err != nil {
+			return err
+		}
+		for {
+			n, err := conn.Read(buf)
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			ok, err := match(buf[:n])
+			if err != nil {
+				return fmt.Errorf("ipmi: malformed response: %v", err)
+			}
+			if ok {
+				return nil
+			}
+		}
+	}
+	return ErrTimeout
+}
+
+func completionData(resp *Message, netFn, cmd byte) ([]byte, error) {
+	if len(resp.Data) < 1 {
+		return nil, errors.New("ipmi: response without completion code")
+	}
+	if resp.Data[0] != CompletionOK {
+		return nil, &CompletionError{NetFn: netFn, Cmd: cmd, Code: resp.Data[0]}
+	}
+	return resp.Data[1:], nil
+}
+
+func noKeys(uint32) *SessionKeys {
+	return nil
+}
+
+func withPort(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(addr, DefaultPort)
+}
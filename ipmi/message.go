@@ -0,0 +1,156 @@
+// Package ipmi implements the parts of the IPMI over LAN protocol used by the
+// exporter: RMCP presence pings, session-less requests and RMCP+ (IPMI v2.0)
+// sessions.
+package ipmi
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Network functions.
+const (
+	NetFnChassis   = 0x00
+	NetFnSensor    = 0x04
+	NetFnApp       = 0x06
+	NetFnStorage   = 0x0a
+	NetFnTransport = 0x0c
+)
+
+// Commands of the Chassis network function.
+const (
+	CmdGetSystemBootOptions = 0x09
+)
+
+// Commands of the Sensor/Event network function.
+const (
+	CmdGetSensorThresholds = 0x27
+	CmdGetSensorReading    = 0x2d
+)
+
+// Commands of the App network function.
+const (
+	CmdGetDeviceID                = 0x01
+	CmdGetWatchdogTimer           = 0x25
+	CmdGetChannelAuthCapabilities = 0x38
+	CmdSetSessionPrivilegeLevel   = 0x3b
+	CmdCloseSession               = 0x3c
+	CmdGetUserAccess              = 0x44
+	CmdGetUserName                = 0x46
+	CmdMasterWriteRead            = 0x52
+	CmdGetChannelCipherSuites     = 0x54
+)
+
+// Commands of the Storage network function.
+const (
+	CmdGetSDRRepositoryInfo = 0x20
+	CmdReserveSDRRepository = 0x22
+	CmdGetSDR               = 0x23
+)
+
+// Commands of the Transport network function.
+const (
+	CmdGetLANConfigurationParameters = 0x02
+)
+
+// Privilege levels.
+const (
+	PrivilegeCallback      = 0x01
+	PrivilegeUser          = 0x02
+	PrivilegeOperator      = 0x03
+	PrivilegeAdministrator = 0x04
+	PrivilegeOEM           = 0x05
+)
+
+// Completion codes.
+const (
+	CompletionOK                  = 0x00
+	CompletionInvalidCommand      = 0xc1
+	CompletionTimeout             = 0xc3
+	CompletionInvalidReservation  = 0xc5
+	CompletionParameterOutOfRange = 0xc9
+	CompletionNotPresent          = 0xcb
+	CompletionInsufficientPriv    = 0xd4
+	CompletionUnspecified         = 0xff
+)
+
+// Slave addresses of the BMC and the remote console.
+const (
+	BMCAddress     = 0x20
+	ConsoleAddress = 0x81
+)
+
+// Message is an IPMI message as transported in a LAN session. The data of a
+// response starts with the completion code.
+type Message struct {
+	RsAddr byte
+	NetFn  byte
+	RqAddr byte
+	Seq    byte
+	Cmd    byte
+	Data   []byte
+}
+
+// IsResponse reports whether m is a response message.
+func (m *Message) IsResponse() bool {
+	return m.NetFn&1 == 1
+}
+
+// Marshal encodes m in the LAN message format including both checksums.
+func (m *Message) Marshal() []byte {
+	b := make([]byte, 0, 7+len(m.Data))
+	b = append(b, m.RsAddr, m.NetFn<<2)
+	b = append(b, checksum(b))
+	b = append(b, m.RqAddr, m.Seq<<2, m.Cmd)
+	b = append(b, m.Data...)
+	return append(b, checksum(b[3:]))
+}
+
+// Response returns a response to m carrying the completion code and data.
+func (m *Message) Response(code byte, data []byte) *Message {
+	return &Message{
+		RsAddr: m.RqAddr,
+		NetFn:  m.NetFn | 1,
+		RqAddr: m.RsAddr,
+		Seq:    m.Seq,
+		Cmd:    m.Cmd,
+		Data:   append([]byte{code}, data...),
+	}
+}
+
+// UnmarshalMessage decodes a LAN message and verifies its checksums.
+func UnmarshalMessage(b []byte) (*Message, error) {
+	if len(b) < 7 {
+		return nil, errors.New("ipmi: message too short")
+	}
+	if checksum(b[:2]) != b[2] || checksum(b[3:len(b)-1]) != b[len(b)-1] {
+		return nil, errors.New("ipmi: invalid message checksum")
+	}
+	return &Message{
+		RsAddr: b[0],
+		NetFn:  b[1] >> 2,
+		RqAddr: b[3],
+		Seq:    b[4] >> 2,
+		Cmd:    b[5],
+		Data:   append([]byte(nil), b[6:len(b)-1]...),
+	}, nil
+}
+
+// checksum returns the two's complement checksum of b.
+func checksum(b []byte) byte {
+	var c byte
+	for _, v := range b {
+		c += v
+	}
+	return -c
+}
+
+// CompletionError is returned for responses with a completion code other
+// than CompletionOK.
+type CompletionError struct {
+	NetFn, Cmd, Code byte
+}
+
+func (e *CompletionError) Error() string {
+	return fmt.Sprintf("ipmi: command 0x%02x 0x%02x failed with completion code 0x%02x", e.NetFn, e.Cmd, e.Code)
+}
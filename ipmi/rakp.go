@@ -0,0 +1,487 @@
+package ipmi
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// Authentication algorithms.
+const (
+	AuthNone       = 0x00
+	AuthHMACSHA1   = 0x01
+	AuthHMACSHA256 = 0x03
+)
+
+// Integrity algorithms.
+const (
+	IntegrityNone          = 0x00
+	IntegrityHMACSHA196    = 0x01
+	IntegrityHMACSHA256128 = 0x04
+)
+
+// Confidentiality algorithms.
+const (
+	ConfidentialityNone      = 0x00
+	ConfidentialityAESCBC128 = 0x01
+)
+
+// RAKP status codes.
+const (
+	StatusOK                    = 0x00
+	StatusInsufficientResources = 0x01
+	StatusInvalidSessionID      = 0x02
+	StatusUnauthorizedRole      = 0x0a
+	StatusUnauthorizedName      = 0x0d
+	StatusInvalidIntegrityCheck = 0x0f
+	StatusNoCipherSuiteMatch    = 0x11
+)
+
+var statusText = map[byte]string{
+	StatusOK:                    "no errors",
+	StatusInsufficientResources: "insufficient resources to create a session",
+	StatusInvalidSessionID:      "invalid session ID",
+	StatusUnauthorizedRole:      "unauthorized role or privilege level requested",
+	StatusUnauthorizedName:      "unauthorized name",
+	StatusInvalidIntegrityCheck: "invalid integrity check value",
+	StatusNoCipherSuiteMatch:    "no cipher suite match with proposed security algorithms",
+}
+
+// StatusError is returned when the BMC rejects a session setup message.
+type StatusError struct {
+	Stage  string
+	Status byte
+}
+
+func (e *StatusError) Error() string {
+	text, ok := statusText[e.Status]
+	if !ok {
+		text = fmt.Sprintf("status 0x%02x", e.Status)
+	}
+	return fmt.Sprintf("ipmi: %s failed: %s", e.Stage, text)
+}
+
+// ErrAuthFailed is returned when the key exchange authentication code of
+// the BMC does not match the password of the user.
+var ErrAuthFailed = errors.New("ipmi: authentication failed")
+
+// CipherSuite is a combination of the authentication, integrity and
+// confidentiality algorithms of an RMCP+ session.
+type CipherSuite struct {
+	ID              byte
+	Auth            byte
+	Integrity       byte
+	Confidentiality byte
+}
+
+var cipherSuites = []CipherSuite{
+	{0, AuthNone, IntegrityNone, ConfidentialityNone},
+	{1, AuthHMACSHA1, IntegrityNone, ConfidentialityNone},
+	{2, AuthHMACSHA1, IntegrityHMACSHA196, ConfidentialityNone},
+	{3, AuthHMACSHA1, IntegrityHMACSHA196, ConfidentialityAESCBC128},
+	{15, AuthHMACSHA256, IntegrityNone, ConfidentialityNone},
+	{16, AuthHMACSHA256, IntegrityHMACSHA256128, ConfidentialityNone},
+	{17, AuthHMACSHA256, IntegrityHMACSHA256128, ConfidentialityAESCBC128},
+}
+
+// LookupCipherSuite returns the supported cipher suite with the given ID.
+func LookupCipherSuite(id byte) (CipherSuite, bool) {
+	for _, c := range cipherSuites {
+		if c.ID == id {
+			return c, true
+		}
+	}
+	return CipherSuite{}, false
+}
+
+func (c CipherSuite) authHash() func() hash.Hash {
+	if c.Auth == AuthHMACSHA256 {
+		return sha256.New
+	}
+	return sha1.New
+}
+
+// authCode returns the HMAC of parts keyed with key using the authentication
+// algorithm, or nil for RAKP-none.
+func (c CipherSuite) authCode(key []byte, parts ...[]byte) []byte {
+	if c.Auth == AuthNone {
+		return nil
+	}
+	h := hmac.New(c.authHash(), key)
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+// icvLen returns the length of the integrity check value of RAKP message 4.
+func (c CipherSuite) icvLen() int {
+	switch c.Auth {
+	case AuthHMACSHA1:
+		return 12
+	case AuthHMACSHA256:
+		return 16
+	}
+	return 0
+}
+
+func (c CipherSuite) integrityHash() func() hash.Hash {
+	if c.Integrity == IntegrityHMACSHA256128 {
+		return sha256.New
+	}
+	return sha1.New
+}
+
+func (c CipherSuite) integrityLen() int {
+	switch c.Integrity {
+	case IntegrityHMACSHA196:
+		return 12
+	case IntegrityHMACSHA256128:
+		return 16
+	}
+	return 0
+}
+
+// SessionKeys are the keys of an established RMCP+ session.
+type SessionKeys struct {
+	Suite CipherSuite
+	SIK   []byte
+	K1    []byte
+	K2    []byte
+}
+
+// Authenticated reports whether the payloads of the session carry an
+// integrity check value.
+func (k *SessionKeys) Authenticated() bool {
+	return k.Suite.Integrity != IntegrityNone
+}
+
+// Encrypted reports whether the payloads of the session are encrypted.
+func (k *SessionKeys) Encrypted() bool {
+	return k.Suite.Confidentiality != ConfidentialityNone
+}
+
+// Handshake holds the values exchanged during the RAKP session setup from
+// which the remote console and the BMC derive the authentication codes and
+// keys of a session.
+type Handshake struct {
+	Suite            CipherSuite
+	ConsoleSessionID uint32
+	BMCSessionID     uint32
+	ConsoleRandom    [16]byte
+	BMCRandom        [16]byte
+	BMCGUID          [16]byte
+	// Role is the requested maximum privilege level of RAKP message 1
+	// including the name-only lookup bit.
+	Role     byte
+	Username string
+	Password string
+	// BMCKey is the optional BMC key K_G. The user key is used if empty.
+	BMCKey []byte
+}
+
+func (h *Handshake) userKey() []byte {
+	k := make([]byte, 20)
+	copy(k, h.Password)
+	return k
+}
+
+func (h *Handshake) name() []byte {
+	return append([]byte{h.Role, byte(len(h.Username))}, h.Username...)
+}
+
+// RAKP2AuthCode returns the key exchange authentication code of RAKP
+// message 2.
+func (h *Handshake) RAKP2AuthCode() []byte {
+	ids := make([]byte, 8)
+	binary.LittleEndian.PutUint32(ids, h.ConsoleSessionID)
+	binary.LittleEndian.PutUint32(ids[4:], h.BMCSessionID)
+	return h.Suite.authCode(h.userKey(), ids, h.ConsoleRandom[:], h.BMCRandom[:], h.BMCGUID[:], h.name())
+}
+
+// RAKP3AuthCode returns the key exchange authentication code of RAKP
+// message 3.
+func (h *Handshake) RAKP3AuthCode() []byte {
+	id := make([]byte, 4)
+	binary.LittleEndian.PutUint32(id, h.ConsoleSessionID)
+	return h.Suite.authCode(h.userKey(), h.BMCRandom[:], id, h.name())
+}
+
+// RAKP4ICV returns the integrity check value of RAKP message 4.
+func (h *Handshake) RAKP4ICV() []byte {
+	id := make([]byte, 4)
+	binary.LittleEndian.PutUint32(id, h.BMCSessionID)
+	icv := h.Suite.authCode(h.Keys().SIK, h.ConsoleRandom[:], id, h.BMCGUID[:])
+	return icv[:h.Suite.icvLen()]
+}
+
+// Keys derives the session keys.
+func (h *Handshake) Keys() *SessionKeys {
+	kg := h.BMCKey
+	if len(kg) == 0 {
+		kg = h.userKey()
+	}
+	sik := h.Suite.authCode(kg, h.ConsoleRandom[:], h.BMCRandom[:], h.name())
+	return &SessionKeys{
+		Suite: h.Suite,
+		SIK:   sik,
+		K1:    h.Suite.authCode(sik, bytes.Repeat([]byte{0x01}, 20)),
+		K2:    h.Suite.authCode(sik, bytes.Repeat([]byte{0x02}, 20)),
+	}
+}
+
+// OpenSessionRequest is the payload requesting a new RMCP+ session.
+type OpenSessionRequest struct {
+	Tag              byte
+	MaxPrivilege     byte
+	ConsoleSessionID uint32
+	Suite            CipherSuite
+}
+
+// Marshal encodes r.
+func (r *OpenSessionRequest) Marshal() []byte {
+	b := make([]byte, 8, 32)
+	b[0] = r.Tag
+	b[1] = r.MaxPrivilege
+	binary.LittleEndian.PutUint32(b[4:], r.ConsoleSessionID)
+	return append(b, marshalAlgorithms(r.Suite)...)
+}
+
+// UnmarshalOpenSessionRequest decodes an open session request.
+func UnmarshalOpenSessionRequest(b []byte) (*OpenSessionRequest, error) {
+	if len(b) < 32 {
+		return nil, errors.New("ipmi: open session request too short")
+	}
+	suite, err := unmarshalAlgorithms(b[8:32])
+	if err != nil {
+		return nil, err
+	}
+	return &OpenSessionRequest{
+		Tag:              b[0],
+		MaxPrivilege:     b[1] & 0x0f,
+		ConsoleSessionID: binary.LittleEndian.Uint32(b[4:]),
+		Suite:            suite,
+	}, nil
+}
+
+// OpenSessionResponse is the answer to an OpenSessionRequest.
+type OpenSessionResponse struct {
+	Tag              byte
+	Status           byte
+	MaxPrivilege     byte
+	ConsoleSessionID uint32
+	BMCSessionID     uint32
+	Suite            CipherSuite
+}
+
+// Marshal encodes r. The algorithms are omitted for failed requests.
+func (r *OpenSessionResponse) Marshal() []byte {
+	b := make([]byte, 8, 36)
+	b[0] = r.Tag
+	b[1] = r.Status
+	b[2] = r.MaxPrivilege
+	binary.LittleEndian.PutUint32(b[4:], r.ConsoleSessionID)
+	if r.Status != StatusOK {
+		return b
+	}
+	b = b[:12]
+	binary.LittleEndian.PutUint32(b[8:], r.BMCSessionID)
+	return append(b, marshalAlgorithms(r.Suite)...)
+}
+
+// UnmarshalOpenSessionResponse decodes an open session response.
+func UnmarshalOpenSessionResponse(b []byte) (*OpenSessionResponse, error) {
+	if len(b) < 8 {
+		return nil, errors.New("ipmi: open session response too short")
+	}
+	r := &OpenSessionResponse{
+		Tag:              b[0],
+		Status:           b[1],
+		MaxPrivilege:     b[2],
+		ConsoleSessionID: binary.LittleEndian.Uint32(b[4:]),
+	}
+	if r.Status != StatusOK {
+		return r, nil
+	}
+	if len(b) < 36 {
+		return nil, errors.New("ipmi: open session response too short")
+	}
+	r.BMCSessionID = binary.LittleEndian.Uint32(b[8:])
+	suite, err := unmarshalAlgorithms(b[12:36])
+	if err != nil {
+		return nil, err
+	}
+	r.Suite = suite
+	return r, nil
+}
+
+func marshalAlgorithms(c CipherSuite) []byte {
+	return []byte{
+		0x00, 0, 0, 0x08, c.Auth, 0, 0, 0,
+		0x01, 0, 0, 0x08, c.Integrity, 0, 0, 0,
+		0x02, 0, 0, 0x08, c.Confidentiality, 0, 0, 0,
+	}
+}
+
+func unmarshalAlgorithms(b []byte) (CipherSuite, error) {
+	var algs [3]byte
+	for i := range algs {
+		p := b[i*8 : i*8+8]
+		if p[0] != byte(i) {
+			return CipherSuite{}, errors.New("ipmi: invalid algorithm payload")
+		}
+		algs[i] = p[4] & 0x3f
+	}
+	for _, c := range cipherSuites {
+		if c.Auth == algs[0] && c.Integrity == algs[1] && c.Confidentiality == algs[2] {
+			return c, nil
+		}
+	}
+	return CipherSuite{}, fmt.Errorf("ipmi: unsupported algorithms 0x%02x/0x%02x/0x%02x", algs[0], algs[1], algs[2])
+}
+
+// RAKP1 is the first message of the RAKP key exchange.
+type RAKP1 struct {
+	Tag           byte
+	BMCSessionID  uint32
+	ConsoleRandom [16]byte
+	Role          byte
+	Username      string
+}
+
+// Marshal encodes m.
+func (m *RAKP1) Marshal() []byte {
+	b := make([]byte, 28, 28+len(m.Username))
+	b[0] = m.Tag
+	binary.LittleEndian.PutUint32(b[4:], m.BMCSessionID)
+	copy(b[8:], m.ConsoleRandom[:])
+	b[24] = m.Role
+	b[27] = byte(len(m.Username))
+	return append(b, m.Username...)
+}
+
+// UnmarshalRAKP1 decodes RAKP message 1.
+func UnmarshalRAKP1(b []byte) (*RAKP1, error) {
+	if len(b) < 28 || len(b) < 28+int(b[27]) {
+		return nil, errors.New("ipmi: RAKP message 1 too short")
+	}
+	m := &RAKP1{
+		Tag:          b[0],
+		BMCSessionID: binary.LittleEndian.Uint32(b[4:]),
+		Role:         b[24],
+		Username:     string(b[28 : 28+int(b[27])]),
+	}
+	copy(m.ConsoleRandom[:], b[8:24])
+	return m, nil
+}
+
+// RAKP2 is the answer of the BMC to RAKP message 1.
+type RAKP2 struct {
+	Tag              byte
+	Status           byte
+	ConsoleSessionID uint32
+	BMCRandom        [16]byte
+	BMCGUID          [16]byte
+	AuthCode         []byte
+}
+
+// Marshal encodes m.
+func (m *RAKP2) Marshal() []byte {
+	b := make([]byte, 8, 40+len(m.AuthCode))
+	b[0] = m.Tag
+	b[1] = m.Status
+	binary.LittleEndian.PutUint32(b[4:], m.ConsoleSessionID)
+	if m.Status != StatusOK {
+		return b
+	}
+	b = append(b, m.BMCRandom[:]...)
+	b = append(b, m.BMCGUID[:]...)
+	return append(b, m.AuthCode...)
+}
+
+// UnmarshalRAKP2 decodes RAKP message 2.
+func UnmarshalRAKP2(b []byte) (*RAKP2, error) {
+	if len(b) < 8 {
+		return nil, errors.New("ipmi: RAKP message 2 too short")
+	}
+	m := &RAKP2{
+		Tag:              b[0],
+		Status:           b[1],
+		ConsoleSessionID: binary.LittleEndian.Uint32(b[4:]),
+	}
+	if m.Status != StatusOK {
+		return m, nil
+	}
+	if len(b) < 40 {
+		return nil, errors.New("ipmi: RAKP message 2 too short")
+	}
+	copy(m.BMCRandom[:], b[8:24])
+	copy(m.BMCGUID[:], b[24:40])
+	m.AuthCode = b[40:]
+	return m, nil
+}
+
+// RAKP3 is the answer of the remote console to RAKP message 2.
+type RAKP3 struct {
+	Tag          byte
+	Status       byte
+	BMCSessionID uint32
+	AuthCode     []byte
+}
+
+// Marshal encodes m.
+func (m *RAKP3) Marshal() []byte {
+	b := make([]byte, 8, 8+len(m.AuthCode))
+	b[0] = m.Tag
+	b[1] = m.Status
+	binary.LittleEndian.PutUint32(b[4:], m.BMCSessionID)
+	return append(b, m.AuthCode...)
+}
+
+// UnmarshalRAKP3 decodes RAKP message 3.
+func UnmarshalRAKP3(b []byte) (*RAKP3, error) {
+	if len(b) < 8 {
+		return nil, errors.New("ipmi: RAKP message 3 too short")
+	}
+	return &RAKP3{
+		Tag:          b[0],
+		Status:       b[1],
+		BMCSessionID: binary.LittleEndian.Uint32(b[4:]),
+		AuthCode:     b[8:],
+	}, nil
+}
+
+// RAKP4 concludes the RAKP key exchange.
+type RAKP4 struct {
+	Tag              byte
+	Status           byte
+	ConsoleSessionID uint32
+	ICV              []byte
+}
+
+// Marshal encodes m.
+func (m *RAKP4) Marshal() []byte {
+	b := make([]byte, 8, 8+len(m.ICV))
+	b[0] = m.Tag
+	b[1] = m.Status
+	binary.LittleEndian.PutUint32(b[4:], m.ConsoleSessionID)
+	return append(b, m.ICV...)
+}
+
+// UnmarshalRAKP4 decodes RAKP message 4.
+func UnmarshalRAKP4(b []byte) (*RAKP4, error) {
+	if len(b) < 8 {
+		return nil, errors.New("ipmi: RAKP message 4 too short")
+	}
+	return &RAKP4{
+		Tag:              b[0],
+		Status:           b[1],
+		ConsoleSessionID: binary.LittleEndian.Uint32(b[4:]),
+		ICV:              b[8:],
+	}, nil
+}
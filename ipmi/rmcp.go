@@ -0,0 +1,319 @@
+package ipmi
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Classes of RMCP messages.
+const (
+	ClassASF  = 0x06
+	ClassIPMI = 0x07
+)
+
+const (
+	rmcpVersion = 0x06
+	rmcpNoAck   = 0xff
+	asfIANA     = 4542
+)
+
+// Types of ASF messages.
+const (
+	ASFPing = 0x80
+	ASFPong = 0x40
+)
+
+// Authentication types of session packets.
+const (
+	AuthTypeNone     = 0x00
+	AuthTypeRMCPPlus = 0x06
+)
+
+// Payload types of RMCP+ session packets.
+const (
+	PayloadIPMI                = 0x00
+	PayloadOpenSessionRequest  = 0x10
+	PayloadOpenSessionResponse = 0x11
+	PayloadRAKP1               = 0x12
+	PayloadRAKP2               = 0x13
+	PayloadRAKP3               = 0x14
+	PayloadRAKP4               = 0x15
+)
+
+// MarshalRMCP wraps body into an RMCP header of the given class.
+func MarshalRMCP(class byte, body []byte) []byte {
+	return append([]byte{rmcpVersion, 0x00, rmcpNoAck, class}, body...)
+}
+
+// UnmarshalRMCP returns the class and body of an RMCP packet.
+func UnmarshalRMCP(b []byte) (class byte, body []byte, err error) {
+	if len(b) < 4 {
+		return 0, nil, errors.New("ipmi: RMCP packet too short")
+	}
+	if b[0] != rmcpVersion {
+		return 0, nil, fmt.Errorf("ipmi: unsupported RMCP version 0x%02x", b[0])
+	}
+	return b[3] & 0x7f, b[4:], nil
+}
+
+// ASFMessage is an ASF message as used for RMCP presence pings.
+type ASFMessage struct {
+	Type byte
+	Tag  byte
+	Data []byte
+}
+
+// Marshal encodes m including its RMCP header.
+func (m *ASFMessage) Marshal() []byte {
+	b := make([]byte, 8, 8+len(m.Data))
+	binary.BigEndian.PutUint32(b, asfIANA)
+	b[4] = m.Type
+	b[5] = m.Tag
+	b[7] = byte(len(m.Data))
+	return MarshalRMCP(ClassASF, append(b, m.Data...))
+}
+
+// UnmarshalASF decodes an ASF message from the body of an RMCP packet.
+func UnmarshalASF(b []byte) (*ASFMessage, error) {
+	if len(b) < 8 || int(b[7]) > len(b)-8 {
+		return nil, errors.New("ipmi: ASF message too short")
+	}
+	if binary.BigEndian.Uint32(b) != asfIANA {
+		return nil, errors.New("ipmi: not an ASF message")
+	}
+	return &ASFMessage{Type: b[4], Tag: b[5], Data: b[8 : 8+int(b[7])]}, nil
+}
+
+// Pong is the answer of a managed system to a presence ping.
+type Pong struct {
+	IANA                  uint32
+	OEM                   uint32
+	SupportedEntities     byte
+	SupportedInteractions byte
+}
+
+// SupportsIPMI reports whether the managed system supports IPMI.
+func (p *Pong) SupportsIPMI() bool {
+	return p.SupportedEntities&0x80 != 0
+}
+
+// Marshal encodes p as the data of an ASF pong message.
+func (p *Pong) Marshal() []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint32(b, p.IANA)
+	binary.BigEndian.PutUint32(b[4:], p.OEM)
+	b[8] = p.SupportedEntities
+	b[9] = p.SupportedInteractions
+	return b
+}
+
+func unmarshalPong(b []byte) (*Pong, error) {
+	if len(b) < 10 {
+		return nil, errors.New("ipmi: pong too short")
+	}
+	return &Pong{
+		IANA:                  binary.BigEndian.Uint32(b),
+		OEM:                   binary.BigEndian.Uint32(b[4:]),
+		SupportedEntities:     b[8],
+		SupportedInteractions: b[9],
+	}, nil
+}
+
+// SessionPacket is the session wrapper of IPMI messages. IPMI v1.5 packets
+// are only supported without authentication.
+type SessionPacket struct {
+	AuthType      byte
+	PayloadType   byte
+	Encrypted     bool
+	Authenticated bool
+	SessionID     uint32
+	Sequence      uint32
+	Payload       []byte
+}
+
+// Marshal encodes p including its RMCP header. Encrypted or authenticated
+// payloads are protected with the given session keys.
+func (p *SessionPacket) Marshal(keys *SessionKeys) ([]byte, error) {
+	if p.AuthType == AuthTypeNone {
+		if len(p.Payload) > 0xff {
+			return nil, errors.New("ipmi: payload too long")
+		}
+		b := make([]byte, 10, 10+len(p.Payload))
+		binary.LittleEndian.PutUint32(b[1:], p.Sequence)
+		binary.LittleEndian.PutUint32(b[5:], p.SessionID)
+		b[9] = byte(len(p.Payload))
+		return MarshalRMCP(ClassIPMI, append(b, p.Payload...)), nil
+	}
+	if p.AuthType != AuthTypeRMCPPlus {
+		return nil, fmt.Errorf("ipmi: unsupported authentication type 0x%02x", p.AuthType)
+	}
+
+	if (p.Encrypted || p.Authenticated) && keys == nil {
+		return nil, errors.New("ipmi: protected payload without session keys")
+	}
+	payload := p.Payload
+	if p.Encrypted {
+		var err error
+		if payload, err = keys.encrypt(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	b := make([]byte, 12, 12+len(payload)+32)
+	b[0] = AuthTypeRMCPPlus
+	b[1] = p.PayloadType & 0x3f
+	if p.Encrypted {
+		b[1] |= 0x80
+	}
+	if p.Authenticated {
+		b[1] |= 0x40
+	}
+	binary.LittleEndian.PutUint32(b[2:], p.SessionID)
+	binary.LittleEndian.PutUint32(b[6:], p.Sequence)
+	binary.LittleEndian.PutUint16(b[10:], uint16(len(payload)))
+	b = append(b, payload...)
+
+	if p.Authenticated {
+		pad := (4 - (len(b)+2)%4) % 4
+		for i := 0; i < pad; i++ {
+			b = append(b, 0xff)
+		}
+		b = append(b, byte(pad), 0x07)
+		b = append(b, keys.authCode(b)...)
+	}
+	return MarshalRMCP(ClassIPMI, b), nil
+}
+
+// UnmarshalSession decodes a session packet from the body of an RMCP packet.
+// The function keys returns the keys of the session with the given ID for
+// the verification and decryption of protected payloads.
+func UnmarshalSession(b []byte, keys func(sessionID uint32) *SessionKeys) (*SessionPacket, error) {
+	if len(b) < 1 {
+		return nil, errors.New("ipmi: session packet too short")
+	}
+
+	switch b[0] {
+	case AuthTypeNone:
+		if len(b) < 10 || int(b[9]) > len(b)-10 {
+			return nil, errors.New("ipmi: session packet too short")
+		}
+		return &SessionPacket{
+			AuthType:  AuthTypeNone,
+			Sequence:  binary.LittleEndian.Uint32(b[1:]),
+			SessionID: binary.LittleEndian.Uint32(b[5:]),
+			Payload:   b[10 : 10+int(b[9])],
+		}, nil
+	case AuthTypeRMCPPlus:
+	default:
+		return nil, fmt.Errorf("ipmi: unsupported authentication type 0x%02x", b[0])
+	}
+
+	if len(b) < 12 {
+		return nil, errors.New("ipmi: session packet too short")
+	}
+	p := &SessionPacket{
+		AuthType:      AuthTypeRMCPPlus,
+		PayloadType:   b[1] & 0x3f,
+		Encrypted:     b[1]&0x80 != 0,
+		Authenticated: b[1]&0x40 != 0,
+		SessionID:     binary.LittleEndian.Uint32(b[2:]),
+		Sequence:      binary.LittleEndian.Uint32(b[6:]),
+	}
+	n := int(binary.LittleEndian.Uint16(b[10:]))
+	if n > len(b)-12 {
+		return nil, errors.New("ipmi: session packet too short")
+	}
+	payload := b[12 : 12+n]
+
+	var k *SessionKeys
+	if p.Encrypted || p.Authenticated {
+		if k = keys(p.SessionID); k == nil {
+			return nil, fmt.Errorf("ipmi: unknown session 0x%08x", p.SessionID)
+		}
+	}
+
+	if p.Authenticated {
+		trailer := b[12+n:]
+		codeLen := k.Suite.integrityLen()
+		if len(trailer) < 2+codeLen {
+			return nil, errors.New("ipmi: session trailer too short")
+		}
+		covered := b[:len(b)-codeLen]
+		if !hmac.Equal(k.authCode(covered), b[len(b)-codeLen:]) {
+			return nil, errors.New("ipmi: invalid integrity check value")
+		}
+	}
+	if p.Encrypted {
+		var err error
+		if payload, err = k.decrypt(payload); err != nil {
+			return nil, err
+		}
+	}
+	p.Payload = payload
+	return p, nil
+}
+
+// authCode returns the integrity check value of b.
+func (k *SessionKeys) authCode(b []byte) []byte {
+	h := hmac.New(k.Suite.integrityHash(), k.K1)
+	h.Write(b)
+	return h.Sum(nil)[:k.Suite.integrityLen()]
+}
+
+// encrypt encrypts b with AES-CBC-128, prepending the random IV.
+func (k *SessionKeys) encrypt(b []byte) ([]byte, error) {
+	if k.Suite.Confidentiality != ConfidentialityAESCBC128 {
+		return nil, errors.New("ipmi: unsupported confidentiality algorithm")
+	}
+	block, err := aes.NewCipher(k.K2[:16])
+	if err != nil {
+		return nil, err
+	}
+
+	pad := (aes.BlockSize - (len(b)+1)%aes.BlockSize) % aes.BlockSize
+	plain := make([]byte, 0, len(b)+pad+1)
+	plain = append(plain, b...)
+	for i := 1; i <= pad; i++ {
+		plain = append(plain, byte(i))
+	}
+	plain = append(plain, byte(pad))
+
+	out := make([]byte, aes.BlockSize+len(plain))
+	if _, err := rand.Read(out[:aes.BlockSize]); err != nil {
+		return nil, err
+	}
+	cipher.NewCBCEncrypter(block, out[:aes.BlockSize]).CryptBlocks(out[aes.BlockSize:], plain)
+	return out, nil
+}
+
+// decrypt reverses encrypt.
+func (k *SessionKeys) decrypt(b []byte) ([]byte, error) {
+	if k.Suite.Confidentiality != ConfidentialityAESCBC128 {
+		return nil, errors.New("ipmi: unsupported confidentiality algorithm")
+	}
+	if len(b) < 2*aes.BlockSize || len(b)%aes.BlockSize != 0 {
+		return nil, errors.New("ipmi: invalid encrypted payload length")
+	}
+	block, err := aes.NewCipher(k.K2[:16])
+	if err != nil {
+		return nil, err
+	}
+
+	plain := make([]byte, len(b)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, b[:aes.BlockSize]).CryptBlocks(plain, b[aes.BlockSize:])
+	pad := int(plain[len(plain)-1])
+	if pad >= aes.BlockSize || pad+1 > len(plain) {
+		return nil, errors.New("ipmi: invalid confidentiality pad")
+	}
+	for i := 0; i < pad; i++ {
+		if plain[len(plain)-1-pad+i] != byte(i+1) {
+			return nil, errors.New("ipmi: invalid confidentiality pad")
+		}
+	}
+	return plain[:len(plain)-1-pad], nil
+}
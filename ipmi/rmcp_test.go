@@ -0,0 +1,71 @@
+package ipmi
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMessage(t *testing.T) {
+	req := &Message{RsAddr: BMCAddress, NetFn: NetFnApp, RqAddr: ConsoleAddress, Seq: 5, Cmd: CmdGetDeviceID}
+	b := req.Marshal()
+	if !bytes.Equal(b, []byte{0x20, 0x18, 0xc8, 0x81, 0x14, 0x01, 0x6a}) {
+		t.Fatalf("unexpected encoding % x", b)
+	}
+
+	resp := req.Response(CompletionOK, []byte{0x20})
+	got, err := UnmarshalMessage(resp.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsResponse() || got.Seq != 5 || got.RqAddr != BMCAddress || !bytes.Equal(got.Data, []byte{0, 0x20}) {
+		t.Errorf("unexpected response %+v", got)
+	}
+
+	b[len(b)-1]++
+	if _, err := UnmarshalMessage(b); err == nil {
+		t.Error("want checksum error")
+	}
+}
+
+func TestSessionPacket(t *testing.T) {
+	for _, id := range []byte{3, 17} {
+		suite, _ := LookupCipherSuite(id)
+		h := &Handshake{Suite: suite, Role: PrivilegeAdministrator, Username: "ADMIN", Password: "ADMIN"}
+		keys := h.Keys()
+
+		p := &SessionPacket{
+			AuthType:      AuthTypeRMCPPlus,
+			Encrypted:     true,
+			Authenticated: true,
+			SessionID:     0x11223344,
+			Sequence:      7,
+			Payload:       []byte("payload"),
+		}
+		b, err := p.Marshal(keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lookup := func(uint32) *SessionKeys { return keys }
+
+		_, body, err := UnmarshalRMCP(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := UnmarshalSession(body, lookup)
+		if err != nil {
+			t.Fatalf("cipher suite %d: %v", id, err)
+		}
+		if string(got.Payload) != "payload" || got.SessionID != p.SessionID || got.Sequence != 7 {
+			t.Errorf("cipher suite %d: unexpected packet %+v", id, got)
+		}
+		// The integrity data is padded to a multiple of four bytes.
+		if (len(body)-suite.integrityLen())%4 != 0 {
+			t.Errorf("cipher suite %d: integrity data not padded", id)
+		}
+
+		body[20] ^= 0xff
+		if _, err := UnmarshalSession(body, lookup); err == nil {
+			t.Errorf("cipher suite %d: want error for tampered packet", id)
+		}
+	}
+}
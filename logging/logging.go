@@ -0,0 +1,89 @@
+// Package logging limits repeated errors logged with the structured loggers
+// of github.com/prometheus/common/log, e.g. the errors of a BMC that stopped
+// responding.
+package logging
+
+import (
+	"flag"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/log"
+)
+
+// RepeatInterval is the default interval of a Limiter.
+var RepeatInterval = 10 * time.Minute
+
+// AddFlags adds the flag setting RepeatInterval to fs.
+func AddFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&RepeatInterval, "log.repeat-interval", RepeatInterval, "Interval in which an error repeated for the same target and command is logged once")
+}
+
+// Limiter logs an error repeated for the same key at most once per interval.
+// The number of suppressed repetitions is logged with the next error as
+// field "repeated".
+type Limiter struct {
+	Interval time.Duration
+
+	mu   sync.Mutex
+	last map[string]*repeated
+	now  func() time.Time
+}
+
+type repeated struct {
+	msg        string
+	logged     time.Time
+	suppressed int
+}
+
+// NewLimiter returns a limiter with the interval RepeatInterval.
+func NewLimiter() *Limiter {
+	return &Limiter{
+		Interval: RepeatInterval,
+		last:     map[string]*repeated{},
+		now:      time.Now,
+	}
+}
+
+// Allow returns whether err is logged for key, and the logger to log it
+// with. It returns false if the same error was logged for key within the
+// interval; a different error for key is always logged. The error is logged
+// by the caller, so that the source field of the log line is the caller:
+//
+//	if logger, ok := l.Allow(logger, key, err); ok {
+//		logger.Error(err)
+//	}
+func (l *Limiter) Allow(logger log.Logger, key string, err error) (log.Logger, bool) {
+	n, ok := l.allow(key, err.Error())
+	if ok && n > 0 {
+		logger = logger.With("repeated", n)
+	}
+	return logger, ok
+}
+
+// Reset forgets the errors of key, e.g. after it succeeded, so that the next
+// error is logged immediately.
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.last, key)
+}
+
+// allow returns whether msg is logged for key and the number of repetitions
+// suppressed since it was last logged.
+func (l *Limiter) allow(key, msg string) (int, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	r, ok := l.last[key]
+	if ok && r.msg == msg && now.Sub(r.logged) < l.Interval {
+		r.suppressed++
+		return 0, false
+	}
+	n := 0
+	if ok && r.msg == msg {
+		n = r.suppressed
+	}
+	l.last[key] = &repeated{msg: msg, logged: now}
+	return n, true
+}
@@ -0,0 +1,61 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/log"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := NewLimiter()
+	l.Interval = time.Minute
+	l.now = func() time.Time { return now }
+
+	for _, tc := range []struct {
+		after time.Duration
+		key   string
+		msg   string
+		log   bool
+		n     int
+	}{
+		{0, "sensor", "timeout", true, 0},
+		{10 * time.Second, "sensor", "timeout", false, 0},
+		{10 * time.Second, "sensor", "timeout", false, 0},
+		{10 * time.Second, "sel info", "timeout", true, 0},
+		{10 * time.Second, "sensor", "session failed", true, 0},
+		{10 * time.Second, "sensor", "session failed", false, 0},
+		{time.Minute, "sensor", "session failed", true, 1},
+		{time.Minute, "sensor", "session failed", true, 0},
+	} {
+		now = now.Add(tc.after)
+		n, ok := l.allow(tc.key, tc.msg)
+		if ok != tc.log || n != tc.n {
+			t.Errorf("%v %s %q: want %v with %d repetitions, got %v with %d", now, tc.key, tc.msg, tc.log, tc.n, ok, n)
+		}
+	}
+
+	var buf bytes.Buffer
+	err := errors.New("session failed")
+	for i := 0; i < 3; i++ {
+		if logger, ok := l.Allow(log.NewLogger(&buf), "sensor", err); ok {
+			logger.Error(err)
+		}
+	}
+	now = now.Add(time.Minute)
+	if logger, ok := l.Allow(log.NewLogger(&buf), "sensor", err); ok {
+		logger.Error(err)
+	}
+	if n := strings.Count(buf.String(), "session failed"); n != 1 || !strings.Contains(buf.String(), "repeated=3") {
+		t.Errorf("want the error logged once with repeated=3, got:\n%s", buf.String())
+	}
+
+	l.Reset("sel info")
+	if _, ok := l.allow("sel info", "timeout"); !ok {
+		t.Error("want error logged after reset")
+	}
+}
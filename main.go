@@ -5,8 +5,17 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/lovoo/ipmi_exporter/collector"
+	"github.com/lovoo/ipmi_exporter/config"
+	"github.com/lovoo/ipmi_exporter/discovery"
+	"github.com/lovoo/ipmi_exporter/logging"
+	"github.com/lovoo/ipmi_exporter/sel"
+	"github.com/lovoo/ipmi_exporter/web"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -16,16 +25,39 @@ import (
 
 var (
 	listenAddress = flag.String("web.listen", ":9289", "Address on which to expose metrics and web interface.")
+	webConfig     = flag.String("web.config.file", "", "Web configuration file enabling TLS and basic authentication")
 	metricsPath   = flag.String("web.path", "/metrics", "Path under which to expose metrics.")
 	ipmiBinary    = flag.String("ipmi.path", "ipmitool", "Path to the ipmi binary")
+	profileName   = flag.String("ipmi.profile", "", "Vendor profile to use instead of detecting it from the BMC manufacturer ID")
+	profileDir    = flag.String("ipmi.profiles", "", "Directory with additional vendor profiles (*.json)")
+	replayDir     = flag.String("ipmi.replay", "", "Serve recorded command outputs from this directory instead of calling the ipmi binary")
+	recordDir     = flag.String("ipmi.record", "", "Record the output of all ipmi commands to this directory")
+	configFile    = flag.String("config.file", "", "Configuration file with the modules and credentials used to probe remote BMCs")
+	probePath     = flag.String("web.probe-path", "/ipmi", "Path under which to expose metrics of remote BMCs given by the target parameter")
+	sdFile        = flag.String("sd.file", "", "Write the targets of the configuration file to this file for Prometheus file service discovery")
+	sdrCacheDir   = flag.String("ipmi.sdr-cache", "", "Directory caching the sensor data records of the local BMC and the named targets, read from the BMCs with every scrape if empty")
+	sdrCacheAge   = flag.Duration("ipmi.sdr-cache.max-age", time.Hour, "Age after which the cached sensor data records are read from the BMC again")
+	energyPoll    = flag.Duration("energy.interval", 0, "Interval of the power readings integrated into energy counters, 0 disables the counters")
+	energyDir     = flag.String("energy.state-dir", "", "Directory persisting the energy counters across restarts")
+	collectors    = flag.String("collectors", "", "Comma separated list of optional collectors to enable: "+strings.Join(collector.CollectorNames(), ", "))
 	showVersion   = flag.Bool("version", false, "Show version information and exit")
 )
 
 func init() {
 	prometheus.MustRegister(version.NewCollector("ipmi_exporter"))
+	logging.AddFlags(flag.CommandLine)
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "rules" {
+		os.Exit(runRules(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "dashboard" {
+		os.Exit(runDashboard(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "probe" {
+		os.Exit(runProbe(os.Args[2:]))
+	}
 	flag.Parse()
 
 	if *showVersion {
@@ -36,27 +68,202 @@ func main() {
 	log.Infoln("Starting IPMI Exporter", version.Info())
 	log.Infoln("Build context", version.BuildContext())
 
-	prometheus.MustRegister(collector.NewExporter(*ipmiBinary))
+	var webCfg *web.Config
+	if *webConfig != "" {
+		var err error
+		if webCfg, err = web.LoadConfig(*webConfig); err != nil {
+			log.Fatalf("Error loading web configuration: %v", err)
+		}
+	}
 
-	handler := promhttp.Handler()
-	if *metricsPath == "" || *metricsPath == "/" {
-		http.Handle(*metricsPath, handler)
-	} else {
-		http.Handle(*metricsPath, handler)
-		http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-			w.Write([]byte(`<html>
-			<head><title>IPMI Exporter</title></head>
-			<body>
-			<h1>IPMI Exporter</h1>
-			<p><a href="` + *metricsPath + `">Metrics</a></p>
-			</body>
-			</html>`))
+	if *replayDir != "" {
+		log.Infoln("Replaying recorded outputs from", *replayDir)
+	}
+	if *recordDir != "" {
+		log.Infoln("Recording outputs to", *recordDir)
+	}
+	if *sdrCacheDir != "" {
+		if err := os.MkdirAll(*sdrCacheDir, 0755); err != nil {
+			log.Fatalf("Error creating SDR cache directory: %v", err)
+		}
+	}
+	backend := localBackend()
+
+	exporter := collector.NewExporter(backend)
+	exporter.Logger = log.With("target", config.LocalTarget)
+	exporter.Profile = *profileName
+	exporter.Collectors = splitList(*collectors)
+	if err := collector.ValidateCollectors(exporter.Collectors); err != nil {
+		log.Fatalf("Error enabling collectors: %v", err)
+	}
+	if *profileDir != "" {
+		profiles, err := collector.LoadProfiles(*profileDir)
+		if err != nil {
+			log.Fatalf("Error loading vendor profiles: %v", err)
+		}
+		exporter.Profiles = profiles
+	}
+	if *energyDir != "" {
+		if err := os.MkdirAll(*energyDir, 0755); err != nil {
+			log.Fatalf("Error creating energy state directory: %v", err)
+		}
+	}
+	if *energyPoll > 0 {
+		exporter.StartEnergyMeter(*energyPoll, energyStateFile("local"), nil)
+	}
+	prometheus.MustRegister(exporter)
+
+	cfg := config.Default()
+	if *configFile != "" {
+		var err error
+		cfg, err = config.Load(*configFile)
+		if err != nil {
+			log.Fatalf("Error loading configuration: %v", err)
+		}
+	}
+	for name, m := range cfg.Modules {
+		if err := collector.ValidateCollectors(m.Collectors); err != nil {
+			log.Fatalf("Error in module %s: %v", name, err)
+		}
+	}
+	if *sdFile != "" {
+		if err := cfg.WriteFileSD(*sdFile); err != nil {
+			log.Fatalf("Error writing service discovery file: %v", err)
+		}
+	}
+	if cfg.Discovery != nil {
+		scanner := discovery.NewScanner(cfg)
+		if *sdFile != "" {
+			scanner.OnScan = func() {
+				if err := cfg.WriteFileSD(*sdFile); err != nil {
+					log.Errorf("Error writing service discovery file: %v", err)
+				}
+			}
+		}
+		prometheus.MustRegister(scanner)
+		go scanner.Run(nil)
+	}
+	prober := newProber(cfg, exporter.Profiles)
+	http.Handle(*probePath, prober)
+	http.HandleFunc("/sd/targets", prober.serveSD)
+	http.Handle(apiPrefix, &api{local: exporter, prober: prober})
+	if cfg.SEL != nil {
+		events := sel.NewBroker()
+		tailer := sel.NewTailer(cfg.SEL.StateFile, func(target string) (collector.Backend, error) {
+			if target == config.LocalTarget {
+				return backend, nil
+			}
+			return prober.namedBackend(target)
 		})
+		tailer.Targets = cfg.SEL.Targets
+		tailer.Interval = time.Duration(cfg.SEL.Interval)
+		tailer.Sinks = []sel.Sink{events}
+		if cfg.SEL.Stdout {
+			tailer.Sinks = append(tailer.Sinks, sel.NewWriter(os.Stdout))
+		}
+		if w := cfg.SEL.Webhook; w != nil {
+			tailer.Sinks = append(tailer.Sinks, &sel.Webhook{
+				URL:    w.URL,
+				Client: &http.Client{Timeout: time.Duration(w.Timeout)},
+			})
+		}
+		if a := cfg.SEL.Alertmanager; a != nil {
+			tailer.Sinks = append(tailer.Sinks, &sel.Alertmanager{
+				URL:          a.URL,
+				Client:       &http.Client{Timeout: time.Duration(a.Timeout)},
+				ResolveAfter: time.Duration(a.ResolveAfter),
+				Rules:        alertRules(a.Rules),
+				Labels:       cfg.TargetLabels,
+			})
+		}
+		if c := cfg.SEL.Clear; c != nil {
+			tailer.Clear = &sel.ClearPolicy{Threshold: c.Threshold, ArchiveDir: c.ArchiveDir, DryRun: c.DryRun}
+		}
+		prometheus.MustRegister(tailer)
+		http.Handle("/events", events)
+		go tailer.Run(nil)
 	}
 
+	statusPath := "/"
+	if *metricsPath == "" || *metricsPath == "/" {
+		statusPath = "/status"
+	}
+	status := newStatusPage(statusPath, *probePath, cfg, exporter, prober)
+	status.links["Metrics"] = *metricsPath
+	status.links["Service discovery"] = "/sd/targets"
+	if cfg.SEL != nil {
+		status.links["SEL events"] = "/events"
+	}
+	http.Handle(*metricsPath, promhttp.Handler())
+	http.Handle(statusPath, status)
+	http.HandleFunc("/status/probe", status.probeNow)
+	http.HandleFunc("/status/trace", status.serveTrace)
+
 	log.Infoln("Listening on", *listenAddress, *metricsPath)
-	err := http.ListenAndServe(*listenAddress, nil)
+	err := web.ListenAndServe(&http.Server{Addr: *listenAddress}, webCfg)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
+
+// localBackend returns the backend querying the local BMC.
+func localBackend() collector.Backend {
+	var backend collector.Backend = &collector.IPMITool{
+		Path:           *ipmiBinary,
+		SDRCache:       sdrCacheFile(config.LocalTarget),
+		SDRCacheMaxAge: *sdrCacheAge,
+	}
+	if *replayDir != "" {
+		backend = &collector.Replay{Dir: *replayDir}
+	}
+	if *recordDir != "" {
+		backend = &collector.Recorder{Backend: backend, Dir: *recordDir}
+	}
+	return backend
+}
+
+// energyStateFile returns the file persisting the energy counters of the
+// node with the given name.
+func energyStateFile(name string) string {
+	if *energyDir == "" {
+		return ""
+	}
+	return filepath.Join(*energyDir, collector.FixtureName([]string{name})+".json")
+}
+
+// sdrCacheFile returns the file caching the sensor data records of the node
+// with the given name, empty if they are not cached.
+func sdrCacheFile(name string) string {
+	if *sdrCacheDir == "" {
+		return ""
+	}
+	return filepath.Join(*sdrCacheDir, collector.FixtureName([]string{name})+".sdr")
+}
+
+// alertRules compiles the SEL alert rules of the configuration, which have
+// been validated when loading it.
+func alertRules(rules []*config.AlertRule) []*sel.Rule {
+	compiled := make([]*sel.Rule, 0, len(rules))
+	for _, r := range rules {
+		rule := &sel.Rule{Alert: r.Alert, Labels: r.Labels, Annotations: r.Annotations}
+		if r.Sensor != "" {
+			rule.Sensor = regexp.MustCompile(r.Sensor)
+		}
+		if r.Description != "" {
+			rule.Description = regexp.MustCompile(r.Description)
+		}
+		compiled = append(compiled, rule)
+	}
+	return compiled
+}
+
+// splitList splits a comma separated list, ignoring empty elements.
+func splitList(s string) []string {
+	var list []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
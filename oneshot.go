@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lovoo/ipmi_exporter/collector"
+	"github.com/lovoo/ipmi_exporter/config"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/log"
+)
+
+// probeFormats are the output formats of the probe subcommand.
+var probeFormats = map[string]func(io.Writer, string, []*dto.MetricFamily, *collector.Collection) error{
+	"json":  writeProbeJSON,
+	"table": writeProbeTable,
+	"text":  writeProbeText,
+}
+
+// runProbe implements the probe subcommand, which collects the metrics of a
+// BMC once and prints them, and returns the exit code: 0 if all parts of the
+// collection succeeded, 1 otherwise.
+func runProbe(args []string) int {
+	fs := flag.NewFlagSet("probe", flag.ContinueOnError)
+	target := fs.String("target", config.LocalTarget, "BMC to probe, the local BMC by default")
+	moduleName := fs.String("module", "", "Module used to probe a remote BMC, the module of a named target by default")
+	format := fs.String("format", "text", "Output format: json, table or text")
+	// The flags of the exporter select the backend, profiles and collectors.
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	write, ok := probeFormats[*format]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown format %q, valid formats are json, table, text\n", *format)
+		return 2
+	}
+
+	exporter, err := probeExporter(*target, *moduleName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error probing %s: %v\n", *target, err)
+		return 1
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter)
+	mfs, err := registry.Gather()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error probing %s: %v\n", *target, err)
+		return 1
+	}
+	collection := exporter.LastCollection()
+	if err := write(os.Stdout, *target, mfs, collection); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := collection.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error probing %s: %v\n", *target, err)
+		return 1
+	}
+	return 0
+}
+
+// probeExporter returns the exporter of the local BMC, or of a remote BMC
+// probed with the module like the probe endpoint does.
+func probeExporter(target, moduleName string) (*collector.Exporter, error) {
+	profiles := collector.DefaultProfiles()
+	if *profileDir != "" {
+		var err error
+		if profiles, err = collector.LoadProfiles(*profileDir); err != nil {
+			return nil, err
+		}
+	}
+
+	if target == config.LocalTarget && moduleName == "" {
+		e := collector.NewExporter(localBackend())
+		e.Logger = log.With("target", config.LocalTarget)
+		e.Profile = *profileName
+		e.Profiles = profiles
+		e.Collectors = splitList(*collectors)
+		if err := collector.ValidateCollectors(e.Collectors); err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+
+	cfg := config.Default()
+	if *configFile != "" {
+		var err error
+		if cfg, err = config.Load(*configFile); err != nil {
+			return nil, err
+		}
+	}
+	p := newProber(cfg, profiles)
+	moduleName, module, address, err := p.resolve(target, moduleName)
+	if err != nil {
+		return nil, err
+	}
+	if err := collector.ValidateCollectors(module.Collectors); err != nil {
+		return nil, err
+	}
+	return p.exporter(target, address, moduleName, module)
+}
+
+func writeProbeText(w io.Writer, target string, mfs []*dto.MetricFamily, c *collector.Collection) error {
+	for _, mf := range mfs {
+		if _, err := expfmt.MetricFamilyToText(w, mf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeProbeTable(w io.Writer, target string, mfs []*dto.MetricFamily, c *collector.Collection) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "METRIC\tLABELS\tVALUE")
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			var labels []string
+			for _, l := range m.GetLabel() {
+				labels = append(labels, fmt.Sprintf("%s=%q", l.GetName(), l.GetValue()))
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%g\n", mf.GetName(), strings.Join(labels, ","), sampleValue(m))
+		}
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "PART\tRESULT")
+	for _, p := range probeParts(c) {
+		result := "ok"
+		if !p.Success {
+			result = p.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", p.Name, result)
+	}
+	return tw.Flush()
+}
+
+// probeResult is the output of the probe subcommand in the json format.
+type probeResult struct {
+	Target          string        `json:"target"`
+	DurationSeconds float64       `json:"duration_seconds"`
+	Parts           []*probePart  `json:"parts"`
+	Metrics         []probeSample `json:"metrics"`
+}
+
+// probePart is the result of a part of the collection, see
+// collector.Collection.
+type probePart struct {
+	Name    string `json:"name"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+type probeSample struct {
+	Name   string            `json:"name"`
+	Help   string            `json:"help"`
+	Type   string            `json:"type"`
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+}
+
+func writeProbeJSON(w io.Writer, target string, mfs []*dto.MetricFamily, c *collector.Collection) error {
+	result := probeResult{
+		Target:          target,
+		DurationSeconds: c.Duration.Seconds(),
+		Parts:           probeParts(c),
+		Metrics:         []probeSample{},
+	}
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			result.Metrics = append(result.Metrics, probeSample{
+				Name:   mf.GetName(),
+				Help:   mf.GetHelp(),
+				Type:   strings.ToLower(mf.GetType().String()),
+				Labels: labels,
+				Value:  sampleValue(m),
+			})
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(result)
+}
+
+// probeParts returns the parts of the collection sorted by name.
+func probeParts(c *collector.Collection) []*probePart {
+	parts := []*probePart{}
+	for name, err := range c.Parts {
+		p := &probePart{Name: name, Success: err == nil}
+		if err != nil {
+			p.Error = err.Error()
+		}
+		parts = append(parts, p)
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Name < parts[j].Name })
+	return parts
+}
+
+// sampleValue returns the value of a gauge, counter or untyped metric.
+func sampleValue(m *dto.Metric) float64 {
+	switch {
+	case m.Gauge != nil:
+		return m.GetGauge().GetValue()
+	case m.Counter != nil:
+		return m.GetCounter().GetValue()
+	}
+	return m.GetUntyped().GetValue()
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/lovoo/ipmi_exporter/collector"
+	"github.com/lovoo/ipmi_exporter/config"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/log"
+)
+
+var probesRejected = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "ipmi_exporter",
+		Name:      "probes_rejected_total",
+		Help:      "Number of probes rejected because of their target.",
+	},
+	[]string{"module", "reason"},
+)
+
+func init() {
+	prometheus.MustRegister(probesRejected)
+}
+
+// prober exposes the metrics of remote BMCs. The exporters of named targets
+// are kept between probes so the detected vendor profile and disabled raw
+// commands persist. Other addresses get a new exporter for each probe, so
+// probes of arbitrary addresses cannot grow the memory of the exporter.
+type prober struct {
+	config   *config.Config
+	profiles []*collector.Profile
+	// status records the collections of the exporters if set.
+	status *statusPage
+
+	mu        sync.Mutex
+	exporters map[string]*cachedExporter
+}
+
+// cachedExporter is the exporter of a named target. Its energy meter runs
+// until stop is closed.
+type cachedExporter struct {
+	target   string
+	exporter *collector.Exporter
+	stop     chan struct{}
+}
+
+func newProber(c *config.Config, profiles []*collector.Profile) *prober {
+	return &prober{
+		config:    c,
+		profiles:  profiles,
+		exporters: map[string]*cachedExporter{},
+	}
+}
+
+func (p *prober) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		http.Error(w, "'target' parameter must be specified", http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("debug") == "true" {
+		moduleName, module, address, status, err := p.allowed(target, r.URL.Query().Get("module"))
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		p.serveDebug(w, target, moduleName, module, address)
+		return
+	}
+	exporter, status, err := p.lookup(target, r.URL.Query().Get("module"))
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter)
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// unknownModuleError is returned for a probe with an unknown module.
+type unknownModuleError string
+
+func (e unknownModuleError) Error() string {
+	return fmt.Sprintf("unknown module %q", string(e))
+}
+
+// resolve returns the module and address used to probe target with the named
+// module, which defaults to the module of a named target. An error other than
+// unknownModuleError means the target may not be probed.
+func (p *prober) resolve(target, moduleName string) (string, *config.Module, string, error) {
+	if t, ok := p.config.Target(target); ok && moduleName == "" {
+		moduleName = t.Module
+	}
+	module, ok := p.config.Module(moduleName)
+	if !ok {
+		return moduleName, nil, "", unknownModuleError(moduleName)
+	}
+	if moduleName == "" {
+		moduleName = config.DefaultModule
+	}
+	address, err := p.config.Resolve(module, target)
+	return moduleName, module, address, err
+}
+
+// allowed resolves target like resolve and counts the rejected probes. On
+// error it returns the HTTP status code of the error.
+func (p *prober) allowed(target, moduleName string) (string, *config.Module, string, int, error) {
+	moduleName, module, address, err := p.resolve(target, moduleName)
+	switch err := err.(type) {
+	case nil:
+		return moduleName, module, address, http.StatusOK, nil
+	case unknownModuleError:
+		return moduleName, nil, "", http.StatusBadRequest, err
+	default:
+		reason := "not_allowed"
+		if err == config.ErrUnknownTarget {
+			reason = "unknown_target"
+		}
+		probesRejected.WithLabelValues(moduleName, reason).Inc()
+		log.With("target", target).With("module", moduleName).Warnf("Rejected probe: %v", err)
+		return moduleName, nil, "", http.StatusForbidden, err
+	}
+}
+
+// lookup returns the exporter probing target with the named module. On error
+// it returns the HTTP status code of the error.
+func (p *prober) lookup(target, moduleName string) (*collector.Exporter, int, error) {
+	moduleName, module, address, status, err := p.allowed(target, moduleName)
+	if err != nil {
+		return nil, status, err
+	}
+
+	exporter, err := p.exporter(target, address, moduleName, module)
+	if err != nil {
+		log.With("target", target).With("module", moduleName).Errorf("Error probing: %v", err)
+		return nil, http.StatusInternalServerError, err
+	}
+	return exporter, http.StatusOK, nil
+}
+
+// serveSD serves the named targets in the Prometheus HTTP service discovery
+// format.
+func (p *prober) serveSD(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(p.config.ServiceDiscovery()); err != nil {
+		log.Errorf("Error encoding service discovery targets: %v", err)
+	}
+}
+
+func (p *prober) exporter(target, address, moduleName string, module *config.Module) (*collector.Exporter, error) {
+	key := moduleName + "/" + target
+	_, named := p.config.Target(target)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prune()
+	if c, ok := p.exporters[key]; ok {
+		return c.exporter, nil
+	}
+
+	backend, err := p.backend(target, address, module)
+	if err != nil {
+		return nil, err
+	}
+
+	e := p.newExporter(backend, target, moduleName, module)
+	if p.status != nil {
+		e.OnCollect = p.status.observer(target, moduleName)
+		e.TraceCollections = true
+	}
+	if !named {
+		return e, nil
+	}
+	c := &cachedExporter{target: target, exporter: e, stop: make(chan struct{})}
+	// Energy meters poll in the background and persist their counters, so
+	// they only run for the targets of the configuration file.
+	if _, configured := p.config.Targets[target]; configured && *energyPoll > 0 {
+		e.StartEnergyMeter(*energyPoll, energyStateFile(moduleName+"_"+target), c.stop)
+	}
+	p.exporters[key] = c
+	return e, nil
+}
+
+// prune removes the exporters of targets that are no longer named, e.g.
+// discovered BMCs that disappeared, and stops their energy meters. It must be
+// called with p.mu held.
+func (p *prober) prune() {
+	for key, c := range p.exporters {
+		if _, ok := p.config.Target(c.target); !ok {
+			close(c.stop)
+			delete(p.exporters, key)
+		}
+	}
+}
+
+// newExporter returns an exporter of a remote BMC running the collectors of
+// module, logging with the target and module as fields.
+func (p *prober) newExporter(backend collector.Backend, target, moduleName string, module *config.Module) *collector.Exporter {
+	e := collector.NewExporter(backend)
+	e.Logger = log.With("target", target).With("module", moduleName)
+	e.Profile = *profileName
+	e.Profiles = p.profiles
+	e.Collectors = module.Collectors
+	if e.Collectors == nil {
+		e.Collectors = splitList(*collectors)
+	}
+	return e
+}
+
+// serveDebug collects the metrics of target with a new exporter recording a
+// trace, which is served as plain text followed by the metrics. The new
+// exporter detects the vendor profile and runs all commands again, regardless
+// of the state of the exporter used by the probes.
+func (p *prober) serveDebug(w http.ResponseWriter, target, moduleName string, module *config.Module, address string) {
+	trace := collector.NewTrace()
+	if secret, err := p.config.CredentialsFor(module, target).Secret(); err == nil {
+		trace.Redact(secret)
+	}
+	trace.Printf("Probing %s at %s with module %s", target, address, moduleName)
+	tool, err := p.ipmitool(target, address, module)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tool.Trace = trace
+	e := p.newExporter(p.wrap(target, tool), target, moduleName, module)
+	e.Trace = trace
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+	mfs, err := registry.Gather()
+	if err != nil {
+		trace.Printf("Gathering the metrics failed: %v", err)
+	}
+	if err := e.LastCollection().Err(); err != nil {
+		trace.Printf("Collection failed: %v", err)
+	} else {
+		trace.Printf("Collection succeeded")
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "Trace:")
+	trace.WriteTo(w)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Metrics:")
+	for _, mf := range mfs {
+		expfmt.MetricFamilyToText(w, mf)
+	}
+}
+
+// namedBackend returns the backend of a named target, used to tail its SEL.
+func (p *prober) namedBackend(target string) (collector.Backend, error) {
+	t, ok := p.config.Target(target)
+	if !ok {
+		return nil, config.ErrUnknownTarget
+	}
+	module, ok := p.config.Module(t.Module)
+	if !ok {
+		return nil, fmt.Errorf("unknown module %q", t.Module)
+	}
+	return p.backend(target, t.Address, module)
+}
+
+// backend returns the backend querying the BMC of target at address with the
+// credentials of module.
+func (p *prober) backend(target, address string, module *config.Module) (collector.Backend, error) {
+	tool, err := p.ipmitool(target, address, module)
+	if err != nil {
+		return nil, err
+	}
+	return p.wrap(target, tool), nil
+}
+
+// ipmitool returns the ipmitool backend querying the BMC of target at
+// address with the credentials of module.
+func (p *prober) ipmitool(target, address string, module *config.Module) (*collector.IPMITool, error) {
+	creds := p.config.CredentialsFor(module, target)
+	password, err := creds.Password()
+	if err != nil {
+		return nil, err
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = config.Host(address), ""
+	}
+	tool := &collector.IPMITool{
+		Path:         *ipmiBinary,
+		Host:         host,
+		Port:         port,
+		Interface:    module.Interface,
+		Privilege:    module.Privilege,
+		User:         creds.User,
+		PasswordFile: creds.PasswordFile,
+		Password:     password,
+		Timeout:      time.Duration(module.Timeout),
+	}
+	// Caching the records of any other address would fill the directory.
+	if _, named := p.config.Target(target); named {
+		tool.SDRCache = sdrCacheFile(target)
+		tool.SDRCacheMaxAge = *sdrCacheAge
+	}
+	return tool, nil
+}
+
+// wrap replaces the backend of target with its recorded outputs or records
+// them if enabled by the flags.
+func (p *prober) wrap(target string, backend collector.Backend) collector.Backend {
+	if *replayDir != "" {
+		backend = &collector.Replay{Dir: filepath.Join(*replayDir, collector.FixtureName([]string{target}))}
+	}
+	if *recordDir != "" {
+		backend = &collector.Recorder{Backend: backend, Dir: filepath.Join(*recordDir, collector.FixtureName([]string{target}))}
+	}
+	return backend
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/lovoo/ipmi_exporter/collector"
+	"github.com/lovoo/ipmi_exporter/config"
+	"github.com/lovoo/ipmi_exporter/rules"
+)
+
+// metricFlags are the flags of the rules and dashboard subcommands describing
+// the metrics an exporter exposes.
+type metricFlags struct {
+	collectors *string
+	profile    *string
+	profiles   *string
+	cfgFile    *string
+	energy     *time.Duration
+	maxTemp    *float64
+	output     *string
+}
+
+func newMetricFlags(fs *flag.FlagSet, maxTempUsage string) *metricFlags {
+	return &metricFlags{
+		collectors: fs.String("collectors", "", "Comma separated list of the enabled optional collectors"),
+		profile:    fs.String("ipmi.profile", "", "Vendor profile of the BMCs, all profiles by default"),
+		profiles:   fs.String("ipmi.profiles", "", "Directory with additional vendor profiles (*.json)"),
+		cfgFile:    fs.String("config.file", "", "Configuration file whose module collectors are enabled"),
+		energy:     fs.Duration("energy.interval", 0, "Interval of the energy counters, 0 if disabled"),
+		maxTemp:    fs.Float64("temperature.max", 80, maxTempUsage),
+		output:     fs.String("output", "", "File to write to instead of standard output"),
+	}
+}
+
+// enabledCollectors returns the collectors of the flag and of the modules of
+// the configuration file.
+func (f *metricFlags) enabledCollectors() ([]string, error) {
+	collectors := splitList(*f.collectors)
+	if *f.cfgFile != "" {
+		cfg, err := config.Load(*f.cfgFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range cfg.Modules {
+			collectors = append(collectors, m.Collectors...)
+		}
+	}
+	return collectors, collector.ValidateCollectors(collectors)
+}
+
+// selectedProfiles returns the vendor profiles selected by the flags.
+func (f *metricFlags) selectedProfiles() ([]*collector.Profile, error) {
+	profiles := collector.DefaultProfiles()
+	if *f.profiles != "" {
+		var err error
+		if profiles, err = collector.LoadProfiles(*f.profiles); err != nil {
+			return nil, err
+		}
+	}
+	if *f.profile == "" {
+		return profiles, nil
+	}
+	var selected []*collector.Profile
+	for _, p := range profiles {
+		if p.Name == *f.profile {
+			selected = append(selected, p)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("unknown vendor profile %q", *f.profile)
+	}
+	return selected, nil
+}
+
+// write calls fn with the output file, or standard output if none is set.
+func (f *metricFlags) write(fn func(io.Writer) error) error {
+	if *f.output == "" {
+		return fn(os.Stdout)
+	}
+	out, err := os.Create(*f.output)
+	if err != nil {
+		return err
+	}
+	if err := fn(out); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// runRules implements the rules subcommand, which writes Prometheus rules for
+// the metrics exposed with the given flags, and returns the exit code.
+func runRules(args []string) int {
+	fs := flag.NewFlagSet("rules", flag.ContinueOnError)
+	job := fs.String("job", "ipmi", "Job of the exporter in Prometheus")
+	flags := newMetricFlags(fs, "Temperature in degrees Celsius above which sensors alert")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	opts := rules.Options{
+		Job:            *job,
+		Energy:         *flags.energy > 0,
+		MaxTemperature: *flags.maxTemp,
+	}
+	var err error
+	if opts.Collectors, err = flags.enabledCollectors(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if opts.Profiles, err = flags.selectedProfiles(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := flags.write(rules.Generate(opts).WriteYAML); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,245 @@
+// Package rules generates Prometheus alerting and recording rules for the
+// metrics exposed by the exporter.
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/lovoo/ipmi_exporter/collector"
+)
+
+// Options select the rules generated for the metrics an exporter exposes.
+type Options struct {
+	// Job is the job of the exporter in Prometheus, used to alert if the
+	// exporter is down.
+	Job string
+	// Collectors are the enabled optional collectors.
+	Collectors []string
+	// Profiles are the vendor profiles of the probed BMCs. Their sensor
+	// mappings select the power supply status sensors.
+	Profiles []*collector.Profile
+	// Energy is set if the energy counters are enabled.
+	Energy bool
+	// MaxTemperature is the temperature in degrees Celsius above which
+	// sensors alert.
+	MaxTemperature float64
+}
+
+// File is a Prometheus rule file.
+type File struct {
+	Groups []*Group
+}
+
+// Group is a group of rules evaluated together.
+type Group struct {
+	Name  string
+	Rules []*Rule
+}
+
+// Rule is a recording rule if Record is set, otherwise an alerting rule.
+type Rule struct {
+	Record      string
+	Alert       string
+	Expr        string
+	For         string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+func alert(name, expr, duration, severity, summary, description string) *Rule {
+	return &Rule{
+		Alert:       name,
+		Expr:        expr,
+		For:         duration,
+		Labels:      map[string]string{"severity": severity},
+		Annotations: map[string]string{"summary": summary, "description": description},
+	}
+}
+
+// Generate returns the rules for the metrics exposed with the options.
+func Generate(o Options) *File {
+	recording := &Group{Name: "ipmi_exporter.rules"}
+	recording.Rules = append(recording.Rules, &Rule{
+		Record: "instance:ipmi_temperatures:max",
+		Expr:   "max by (instance) (ipmi_temperatures)",
+	})
+	if o.Energy {
+		recording.Rules = append(recording.Rules, &Rule{
+			Record: "instance:ipmi_power_energy_joules:rate5m",
+			Expr:   "sum by (instance) (rate(ipmi_power_energy_joules_total[5m]))",
+		})
+	}
+	if hasPSUs(o.Profiles) {
+		recording.Rules = append(recording.Rules, &Rule{
+			Record: "instance:ipmi_psu_input_power_watts:sum",
+			Expr:   "sum by (instance) (ipmi_psu_input_power_watts)",
+		})
+	}
+
+	alerts := &Group{Name: "ipmi_exporter.alerts"}
+	alerts.Rules = append(alerts.Rules,
+		alert("IPMIExporterDown",
+			fmt.Sprintf("up{job=%q} == 0", o.Job), "5m", "warning",
+			"IPMI exporter down",
+			"Scraping the IPMI exporter of {{ $labels.instance }} failed for 5 minutes."),
+		alert("IPMIScrapeFailed",
+			"ipmi_up == 0", "5m", "warning",
+			"IPMI exporter scrape failed",
+			"Reading the sensors of {{ $labels.instance }} failed for 5 minutes."),
+		alert("IPMITemperatureHigh",
+			fmt.Sprintf("ipmi_temperatures > %g", o.MaxTemperature), "5m", "warning",
+			"IPMI temperature high",
+			fmt.Sprintf("Sensor {{ $labels.sensor }} of {{ $labels.instance }} reads {{ $value }} degrees Celsius, above %g.", o.MaxTemperature)),
+	)
+	if re := sensorRegexp(o.Profiles, collector.FamilyPowerSupply); re != "" {
+		alerts.Rules = append(alerts.Rules, alert("IPMIPowerSupplyDown",
+			fmt.Sprintf("ipmi_power_supply_status{PSU=~%q} != 1", re), "5m", "critical",
+			"IPMI power supply down",
+			"Power supply {{ $labels.PSU }} of {{ $labels.instance }} reports status {{ $value }} instead of presence detected."))
+	}
+	if sensorRegexp(o.Profiles, collector.FamilyIntrusion) != "" {
+		alerts.Rules = append(alerts.Rules, alert("IPMIChassisIntrusion",
+			"ipmi_intrusion_status != 0", "", "warning",
+			"IPMI chassis intrusion",
+			"The chassis of {{ $labels.instance }} has been opened."))
+	}
+
+	enabled := map[string]bool{}
+	for _, c := range o.Collectors {
+		enabled[c] = true
+	}
+	if len(enabled) > 0 {
+		alerts.Rules = append(alerts.Rules, alert("IPMICollectorFailed",
+			"ipmi_collector_success == 0", "15m", "warning",
+			"IPMI collector failed",
+			"The {{ $labels.collector }} collector of {{ $labels.instance }} failed for 15 minutes."))
+	}
+	if enabled[collector.CollectorSEL] {
+		alerts.Rules = append(alerts.Rules,
+			// ipmi_sel_entries is a gauge dropping when the SEL is
+			// cleared, which increase() would take for a counter reset.
+			alert("IPMISELGrowing",
+				"ipmi_sel_entries - ipmi_sel_entries offset 1h > 0", "", "info",
+				"IPMI system event log growing",
+				"{{ $value }} entries were added to the system event log of {{ $labels.instance }} within the last hour."),
+			alert("IPMISELAlmostFull",
+				"ipmi_sel_fullness_ratio > 0.9", "1h", "warning",
+				"IPMI system event log almost full",
+				"The system event log of {{ $labels.instance }} has a fullness ratio of {{ $value }}. Some BMCs stop logging when it is full."),
+		)
+	}
+	if enabled[collector.CollectorWatchdog] {
+		alerts.Rules = append(alerts.Rules, alert("IPMIWatchdogNotRunning",
+			"ipmi_watchdog_running == 0", "1h", "warning",
+			"IPMI watchdog not running",
+			"The BMC watchdog timer of {{ $labels.instance }} is not running, hung systems are not recovered."))
+	}
+	if enabled[collector.CollectorLAN] {
+		for _, setting := range []struct{ alert, metric, summary string }{
+			{"IPMICipherSuiteZeroEnabled", "ipmi_lan_cipher_suite_zero_enabled", "cipher suite 0"},
+			{"IPMIAuthTypeNoneEnabled", "ipmi_lan_auth_type_none_enabled", "authentication type NONE"},
+			{"IPMIAnonymousLoginEnabled", "ipmi_lan_anonymous_login_enabled", "anonymous login"},
+			{"IPMINullUsernamesEnabled", "ipmi_lan_null_usernames_enabled", "null user names"},
+		} {
+			alerts.Rules = append(alerts.Rules, alert(setting.alert,
+				setting.metric+" == 1", "", "warning",
+				"IPMI LAN channel insecure",
+				"Channel {{ $labels.channel }} of {{ $labels.instance }} allows "+setting.summary+"."))
+		}
+	}
+	if enabled[collector.CollectorUsers] {
+		alerts.Rules = append(alerts.Rules, alert("IPMIDefaultUserEnabled",
+			"ipmi_bmc_user_default_name_enabled == 1", "", "warning",
+			"IPMI default user enabled",
+			"The BMC of {{ $labels.instance }} has the enabled user {{ $labels.name }} with a vendor default name."))
+	}
+	if enabled[collector.CollectorBoot] {
+		alerts.Rules = append(alerts.Rules, alert("IPMIPersistentBootOverride",
+			`ipmi_boot_options_info{persistent="true",device!="No override"} == 1`, "1h", "info",
+			"IPMI persistent boot override",
+			"{{ $labels.instance }} boots from {{ $labels.device }} persistently."))
+	}
+
+	return &File{Groups: []*Group{recording, alerts}}
+}
+
+func hasPSUs(profiles []*collector.Profile) bool {
+	for _, p := range profiles {
+		if len(p.PSUs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// sensorRegexp returns a Prometheus regular expression matching the sensors
+// the profiles map to family, or an empty string if none do.
+func sensorRegexp(profiles []*collector.Profile, family string) string {
+	seen := map[string]bool{}
+	var patterns []string
+	for _, p := range profiles {
+		for _, m := range p.Sensors {
+			if m.Family != family || seen[m.Pattern] {
+				continue
+			}
+			seen[m.Pattern] = true
+			// Prometheus anchors regular expressions, the profile
+			// patterns are not.
+			patterns = append(patterns, ".*(?:"+m.Pattern+").*")
+		}
+	}
+	return strings.Join(patterns, "|")
+}
+
+// WriteYAML writes the rule file in the YAML format of Prometheus. Strings
+// are written as JSON strings, which are valid YAML.
+func (f *File) WriteYAML(w io.Writer) error {
+	var b bytes.Buffer
+	b.WriteString("groups:\n")
+	for _, g := range f.Groups {
+		fmt.Fprintf(&b, "- name: %s\n  rules:\n", quote(g.Name))
+		for _, r := range g.Rules {
+			if r.Record != "" {
+				fmt.Fprintf(&b, "  - record: %s\n", quote(r.Record))
+			} else {
+				fmt.Fprintf(&b, "  - alert: %s\n", quote(r.Alert))
+			}
+			fmt.Fprintf(&b, "    expr: %s\n", quote(r.Expr))
+			if r.For != "" {
+				fmt.Fprintf(&b, "    for: %s\n", r.For)
+			}
+			writeMap(&b, "labels", r.Labels)
+			writeMap(&b, "annotations", r.Annotations)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeMap(b *bytes.Buffer, name string, m map[string]string) {
+	if len(m) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(b, "    %s:\n", name)
+	for _, k := range keys {
+		fmt.Fprintf(b, "      %s: %s\n", k, quote(m[k]))
+	}
+}
+
+func quote(s string) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
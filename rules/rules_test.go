@@ -0,0 +1,72 @@
+package rules
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/lovoo/ipmi_exporter/collector"
+)
+
+var update = flag.Bool("update", false, "update the golden rule file")
+
+// TestGolden compares the rules of all collectors and profiles to the rule
+// file shipped with the exporter, which is tested with promtool by
+// ipmi_exporter.rules_test.yml. Run the tests with -update to rewrite it.
+func TestGolden(t *testing.T) {
+	var buf bytes.Buffer
+	err := Generate(Options{
+		Job:            "ipmi",
+		Collectors:     collector.CollectorNames(),
+		Profiles:       collector.DefaultProfiles(),
+		Energy:         true,
+		MaxTemperature: 80,
+	}).WriteYAML(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const golden = "ipmi_exporter.rules.yml"
+	if *update {
+		if err := ioutil.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("rules differ from %s:\n--- got:\n%s\n--- want:\n%s", golden, buf.Bytes(), want)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	generic := collector.DefaultProfiles()[0]
+	if generic.Name != collector.GenericProfile {
+		t.Fatalf("want generic profile first, got %s", generic.Name)
+	}
+
+	var buf bytes.Buffer
+	if err := Generate(Options{Job: "bmc", Profiles: []*collector.Profile{generic}, MaxTemperature: 70}).WriteYAML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	rules := buf.String()
+	for _, want := range []string{
+		`expr: "up{job=\"bmc\"} == 0"`,
+		`expr: "ipmi_up == 0"`,
+		`expr: "ipmi_temperatures > 70"`,
+		`expr: "ipmi_power_supply_status{PSU=~\".*(?:PS(.*) Status).*\"} != 1"`,
+	} {
+		if !strings.Contains(rules, want) {
+			t.Errorf("want %s in rules:\n%s", want, rules)
+		}
+	}
+	for _, unwanted := range []string{"ipmi_sel_", "ipmi_watchdog_", "energy", "ipmi_psu_", "ipmi_collector_success"} {
+		if strings.Contains(rules, unwanted) {
+			t.Errorf("want no rules for %s without its collector:\n%s", unwanted, rules)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package sel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rule raises the alert named Alert for events whose sensor and description
+// match Sensor and Description. Nil expressions match all events.
+type Rule struct {
+	Alert       string
+	Sensor      *regexp.Regexp
+	Description *regexp.Regexp
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+func (r *Rule) match(e *Event) bool {
+	return (r.Sensor == nil || r.Sensor.MatchString(e.Sensor)) &&
+		(r.Description == nil || r.Description.MatchString(e.Description))
+}
+
+// Alertmanager is a Sink posting alerts for the events matching its rules to
+// the v2 API of Alertmanager at URL.
+//
+// Alerts are labeled with the alert name of the rule, the target, the sensor,
+// the labels returned by Labels for the target and the labels of the rule.
+// They resolve after ResolveAfter, or when a deasserted event of the same
+// sensor matches the rule.
+type Alertmanager struct {
+	URL          string
+	Client       *http.Client
+	ResolveAfter time.Duration
+	Rules        []*Rule
+	// Labels returns the labels of a target, e.g. from the configuration.
+	Labels func(target string) map[string]string
+
+	now func() time.Time
+}
+
+// alert is an alert of the Alertmanager v2 API.
+type alert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+// Send implements Sink. Events matching no rule are ignored.
+func (a *Alertmanager) Send(e *Event) error {
+	alerts := a.alerts(e)
+	if len(alerts) == 0 {
+		return nil
+	}
+	buf, err := json.Marshal(alerts)
+	if err != nil {
+		return err
+	}
+	url := strings.TrimRight(a.URL, "/") + "/api/v2/alerts"
+	resp, err := a.Client.Post(url, "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("alertmanager %s returned %s", url, resp.Status)
+	}
+	return nil
+}
+
+func (a *Alertmanager) alerts(e *Event) []*alert {
+	now := time.Now()
+	if a.now != nil {
+		now = a.now()
+	}
+	var alerts []*alert
+	for _, r := range a.Rules {
+		if !r.match(e) {
+			continue
+		}
+		labels := map[string]string{}
+		if a.Labels != nil {
+			for k, v := range a.Labels(e.Target) {
+				labels[k] = v
+			}
+		}
+		for k, v := range r.Labels {
+			labels[k] = v
+		}
+		labels["alertname"] = r.Alert
+		labels["target"] = e.Target
+		labels["sensor"] = e.Sensor
+
+		annotations := map[string]string{
+			"description": e.Description,
+			"record_id":   strconv.Itoa(int(e.RecordID)),
+			"record":      e.Raw,
+		}
+		if e.Time != nil {
+			annotations["time"] = e.Time.Format(time.RFC3339)
+		}
+		for k, v := range r.Annotations {
+			annotations[k] = v
+		}
+
+		endsAt := now.Add(a.ResolveAfter)
+		if e.Direction == "Deasserted" {
+			endsAt = now
+		}
+		alerts = append(alerts, &alert{
+			Labels:      labels,
+			Annotations: annotations,
+			StartsAt:    now,
+			EndsAt:      endsAt,
+		})
+	}
+	return alerts
+}
@@ -0,0 +1,87 @@
+package sel
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestAlertmanager(t *testing.T) {
+	var got []*alert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/alerts" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		got = nil
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	now := time.Date(2017, 4, 13, 11, 0, 0, 0, time.UTC)
+	a := &Alertmanager{
+		URL:          server.URL + "/",
+		Client:       http.DefaultClient,
+		ResolveAfter: time.Hour,
+		Rules: []*Rule{
+			{
+				Alert:       "IPMIUncorrectableECC",
+				Sensor:      regexp.MustCompile("^Memory"),
+				Description: regexp.MustCompile("Uncorrectable ECC"),
+				Labels:      map[string]string{"severity": "critical"},
+			},
+			{
+				Alert:  "IPMIPowerSupplyFailure",
+				Sensor: regexp.MustCompile("^Power Supply"),
+			},
+		},
+		Labels: func(target string) map[string]string {
+			return map[string]string{"rack": "r2", "target": "overridden"}
+		},
+		now: func() time.Time { return now },
+	}
+
+	ecc := &Event{Target: "node1", RecordID: 9, Sensor: "Memory #0x53", Description: "Uncorrectable ECC", Direction: "Asserted"}
+	if err := a.Send(ecc); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("want 1 alert, got %d", len(got))
+	}
+	want := map[string]string{
+		"alertname": "IPMIUncorrectableECC",
+		"target":    "node1",
+		"sensor":    "Memory #0x53",
+		"rack":      "r2",
+		"severity":  "critical",
+	}
+	for k, v := range want {
+		if got[0].Labels[k] != v {
+			t.Errorf("want label %s=%q, got %q", k, v, got[0].Labels[k])
+		}
+	}
+	if got[0].Annotations["record_id"] != "9" {
+		t.Errorf("unexpected annotations %v", got[0].Annotations)
+	}
+	if !got[0].StartsAt.Equal(now) || !got[0].EndsAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("want alert resolving after an hour, got %v - %v", got[0].StartsAt, got[0].EndsAt)
+	}
+
+	psu := &Event{Target: "node1", RecordID: 10, Sensor: "Power Supply #0x51", Description: "Power Supply AC lost", Direction: "Deasserted"}
+	if err := a.Send(psu); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Labels["alertname"] != "IPMIPowerSupplyFailure" || !got[0].EndsAt.Equal(now) {
+		t.Errorf("want resolved power supply alert, got %+v", got)
+	}
+
+	// Events matching no rule are not sent.
+	got = nil
+	if err := a.Send(&Event{Target: "node1", Sensor: "Fan #0x30"}); err != nil || got != nil {
+		t.Errorf("want no alert, got %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,415 @@
+// Package sel tails the system event logs of BMCs and forwards new records
+// as events.
+package sel
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lovoo/ipmi_exporter/collector"
+	"github.com/lovoo/ipmi_exporter/logging"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// Event is a record of the system event log of a target.
+type Event struct {
+	Target   string `json:"target"`
+	RecordID uint16 `json:"record_id"`
+	// Time of the record, nil for records without a valid timestamp,
+	// e.g. before the BMC initialized its clock.
+	Time        *time.Time `json:"time,omitempty"`
+	Sensor      string     `json:"sensor"`
+	Description string     `json:"description"`
+	// Direction is "Asserted" or "Deasserted".
+	Direction string `json:"direction,omitempty"`
+	// Raw is the line printed by ipmitool sel elist.
+	Raw string `json:"raw"`
+}
+
+// BackendFunc returns the backend reading the SEL of a target.
+type BackendFunc func(target string) (collector.Backend, error)
+
+// Tailer reads the SEL of its targets every Interval and sends the records
+// added since the last read to its sinks.
+//
+// The last record sent is remembered per target and persisted in StateFile,
+// so records are not sent again after a restart. The SEL of a target seen
+// for the first time is not sent. If the last record sent is no longer in the
+// SEL, e.g. because it was cleared, all records are sent. Records are
+// delivered at least once: if a sink fails, the record is sent to all sinks
+// again with the next read.
+type Tailer struct {
+	Targets  []string
+	Interval time.Duration
+	// StateFile persists the last record sent per target if set.
+	StateFile string
+	Sinks     []Sink
+	// Clear archives and clears full SELs if set.
+	Clear *ClearPolicy
+
+	backend BackendFunc
+	now     func() time.Time
+	// failures limits the errors logged for targets that fail repeatedly.
+	failures *logging.Limiter
+
+	mu     sync.Mutex
+	last   map[string]uint16
+	events map[string]float64
+	errors map[string]float64
+	clears map[string]float64
+
+	eventsDesc *prometheus.Desc
+	errorsDesc *prometheus.Desc
+	clearsDesc *prometheus.Desc
+}
+
+// ClearPolicy archives the SEL of a target as JSON to ArchiveDir and clears
+// it once all its records were sent and its fullness reached Threshold. With
+// DryRun the SEL is neither archived nor cleared, only logged.
+type ClearPolicy struct {
+	Threshold  float64
+	ArchiveDir string
+	DryRun     bool
+}
+
+// archive is the content of an archive file.
+type archive struct {
+	Target   string    `json:"target"`
+	Archived time.Time `json:"archived"`
+	Records  []*Event  `json:"records"`
+}
+
+// tailerState is the content of the state file.
+type tailerState struct {
+	Last map[string]uint16 `json:"last_record_ids"`
+}
+
+// NewTailer returns a tailer reading the SEL through the backends returned by
+// backend. The last records sent are loaded from stateFile.
+func NewTailer(stateFile string, backend BackendFunc) *Tailer {
+	t := &Tailer{
+		Interval:  time.Minute,
+		StateFile: stateFile,
+		backend:   backend,
+		now:       time.Now,
+		failures:  logging.NewLimiter(),
+		last:      map[string]uint16{},
+		events:    map[string]float64{},
+		errors:    map[string]float64{},
+		clears:    map[string]float64{},
+		eventsDesc: prometheus.NewDesc(
+			"ipmi_exporter_sel_events_total",
+			"Number of SEL records forwarded.",
+			[]string{"target"}, nil,
+		),
+		errorsDesc: prometheus.NewDesc(
+			"ipmi_exporter_sel_errors_total",
+			"Number of failed reads or deliveries of SEL records.",
+			[]string{"target"}, nil,
+		),
+		clearsDesc: prometheus.NewDesc(
+			"ipmi_exporter_sel_clears_total",
+			"Number of times the SEL was archived and cleared.",
+			[]string{"target"}, nil,
+		),
+	}
+	if err := t.load(); err != nil {
+		log.With("file", stateFile).Errorf("could not load SEL state: %v", err)
+	}
+	return t
+}
+
+// Run reads the SEL of the targets every interval until stop is closed.
+func (t *Tailer) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(t.Interval)
+	defer ticker.Stop()
+	for {
+		t.Poll()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll reads the SEL of all targets once and sends the new records.
+func (t *Tailer) Poll() {
+	var wg sync.WaitGroup
+	for _, target := range t.Targets {
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			if err := t.poll(target); err != nil {
+				if logger, ok := t.failures.Allow(log.With("target", target), target, err); ok {
+					logger.Errorf("Error tailing SEL: %v", err)
+				}
+				t.mu.Lock()
+				t.errors[target]++
+				t.mu.Unlock()
+				return
+			}
+			t.failures.Reset(target)
+		}(target)
+	}
+	wg.Wait()
+	if err := t.save(); err != nil {
+		log.With("file", t.StateFile).Errorf("could not save SEL state: %v", err)
+	}
+}
+
+func (t *Tailer) poll(target string) error {
+	backend, err := t.backend(target)
+	if err != nil {
+		return err
+	}
+	output, err := backend.Output("sel", "elist")
+	if err != nil {
+		return err
+	}
+	records := Parse(target, output)
+
+	t.mu.Lock()
+	last, seen := t.last[target]
+	t.mu.Unlock()
+	if !seen {
+		if len(records) > 0 {
+			t.setLast(target, records[len(records)-1].RecordID, 0)
+		} else {
+			t.setLast(target, 0, 0)
+		}
+		return nil
+	}
+
+	for _, e := range after(records, last) {
+		for _, s := range t.Sinks {
+			if err := s.Send(e); err != nil {
+				return fmt.Errorf("could not send record %d: %v", e.RecordID, err)
+			}
+		}
+		log.With("target", target).With("record_id", e.RecordID).Infof("SEL record: %s", e.Raw)
+		t.setLast(target, e.RecordID, 1)
+	}
+	if t.Clear != nil && len(records) > 0 {
+		return t.clear(target, backend, records)
+	}
+	return nil
+}
+
+// clear archives and clears the SEL of target if it is full.
+//
+// The SEL is reserved and read again before it is cleared, and it is not
+// cleared if records were added after the records were read, so they are
+// sent with the next poll first. The BMC cancels the reservation if records
+// are deleted or the SEL is cleared by another client in the meantime, and
+// then rejects the clear. Records added between reading the SEL again and
+// clearing it, i.e. while the archive is written, are still lost.
+func (t *Tailer) clear(target string, backend collector.Backend, records []*Event) error {
+	output, err := backend.Output("sel", "info")
+	if err != nil {
+		return err
+	}
+	info, err := collector.ParseSELInfo(output)
+	if err != nil {
+		return err
+	}
+	if info.Fullness < t.Clear.Threshold {
+		return nil
+	}
+	if t.Clear.DryRun {
+		log.With("target", target).Infof("SEL is %.0f%% full, would archive and clear %d records (dry run)", 100*info.Fullness, len(records))
+		return nil
+	}
+
+	reservation, err := reserve(backend)
+	if err != nil {
+		return err
+	}
+	output, err = backend.Output("sel", "elist")
+	if err != nil {
+		return err
+	}
+	if current := Parse(target, output); !sameRecords(records, current) {
+		log.With("target", target).Infof("SEL changed while clearing it, clearing it after the next read")
+		return nil
+	}
+
+	now := t.now()
+	if err := os.MkdirAll(t.Clear.ArchiveDir, 0755); err != nil {
+		return err
+	}
+	buf, err := json.MarshalIndent(&archive{Target: target, Archived: now, Records: records}, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := collector.FixtureName([]string{target}) + "_" + now.UTC().Format("20060102T150405Z") + ".json"
+	file := filepath.Join(t.Clear.ArchiveDir, name)
+	if err := ioutil.WriteFile(file, buf, 0644); err != nil {
+		return err
+	}
+	// Clear SEL with the reservation and the bytes "CLR" to initiate the
+	// erasure.
+	cmd := []string{"raw", "0x0a", "0x47", fmt.Sprintf("0x%02x", reservation&0xff), fmt.Sprintf("0x%02x", reservation>>8), "0x43", "0x4c", "0x52", "0xaa"}
+	if _, err := backend.Output(cmd...); err != nil {
+		return fmt.Errorf("could not clear SEL: %v", err)
+	}
+	log.With("target", target).With("file", file).Infof("SEL was %.0f%% full, archived %d records and cleared it", 100*info.Fullness, len(records))
+	t.mu.Lock()
+	// The BMC may reuse the record IDs after a clear, so no record of the
+	// cleared SEL must be taken for the last record sent. Record ID 0 is
+	// never used.
+	t.last[target] = 0
+	t.clears[target]++
+	t.mu.Unlock()
+	return nil
+}
+
+// reserve reserves the SEL with Reserve SEL and returns the reservation ID.
+func reserve(backend collector.Backend) (uint16, error) {
+	output, err := backend.Output("raw", "0x0a", "0x42")
+	if err != nil {
+		return 0, fmt.Errorf("could not reserve SEL: %v", err)
+	}
+	fields := strings.Fields(string(output))
+	if len(fields) != 2 {
+		return 0, fmt.Errorf("want 2 bytes of SEL reservation, got %q", output)
+	}
+	var id uint16
+	for i, f := range fields {
+		b, err := strconv.ParseUint(f, 16, 8)
+		if err != nil {
+			return 0, fmt.Errorf("could not parse SEL reservation %q: %v", output, err)
+		}
+		id |= uint16(b) << (8 * uint(i))
+	}
+	return id, nil
+}
+
+// sameRecords reports whether a and b contain the same records.
+func sameRecords(a, b []*Event) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].RecordID != b[i].RecordID || a[i].Raw != b[i].Raw {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *Tailer) setLast(target string, id uint16, events float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.last[target] = id
+	t.events[target] += events
+}
+
+// after returns the events following the record with the given ID, or all
+// events if the record is not in the SEL anymore.
+func after(events []*Event, id uint16) []*Event {
+	for i, e := range events {
+		if e.RecordID == id {
+			return events[i+1:]
+		}
+	}
+	return events
+}
+
+// Parse parses the output of ipmitool sel elist.
+func Parse(target string, output []byte) []*Event {
+	var events []*Event
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Split(line, "|")
+		if len(fields) < 5 {
+			continue
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		id, err := strconv.ParseUint(fields[0], 16, 16)
+		if err != nil {
+			continue
+		}
+		e := &Event{
+			Target:      target,
+			RecordID:    uint16(id),
+			Sensor:      fields[3],
+			Description: fields[4],
+			Raw:         strings.TrimSpace(line),
+		}
+		if ts, err := time.ParseInLocation("01/02/2006 15:04:05", fields[1]+" "+fields[2], time.Local); err == nil {
+			e.Time = &ts
+		}
+		if len(fields) > 5 {
+			e.Direction = fields[5]
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func (t *Tailer) load() error {
+	if t.StateFile == "" {
+		return nil
+	}
+	buf, err := ioutil.ReadFile(t.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state tailerState
+	if err := json.Unmarshal(buf, &state); err != nil {
+		return err
+	}
+	for target, id := range state.Last {
+		t.last[target] = id
+	}
+	return nil
+}
+
+func (t *Tailer) save() error {
+	if t.StateFile == "" {
+		return nil
+	}
+	t.mu.Lock()
+	buf, err := json.Marshal(&tailerState{Last: t.last})
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := t.StateFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.StateFile)
+}
+
+// Describe implements prometheus.Collector.
+func (t *Tailer) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.eventsDesc
+	ch <- t.errorsDesc
+	ch <- t.clearsDesc
+}
+
+// Collect implements prometheus.Collector.
+func (t *Tailer) Collect(ch chan<- prometheus.Metric) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, target := range t.Targets {
+		ch <- prometheus.MustNewConstMetric(t.eventsDesc, prometheus.CounterValue, t.events[target], target)
+		ch <- prometheus.MustNewConstMetric(t.errorsDesc, prometheus.CounterValue, t.errors[target], target)
+		ch <- prometheus.MustNewConstMetric(t.clearsDesc, prometheus.CounterValue, t.clears[target], target)
+	}
+}
@@ -0,0 +1,276 @@
+package sel
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lovoo/ipmi_exporter/collector"
+)
+
+const elist = `   1 | Pre-Init  |0000000003| System Event #0x83 | Timestamp Clock Sync | Asserted
+   2 | 04/13/2017 | 10:25:13 | Power Supply #0x51 | Failure detected | Asserted
+   3 | 04/13/2017 | 10:27:40 | Power Supply #0x51 | Failure detected | Deasserted
+`
+
+// fakeSEL is a backend serving a SEL that can be changed by tests.
+type fakeSEL struct {
+	mu      sync.Mutex
+	output  string
+	info    string
+	cleared bool
+	// reservation is the last reservation ID, cancelled when cleared.
+	reservation int
+	// added is added to the SEL after the next reservation.
+	added string
+}
+
+func (f *fakeSEL) Output(args ...string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch strings.Join(args, " ") {
+	case "sel elist":
+		return []byte(f.output), nil
+	case "sel info":
+		return []byte(f.info), nil
+	case "raw 0x0a 0x42":
+		f.reservation++
+		f.output += f.added
+		f.added = ""
+		return []byte(fmt.Sprintf(" %02x 00\n", f.reservation)), nil
+	case fmt.Sprintf("raw 0x0a 0x47 0x%02x 0x00 0x43 0x4c 0x52 0xaa", f.reservation):
+		f.output, f.cleared = "", true
+		f.reservation = 0
+		return []byte(" 01\n"), nil
+	}
+	return nil, errors.New("unexpected command")
+}
+
+func (f *fakeSEL) set(output string) {
+	f.mu.Lock()
+	f.output = output
+	f.mu.Unlock()
+}
+
+// recorder is a sink recording the events sent and failing with err.
+type recorder struct {
+	ids []uint16
+	err error
+}
+
+func (r *recorder) Send(e *Event) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.ids = append(r.ids, e.RecordID)
+	return nil
+}
+
+func TestParse(t *testing.T) {
+	events := Parse("node1", []byte(elist+"SEL has no entries\n"))
+	if len(events) != 3 {
+		t.Fatalf("want 3 events, got %d", len(events))
+	}
+	if events[0].Time != nil {
+		t.Errorf("want no time for pre-init record, got %v", events[0].Time)
+	}
+	e := events[2]
+	want := time.Date(2017, 4, 13, 10, 27, 40, 0, time.Local)
+	if e.Target != "node1" || e.RecordID != 3 || e.Sensor != "Power Supply #0x51" || e.Description != "Failure detected" || e.Direction != "Deasserted" {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if e.Time == nil || !e.Time.Equal(want) {
+		t.Errorf("want time %v, got %v", want, e.Time)
+	}
+}
+
+func TestTailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipmi_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stateFile := filepath.Join(dir, "sel.json")
+
+	sel := &fakeSEL{output: elist}
+	backend := func(target string) (collector.Backend, error) { return sel, nil }
+	sink := &recorder{}
+	newTailer := func() *Tailer {
+		tailer := NewTailer(stateFile, backend)
+		tailer.Targets = []string{"node1"}
+		tailer.Sinks = []Sink{sink}
+		return tailer
+	}
+	tailer := newTailer()
+
+	check := func(want ...uint16) {
+		if len(sink.ids) != len(want) {
+			t.Fatalf("want records %v, got %v", want, sink.ids)
+		}
+		for i := range want {
+			if sink.ids[i] != want[i] {
+				t.Fatalf("want records %v, got %v", want, sink.ids)
+			}
+		}
+	}
+
+	// The existing records are not sent.
+	tailer.Poll()
+	check()
+
+	sel.set(elist + "   4 | 04/13/2017 | 11:00:00 | Fan #0x30 | Lower Critical going low | Asserted\n")
+	tailer.Poll()
+	check(4)
+	tailer.Poll()
+	check(4)
+
+	// Failed deliveries are retried.
+	sel.set(elist + "   4 | 04/13/2017 | 11:00:00 | Fan #0x30 | Lower Critical going low | Asserted\n" +
+		"   5 | 04/13/2017 | 11:01:00 | Fan #0x30 | Lower Critical going low | Deasserted\n")
+	sink.err = errors.New("unavailable")
+	tailer.Poll()
+	sink.err = nil
+	check(4)
+
+	// The last record sent survives restarts.
+	tailer = newTailer()
+	tailer.Poll()
+	check(4, 5)
+
+	// All records are sent after the SEL was cleared.
+	sel.set("   1 | 04/13/2017 | 12:00:00 | Event Logging Disabled #0x07 | Log area reset/cleared | Asserted\n")
+	tailer.Poll()
+	check(4, 5, 1)
+}
+
+func TestClear(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipmi_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sel := &fakeSEL{output: elist, info: "Entries : 3\n# of Alloc Units : 4\n# Free Units : 1\n"}
+	sink := &recorder{}
+	state := filepath.Join(dir, "state.json")
+	tailer := NewTailer(state, func(target string) (collector.Backend, error) { return sel, nil })
+	tailer.Targets = []string{"node1"}
+	tailer.Sinks = []Sink{sink}
+	tailer.Clear = &ClearPolicy{Threshold: 0.8, ArchiveDir: dir, DryRun: true}
+	tailer.now = func() time.Time { return time.Date(2017, 4, 13, 11, 0, 0, 0, time.UTC) }
+
+	tailer.Poll()
+	tailer.Poll()
+	if sel.cleared {
+		t.Fatal("want SEL not to be cleared in dry run")
+	}
+	tailer.Clear.DryRun = false
+	tailer.Clear.Threshold = 0.9
+	tailer.Poll()
+	if sel.cleared {
+		t.Fatal("want SEL below the threshold not to be cleared")
+	}
+	tailer.Clear.Threshold = 0.75
+	// A record logged while clearing is sent before the SEL is cleared.
+	record4 := "   4 | 04/13/2017 | 10:59:59 | Power Supply #0x51 | Failure detected | Asserted\n"
+	sel.added = record4
+	tailer.Poll()
+	if sel.cleared {
+		t.Fatal("want SEL changed while clearing not to be cleared")
+	}
+	tailer.Poll()
+	if !sel.cleared {
+		t.Fatal("want SEL to be cleared")
+	}
+	if ids := sink.ids; len(ids) == 0 || ids[len(ids)-1] != 4 {
+		t.Errorf("want record 4 sent before clearing, got %v", ids)
+	}
+
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "node1_20170413T110000Z.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a archive
+	if err := json.Unmarshal(buf, &a); err != nil {
+		t.Fatal(err)
+	}
+	if a.Target != "node1" || len(a.Records) != 4 || a.Records[3].RecordID != 4 {
+		t.Errorf("unexpected archive %+v", a)
+	}
+
+	// The record IDs of the cleared SEL are reused, also after a restart.
+	tailer = NewTailer(state, tailer.backend)
+	tailer.Targets = []string{"node1"}
+	tailer.Sinks = []Sink{sink}
+	sink.ids = nil
+	sel.set(elist + record4)
+	tailer.Poll()
+	if len(sink.ids) != 4 {
+		t.Errorf("want the 4 records after the clear sent, got %v", sink.ids)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var got Event
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	h := &Webhook{URL: server.URL, Client: http.DefaultClient}
+	if err := h.Send(&Event{Target: "node1", RecordID: 7}); err != nil {
+		t.Fatal(err)
+	}
+	if got.Target != "node1" || got.RecordID != 7 {
+		t.Errorf("unexpected event %+v", got)
+	}
+	status = http.StatusServiceUnavailable
+	if err := h.Send(&Event{Target: "node1", RecordID: 8}); err == nil {
+		t.Error("want error for failed request")
+	}
+}
+
+func TestBroker(t *testing.T) {
+	b := NewBroker()
+	server := httptest.NewServer(b)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?target=node2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("want event stream, got %s", ct)
+	}
+
+	b.Send(&Event{Target: "node1", RecordID: 1})
+	b.Send(&Event{Target: "node2", RecordID: 2})
+
+	r := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 3 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	if lines[0] != "id: node2/2" || lines[1] != "event: sel" || !strings.HasPrefix(lines[2], `data: {"target":"node2","record_id":2`) {
+		t.Errorf("unexpected event %q", lines)
+	}
+}
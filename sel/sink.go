@@ -0,0 +1,140 @@
+package sel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/common/log"
+)
+
+// Sink receives the new records of the tailed SELs.
+type Sink interface {
+	Send(e *Event) error
+}
+
+// Writer is a Sink writing events as JSON lines, e.g. to standard output.
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriter returns a Sink writing events to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Send implements Sink.
+func (w *Writer) Send(e *Event) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return json.NewEncoder(w.w).Encode(e)
+}
+
+// Webhook is a Sink posting each event as JSON to URL.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+// Send implements Sink. Responses other than 2xx are errors.
+func (h *Webhook) Send(e *Event) error {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	resp, err := h.Client.Post(h.URL, "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook %s returned %s", h.URL, resp.Status)
+	}
+	return nil
+}
+
+// brokerBuffer is the number of events buffered per client of a Broker.
+const brokerBuffer = 64
+
+// Broker is a Sink streaming events to HTTP clients as server-sent events.
+// Clients may restrict the stream to a single target with the target
+// parameter. Events are dropped for clients not reading fast enough.
+type Broker struct {
+	mu      sync.Mutex
+	clients map[chan *Event]string
+}
+
+// NewBroker returns a Broker without clients.
+func NewBroker() *Broker {
+	return &Broker{clients: map[chan *Event]string{}}
+}
+
+// Send implements Sink. It never fails.
+func (b *Broker) Send(e *Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c, target := range b.clients {
+		if target != "" && target != e.Target {
+			continue
+		}
+		select {
+		case c <- e:
+		default:
+			log.With("target", e.Target).With("record_id", e.RecordID).Warn("Dropping SEL record for slow event stream client")
+		}
+	}
+	return nil
+}
+
+func (b *Broker) subscribe(target string) chan *Event {
+	c := make(chan *Event, brokerBuffer)
+	b.mu.Lock()
+	b.clients[c] = target
+	b.mu.Unlock()
+	return c
+}
+
+func (b *Broker) unsubscribe(c chan *Event) {
+	b.mu.Lock()
+	delete(b.clients, c)
+	b.mu.Unlock()
+}
+
+// ServeHTTP streams the events until the client disconnects.
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	c := b.subscribe(r.URL.Query().Get("target"))
+	defer b.unsubscribe(c)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-c:
+			buf, err := json.Marshal(e)
+			if err != nil {
+				log.Errorf("Error encoding SEL record: %v", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %s/%d\nevent: sel\ndata: %s\n\n", e.Target, e.RecordID, buf); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package simulator
+
+import (
+	"bytes"
+	"net"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lovoo/ipmi_exporter/collector"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/log"
+)
+
+// The tests in this file run the exporter with the ipmitool backend against
+// the simulator, covering ipmitool's RMCP+ implementation and the parsers of
+// its output. They are skipped if ipmitool is not installed.
+
+// exporter returns an exporter querying the simulator at addr with ipmitool.
+func exporter(t *testing.T, addr, user, password string) *collector.Exporter {
+	path, err := exec.LookPath("ipmitool")
+	if err != nil {
+		t.Skip("ipmitool not installed")
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := collector.NewExporter(&collector.IPMITool{
+		Path:      path,
+		Host:      host,
+		Port:      port,
+		Interface: "lanplus",
+		User:      user,
+		Password:  password,
+		Timeout:   5 * time.Second,
+	})
+	e.Logger = log.NewNopLogger()
+	return e
+}
+
+// gather collects the metrics of e in the text exposition format.
+func gather(t *testing.T, e *collector.Exporter) string {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(e)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	for _, mf := range mfs {
+		expfmt.MetricFamilyToText(&buf, mf)
+	}
+	return buf.String()
+}
+
+func TestIPMITool(t *testing.T) {
+	addr, stop := start(t)
+	defer stop()
+
+	e := exporter(t, addr, "ADMIN", "ADMIN")
+	metrics := gather(t, e)
+	if err := e.LastCollection().Err(); err != nil {
+		t.Fatalf("collection failed: %v", err)
+	}
+	for _, want := range []string{
+		`ipmi_temperatures{sensor="CPU1 Temp"} 33`,
+		`ipmi_fan_speed{fan="FAN2"} 3000`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("want %q in metrics:\n%s", want, metrics)
+		}
+	}
+
+	bmc, err := e.BMC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bmc.ManufacturerID != 10876 || bmc.Profile != "supermicro" {
+		t.Errorf("want supermicro BMC, got %+v", bmc)
+	}
+
+	sensors, err := e.Sensors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range sensors {
+		if s.Name == "CPU1 Temp" {
+			if s.Value == nil || *s.Value != 33 || s.Thresholds.UpperCritical == nil || *s.Thresholds.UpperCritical != 82 {
+				t.Errorf("unexpected sensor %+v", s)
+			}
+			return
+		}
+	}
+	t.Errorf("want sensor CPU1 Temp, got %+v", sensors)
+}
+
+func TestIPMIToolFailures(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		faults   []Fault
+		user     string
+		password string
+		// want is part of the error of the sensor readings if not empty.
+		want string
+	}{
+		{
+			name: "wrong password", user: "ADMIN", password: "wrong",
+			want: "Unable to establish IPMI v2 / RMCP+ session",
+		},
+		{
+			name: "unknown user", user: "nobody", password: "ADMIN",
+			want: "Unable to establish IPMI v2 / RMCP+ session",
+		},
+		{
+			name: "malformed session setup", user: "ADMIN", password: "ADMIN",
+			faults: []Fault{{Command: "rakp1", Action: "malformed"}},
+		},
+		{
+			name: "unanswered sensor readings", user: "ADMIN", password: "ADMIN",
+			faults: []Fault{{Command: "0x04 0x2d", Action: "drop"}},
+			want:   "timed out after 5s",
+		},
+	} {
+		addr, stop := start(t, tc.faults...)
+		e := exporter(t, addr, tc.user, tc.password)
+		metrics := gather(t, e)
+		stop()
+
+		err := e.LastCollection().Parts[collector.SensorPart]
+		if err == nil {
+			t.Errorf("%s: want sensor readings failed, got metrics:\n%s", tc.name, metrics)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: want error %q, got %v", tc.name, tc.want, err)
+		}
+		if strings.Contains(metrics, "ipmi_temperatures") {
+			t.Errorf("%s: want no sensor metrics, got:\n%s", tc.name, metrics)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package simulator
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lovoo/ipmi_exporter/ipmi"
+)
+
+// Scenario describes the BMC simulated by a Simulator.
+type Scenario struct {
+	Device  Device   `json:"device"`
+	Channel Channel  `json:"channel"`
+	Users   []User   `json:"users"`
+	Sensors []Sensor `json:"sensors"`
+	// Responses are fixed answers to commands, keyed by network function
+	// and command, e.g. "0x06 0x25". The value is the hex encoded response
+	// data following the completion code.
+	Responses map[string]string `json:"responses"`
+	Faults    []Fault           `json:"faults"`
+}
+
+// Device is the identity of the BMC reported by Get Device ID.
+type Device struct {
+	DeviceID       byte   `json:"device_id"`
+	Revision       byte   `json:"revision"`
+	Firmware       string `json:"firmware"`
+	ManufacturerID uint32 `json:"manufacturer_id"`
+	ProductID      uint16 `json:"product_id"`
+	GUID           string `json:"guid"`
+}
+
+// Channel configures the LAN channel of the BMC.
+type Channel struct {
+	Number         byte  `json:"number"`
+	CipherSuites   []int `json:"cipher_suites"`
+	AnonymousLogin bool  `json:"anonymous_login"`
+	NullUsernames  bool  `json:"null_usernames"`
+}
+
+// User is a BMC user account.
+type User struct {
+	Name      string `json:"name"`
+	Password  string `json:"password"`
+	Privilege string `json:"privilege"`
+}
+
+// Sensor is a sensor of the SDR repository. Sensors without a unit are
+// discrete sensors reporting State. The reading of threshold sensors is
+// converted with the linear formula (M * x + B * 10^BExp) * 10^RExp.
+type Sensor struct {
+	Number      byte               `json:"number"`
+	Name        string             `json:"name"`
+	Type        string             `json:"type"`
+	Unit        string             `json:"unit"`
+	Value       float64            `json:"value"`
+	M           int                `json:"m"`
+	B           int                `json:"b"`
+	BExp        int                `json:"b_exp"`
+	RExp        int                `json:"r_exp"`
+	Thresholds  map[string]float64 `json:"thresholds"`
+	State       uint16             `json:"state"`
+	Unavailable bool               `json:"unavailable"`
+}
+
+// Fault makes the simulator misbehave on a command. Command is either the
+// network function and command of an IPMI request, e.g. "0x06 0x01", or one
+// of the session setup stages "ping", "open_session", "rakp1" and "rakp3".
+type Fault struct {
+	Command string `json:"command"`
+	// Action is one of "drop" (no answer), "delay" (answer after Delay),
+	// "malformed" (answer with a corrupted message) or "completion_code"
+	// (answer with CompletionCode).
+	Action         string `json:"action"`
+	Delay          string `json:"delay"`
+	CompletionCode byte   `json:"completion_code"`
+
+	delay time.Duration
+}
+
+var privileges = map[string]byte{
+	"callback":      ipmi.PrivilegeCallback,
+	"user":          ipmi.PrivilegeUser,
+	"operator":      ipmi.PrivilegeOperator,
+	"administrator": ipmi.PrivilegeAdministrator,
+	"oem":           ipmi.PrivilegeOEM,
+}
+
+var sensorTypes = map[string]byte{
+	"temperature":       0x01,
+	"voltage":           0x02,
+	"current":           0x03,
+	"fan":               0x04,
+	"physical_security": 0x05,
+	"processor":         0x07,
+	"power_supply":      0x08,
+	"memory":            0x0c,
+	"drive_slot":        0x0d,
+	"watchdog":          0x23,
+}
+
+var sensorUnits = map[string]byte{
+	"degrees c": 1,
+	"volts":     4,
+	"amps":      5,
+	"watts":     6,
+	"rpm":       18,
+}
+
+// thresholdNames in the order of the readable threshold mask.
+var thresholdNames = []string{"lnc", "lcr", "lnr", "unc", "ucr", "unr"}
+
+// LoadScenario reads a scenario from a JSON file.
+func LoadScenario(file string) (*Scenario, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var s Scenario
+	if err := json.Unmarshal(buf, &s); err != nil {
+		return nil, fmt.Errorf("could not parse scenario %s: %v", file, err)
+	}
+	return &s, nil
+}
+
+func (s *Scenario) validate() error {
+	if s.Channel.Number == 0 {
+		s.Channel.Number = 1
+	}
+	if len(s.Channel.CipherSuites) == 0 {
+		s.Channel.CipherSuites = []int{3, 17}
+	}
+	for _, id := range s.Channel.CipherSuites {
+		if _, ok := ipmi.LookupCipherSuite(byte(id)); !ok {
+			return fmt.Errorf("unsupported cipher suite %d", id)
+		}
+	}
+	if s.Device.GUID != "" {
+		if b, err := hex.DecodeString(s.Device.GUID); err != nil || len(b) != 16 {
+			return fmt.Errorf("invalid GUID %q", s.Device.GUID)
+		}
+	}
+	for _, u := range s.Users {
+		if _, ok := privileges[strings.ToLower(u.Privilege)]; !ok {
+			return fmt.Errorf("user %q: unknown privilege %q", u.Name, u.Privilege)
+		}
+	}
+	numbers := map[byte]bool{}
+	for i := range s.Sensors {
+		sensor := &s.Sensors[i]
+		if numbers[sensor.Number] {
+			return fmt.Errorf("duplicate sensor number %d", sensor.Number)
+		}
+		numbers[sensor.Number] = true
+		if err := sensor.validate(); err != nil {
+			return fmt.Errorf("sensor %q: %v", sensor.Name, err)
+		}
+	}
+	responses := make(map[string]string, len(s.Responses))
+	for key, data := range s.Responses {
+		netFn, cmd, err := parseCommand(key)
+		if err != nil {
+			return err
+		}
+		if _, err := parseHex(data); err != nil {
+			return fmt.Errorf("response %q: %v", key, err)
+		}
+		responses[commandKey(netFn, cmd)] = data
+	}
+	s.Responses = responses
+	for i := range s.Faults {
+		f := &s.Faults[i]
+		switch f.Command {
+		case "ping", "open_session", "rakp1", "rakp3":
+		default:
+			netFn, cmd, err := parseCommand(f.Command)
+			if err != nil {
+				return err
+			}
+			f.Command = commandKey(netFn, cmd)
+		}
+		switch f.Action {
+		case "drop", "malformed", "completion_code":
+		case "delay":
+			d, err := time.ParseDuration(f.Delay)
+			if err != nil {
+				return fmt.Errorf("fault %q: %v", f.Command, err)
+			}
+			f.delay = d
+		default:
+			return fmt.Errorf("fault %q: unknown action %q", f.Command, f.Action)
+		}
+	}
+	return nil
+}
+
+func (s *Sensor) validate() error {
+	if len(s.Name) > 16 {
+		return fmt.Errorf("name longer than 16 characters")
+	}
+	if _, ok := sensorTypes[s.Type]; !ok {
+		return fmt.Errorf("unknown sensor type %q", s.Type)
+	}
+	if s.discrete() {
+		return nil
+	}
+	if _, ok := sensorUnits[strings.ToLower(s.Unit)]; !ok {
+		return fmt.Errorf("unknown unit %q", s.Unit)
+	}
+	if _, err := s.raw(s.Value); err != nil {
+		return err
+	}
+	for name, v := range s.Thresholds {
+		if thresholdBit(name) < 0 {
+			return fmt.Errorf("unknown threshold %q", name)
+		}
+		if _, err := s.raw(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Sensor) discrete() bool {
+	return s.Unit == ""
+}
+
+func (s *Sensor) m() int {
+	if s.M == 0 {
+		return 1
+	}
+	return s.M
+}
+
+// raw converts a value to the raw reading of the sensor.
+func (s *Sensor) raw(v float64) (byte, error) {
+	x := (v/math.Pow10(s.RExp) - float64(s.B)*math.Pow10(s.BExp)) / float64(s.m())
+	x = math.Floor(x + 0.5)
+	if x < 0 || x > 255 {
+		return 0, fmt.Errorf("value %v out of the range of the linearization", v)
+	}
+	return byte(x), nil
+}
+
+// record encodes the full sensor record of s with the given record ID.
+func (s *Sensor) record(id uint16) []byte {
+	r := make([]byte, 48, 48+len(s.Name))
+	r[0], r[1] = byte(id), byte(id>>8)
+	r[2] = 0x51
+	r[3] = 0x01
+	r[4] = byte(len(r) - 5 + len(s.Name))
+	r[5] = ipmi.BMCAddress
+	r[7] = s.Number
+	r[8] = 0x07 // system board
+	r[9] = 0x01
+	r[10] = 0x7f
+	r[12] = sensorTypes[s.Type]
+	r[47] = 0xc0 | byte(len(s.Name))
+
+	if s.discrete() {
+		r[11] = 0x40
+		r[13] = 0x6f
+		return append(r, s.Name...)
+	}
+
+	r[11] = 0x44 // auto re-arm, readable thresholds
+	r[13] = 0x01
+	for name := range s.Thresholds {
+		r[18] |= 1 << uint(thresholdBit(name))
+	}
+	r[21] = sensorUnits[strings.ToLower(s.Unit)]
+	m, b := s.m()&0x3ff, s.B&0x3ff
+	r[24] = byte(m)
+	r[25] = byte(m>>8) << 6
+	r[26] = byte(b)
+	r[27] = byte(b>>8) << 6
+	r[29] = byte(s.RExp&0x0f)<<4 | byte(s.BExp&0x0f)
+	r[34] = 0xff
+
+	for i, name := range []string{"unr", "ucr", "unc", "lnr", "lcr", "lnc"} {
+		if v, ok := s.Thresholds[name]; ok {
+			r[36+i], _ = s.raw(v)
+		}
+	}
+	return append(r, s.Name...)
+}
+
+// reading returns the response data of Get Sensor Reading.
+func (s *Sensor) reading() []byte {
+	flags := byte(0xc0)
+	if s.Unavailable {
+		flags |= 0x20
+	}
+	if s.discrete() {
+		return []byte{byte(s.Value), flags, byte(s.State), byte(s.State>>8) | 0x80}
+	}
+
+	x, _ := s.raw(s.Value)
+	var status byte
+	for name, v := range s.Thresholds {
+		t, _ := s.raw(v)
+		bit := thresholdBit(name)
+		if (bit < 3 && x <= t) || (bit >= 3 && x >= t) {
+			status |= 1 << uint(bit)
+		}
+	}
+	return []byte{x, flags, status | 0xc0}
+}
+
+// thresholds returns the response data of Get Sensor Thresholds.
+func (s *Sensor) thresholds() []byte {
+	b := make([]byte, 7)
+	for name, v := range s.Thresholds {
+		bit := thresholdBit(name)
+		b[0] |= 1 << uint(bit)
+		b[1+bit], _ = s.raw(v)
+	}
+	return b
+}
+
+func thresholdBit(name string) int {
+	for i, n := range thresholdNames {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseCommand parses a command key like "0x06 0x01".
+func parseCommand(key string) (netFn, cmd byte, err error) {
+	parts := strings.Fields(key)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid command %q", key)
+	}
+	n, err := strconv.ParseUint(parts[0], 0, 8)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid command %q", key)
+	}
+	c, err := strconv.ParseUint(parts[1], 0, 8)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid command %q", key)
+	}
+	return byte(n), byte(c), nil
+}
+
+func commandKey(netFn, cmd byte) string {
+	return fmt.Sprintf("0x%02x 0x%02x", netFn, cmd)
+}
+
+// parseHex parses hex bytes separated by white space, as printed by
+// `ipmitool raw`.
+func parseHex(s string) ([]byte, error) {
+	return hex.DecodeString(strings.Join(strings.Fields(s), ""))
+}
@@ -0,0 +1,578 @@
+// Package simulator implements a BMC answering IPMI over LAN requests from a
+// scenario, for testing the exporter without hardware. It supports RMCP
+// presence pings, session-less requests and RMCP+ sessions.
+package simulator
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lovoo/ipmi_exporter/ipmi"
+)
+
+// Simulator is a simulated BMC.
+type Simulator struct {
+	scenario *Scenario
+	guid     [16]byte
+	sdr      [][]byte
+
+	mu          sync.Mutex
+	sessions    map[uint32]*session
+	reservation uint16
+}
+
+type session struct {
+	handshake *ipmi.Handshake
+	keys      *ipmi.SessionKeys
+	user      *User
+	privilege byte
+	active    bool
+	seq       uint32
+}
+
+// New returns a simulator for the scenario.
+func New(scenario *Scenario) (*Simulator, error) {
+	if err := scenario.validate(); err != nil {
+		return nil, err
+	}
+	s := &Simulator{
+		scenario: scenario,
+		sessions: make(map[uint32]*session),
+	}
+	if scenario.Device.GUID != "" {
+		b, _ := hex.DecodeString(scenario.Device.GUID)
+		copy(s.guid[:], b)
+	}
+	for i := range scenario.Sensors {
+		s.sdr = append(s.sdr, scenario.Sensors[i].record(uint16(i+1)))
+	}
+	return s, nil
+}
+
+// ListenAndServe listens on the UDP address addr and serves requests.
+func (s *Simulator) ListenAndServe(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(conn)
+}
+
+// Serve answers the requests received on conn until it is closed.
+func (s *Simulator) Serve(conn net.PacketConn) error {
+	buf := make([]byte, 1024)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				return nil
+			}
+			return err
+		}
+		packet := append([]byte(nil), buf[:n]...)
+		go func() {
+			if resp := s.handle(packet); resp != nil {
+				conn.WriteTo(resp, addr)
+			}
+		}()
+	}
+}
+
+// handle returns the answer to an RMCP packet or nil if it is dropped.
+func (s *Simulator) handle(b []byte) []byte {
+	class, body, err := ipmi.UnmarshalRMCP(b)
+	if err != nil {
+		return nil
+	}
+
+	switch class {
+	case ipmi.ClassASF:
+		return s.handleASF(body)
+	case ipmi.ClassIPMI:
+		p, err := ipmi.UnmarshalSession(body, s.sessionKeys)
+		if err != nil {
+			return nil
+		}
+		return s.handleSession(p)
+	}
+	return nil
+}
+
+func (s *Simulator) handleASF(b []byte) []byte {
+	m, err := ipmi.UnmarshalASF(b)
+	if err != nil || m.Type != ipmi.ASFPing {
+		return nil
+	}
+	fault := s.fault("ping")
+	if !applyFault(fault) {
+		return nil
+	}
+
+	pong := &ipmi.Pong{IANA: 4542, SupportedEntities: 0x81}
+	resp := &ipmi.ASFMessage{Type: ipmi.ASFPong, Tag: m.Tag, Data: pong.Marshal()}
+	if fault != nil && fault.Action == "malformed" {
+		return resp.Marshal()[:10]
+	}
+	return resp.Marshal()
+}
+
+func (s *Simulator) handleSession(p *ipmi.SessionPacket) []byte {
+	switch p.PayloadType {
+	case ipmi.PayloadIPMI:
+		return s.handleMessage(p)
+	case ipmi.PayloadOpenSessionRequest:
+		return s.handleSetup(p, "open_session", ipmi.PayloadOpenSessionResponse, s.openSession)
+	case ipmi.PayloadRAKP1:
+		return s.handleSetup(p, "rakp1", ipmi.PayloadRAKP2, s.rakp1)
+	case ipmi.PayloadRAKP3:
+		return s.handleSetup(p, "rakp3", ipmi.PayloadRAKP4, s.rakp3)
+	}
+	return nil
+}
+
+func (s *Simulator) handleSetup(p *ipmi.SessionPacket, stage string, typ byte, handler func([]byte) []byte) []byte {
+	fault := s.fault(stage)
+	if !applyFault(fault) {
+		return nil
+	}
+	payload := handler(p.Payload)
+	if payload == nil {
+		return nil
+	}
+	if fault != nil && fault.Action == "malformed" {
+		payload = payload[:4]
+	}
+	resp := &ipmi.SessionPacket{AuthType: ipmi.AuthTypeRMCPPlus, PayloadType: typ, Payload: payload}
+	b, _ := resp.Marshal(nil)
+	return b
+}
+
+func (s *Simulator) openSession(b []byte) []byte {
+	req, err := ipmi.UnmarshalOpenSessionRequest(b)
+	if err != nil {
+		return nil
+	}
+	resp := &ipmi.OpenSessionResponse{Tag: req.Tag, ConsoleSessionID: req.ConsoleSessionID}
+	if !s.cipherSuiteEnabled(req.Suite.ID) {
+		resp.Status = ipmi.StatusNoCipherSuiteMatch
+		return resp.Marshal()
+	}
+
+	resp.MaxPrivilege = req.MaxPrivilege
+	if resp.MaxPrivilege == 0 {
+		resp.MaxPrivilege = ipmi.PrivilegeAdministrator
+	}
+	resp.Suite = req.Suite
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var id uint32
+	for id == 0 || s.sessions[id] != nil {
+		id = randomUint32()
+	}
+	resp.BMCSessionID = id
+	s.sessions[id] = &session{handshake: &ipmi.Handshake{
+		Suite:            req.Suite,
+		ConsoleSessionID: req.ConsoleSessionID,
+		BMCSessionID:     id,
+		BMCGUID:          s.guid,
+	}}
+	return resp.Marshal()
+}
+
+func (s *Simulator) rakp1(b []byte) []byte {
+	m, err := ipmi.UnmarshalRAKP1(b)
+	if err != nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess := s.sessions[m.BMCSessionID]
+	if sess == nil {
+		return (&ipmi.RAKP2{Tag: m.Tag, Status: ipmi.StatusInvalidSessionID}).Marshal()
+	}
+	h := sess.handshake
+	resp := &ipmi.RAKP2{Tag: m.Tag, ConsoleSessionID: h.ConsoleSessionID}
+
+	user := s.findUser(m.Username)
+	if user == nil {
+		delete(s.sessions, m.BMCSessionID)
+		resp.Status = ipmi.StatusUnauthorizedName
+		return resp.Marshal()
+	}
+	if m.Role&0x0f > privileges[strings.ToLower(user.Privilege)] {
+		delete(s.sessions, m.BMCSessionID)
+		resp.Status = ipmi.StatusUnauthorizedRole
+		return resp.Marshal()
+	}
+
+	h.ConsoleRandom = m.ConsoleRandom
+	rand.Read(h.BMCRandom[:])
+	h.Role = m.Role
+	h.Username = m.Username
+	h.Password = user.Password
+	sess.user = user
+	sess.privilege = m.Role & 0x0f
+
+	resp.BMCRandom = h.BMCRandom
+	resp.BMCGUID = h.BMCGUID
+	resp.AuthCode = h.RAKP2AuthCode()
+	return resp.Marshal()
+}
+
+func (s *Simulator) rakp3(b []byte) []byte {
+	m, err := ipmi.UnmarshalRAKP3(b)
+	if err != nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess := s.sessions[m.BMCSessionID]
+	if sess == nil || sess.user == nil {
+		return (&ipmi.RAKP4{Tag: m.Tag, Status: ipmi.StatusInvalidSessionID}).Marshal()
+	}
+	h := sess.handshake
+	resp := &ipmi.RAKP4{Tag: m.Tag, ConsoleSessionID: h.ConsoleSessionID}
+	if m.Status != ipmi.StatusOK || !hmac.Equal(m.AuthCode, h.RAKP3AuthCode()) {
+		delete(s.sessions, m.BMCSessionID)
+		resp.Status = ipmi.StatusInvalidIntegrityCheck
+		return resp.Marshal()
+	}
+
+	sess.keys = h.Keys()
+	sess.active = true
+	resp.ICV = h.RAKP4ICV()
+	return resp.Marshal()
+}
+
+func (s *Simulator) findUser(name string) *User {
+	if name == "" && !s.scenario.Channel.NullUsernames {
+		return nil
+	}
+	for i := range s.scenario.Users {
+		if s.scenario.Users[i].Name == name {
+			return &s.scenario.Users[i]
+		}
+	}
+	return nil
+}
+
+func (s *Simulator) cipherSuiteEnabled(id byte) bool {
+	for _, c := range s.scenario.Channel.CipherSuites {
+		if byte(c) == id {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionKeys returns the keys of an active session.
+func (s *Simulator) sessionKeys(id uint32) *ipmi.SessionKeys {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sess := s.sessions[id]; sess != nil && sess.active {
+		return sess.keys
+	}
+	return nil
+}
+
+// handleMessage answers an IPMI request received outside of or within a
+// session.
+func (s *Simulator) handleMessage(p *ipmi.SessionPacket) []byte {
+	req, err := ipmi.UnmarshalMessage(p.Payload)
+	if err != nil || req.IsResponse() {
+		return nil
+	}
+
+	var sess *session
+	if p.SessionID != 0 {
+		s.mu.Lock()
+		sess = s.sessions[p.SessionID]
+		s.mu.Unlock()
+		if sess == nil || !sess.active {
+			return nil
+		}
+	} else if !sessionless(req) {
+		return nil
+	}
+
+	fault := s.fault(commandKey(req.NetFn, req.Cmd))
+	if !applyFault(fault) {
+		return nil
+	}
+
+	var resp *ipmi.Message
+	if fault != nil && fault.Action == "completion_code" {
+		resp = req.Response(fault.CompletionCode, nil)
+	} else {
+		code, data := s.command(sess, p.SessionID, req)
+		resp = req.Response(code, data)
+	}
+	payload := resp.Marshal()
+	if fault != nil && fault.Action == "malformed" {
+		payload[len(payload)-1]++
+	}
+
+	out := &ipmi.SessionPacket{AuthType: p.AuthType, PayloadType: ipmi.PayloadIPMI, Payload: payload}
+	var keys *ipmi.SessionKeys
+	if sess != nil {
+		s.mu.Lock()
+		sess.seq++
+		out.Sequence = sess.seq
+		s.mu.Unlock()
+		out.SessionID = sess.handshake.ConsoleSessionID
+		out.Encrypted = sess.keys.Encrypted()
+		out.Authenticated = sess.keys.Authenticated()
+		keys = sess.keys
+	}
+	b, err := out.Marshal(keys)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// sessionless reports whether req may be sent outside of a session.
+func sessionless(req *ipmi.Message) bool {
+	return req.NetFn == ipmi.NetFnApp &&
+		(req.Cmd == ipmi.CmdGetChannelAuthCapabilities || req.Cmd == ipmi.CmdGetChannelCipherSuites)
+}
+
+// command executes req and returns the completion code and response data.
+func (s *Simulator) command(sess *session, id uint32, req *ipmi.Message) (byte, []byte) {
+	if data, ok := s.scenario.Responses[commandKey(req.NetFn, req.Cmd)]; ok {
+		b, _ := parseHex(data)
+		return ipmi.CompletionOK, b
+	}
+
+	switch req.NetFn {
+	case ipmi.NetFnApp:
+		switch req.Cmd {
+		case ipmi.CmdGetDeviceID:
+			return s.deviceID()
+		case ipmi.CmdGetChannelAuthCapabilities:
+			return s.authCapabilities(req.Data)
+		case ipmi.CmdGetChannelCipherSuites:
+			return s.cipherSuites(req.Data)
+		case ipmi.CmdSetSessionPrivilegeLevel:
+			return s.setPrivilege(sess, req.Data)
+		case ipmi.CmdCloseSession:
+			s.mu.Lock()
+			delete(s.sessions, id)
+			s.mu.Unlock()
+			return ipmi.CompletionOK, nil
+		}
+	case ipmi.NetFnStorage:
+		switch req.Cmd {
+		case ipmi.CmdGetSDRRepositoryInfo:
+			return s.sdrInfo()
+		case ipmi.CmdReserveSDRRepository:
+			s.mu.Lock()
+			s.reservation++
+			r := s.reservation
+			s.mu.Unlock()
+			return ipmi.CompletionOK, []byte{byte(r), byte(r >> 8)}
+		case ipmi.CmdGetSDR:
+			return s.getSDR(req.Data)
+		}
+	case ipmi.NetFnSensor:
+		if len(req.Data) < 1 {
+			return 0xc7, nil
+		}
+		sensor := s.sensor(req.Data[0])
+		if sensor == nil {
+			return ipmi.CompletionNotPresent, nil
+		}
+		switch req.Cmd {
+		case ipmi.CmdGetSensorReading:
+			return ipmi.CompletionOK, sensor.reading()
+		case ipmi.CmdGetSensorThresholds:
+			if sensor.discrete() {
+				return ipmi.CompletionInvalidCommand, nil
+			}
+			return ipmi.CompletionOK, sensor.thresholds()
+		}
+	}
+	return ipmi.CompletionInvalidCommand, nil
+}
+
+func (s *Simulator) deviceID() (byte, []byte) {
+	d := s.scenario.Device
+	major, minor := parseFirmware(d.Firmware)
+	return ipmi.CompletionOK, []byte{
+		d.DeviceID,
+		d.Revision & 0x0f,
+		major & 0x7f,
+		minor,
+		0x02, // IPMI 2.0
+		0x8f, // chassis, FRU, SEL, SDR repository and sensor device
+		byte(d.ManufacturerID), byte(d.ManufacturerID >> 8), byte(d.ManufacturerID>>16) & 0x0f,
+		byte(d.ProductID), byte(d.ProductID >> 8),
+	}
+}
+
+// parseFirmware returns the major and BCD encoded minor firmware revision
+// of a version like "3.88".
+func parseFirmware(v string) (byte, byte) {
+	parts := strings.SplitN(v, ".", 2)
+	major, _ := strconv.ParseUint(parts[0], 10, 7)
+	var minor uint64
+	if len(parts) == 2 {
+		minor, _ = strconv.ParseUint(parts[1], 16, 8)
+	}
+	return byte(major), byte(minor)
+}
+
+func (s *Simulator) authCapabilities(req []byte) (byte, []byte) {
+	if len(req) < 2 {
+		return 0xc7, nil
+	}
+	c := s.scenario.Channel
+	status := byte(0x04) // non-null user names
+	if c.NullUsernames {
+		status |= 0x02
+	}
+	if c.AnonymousLogin {
+		status |= 0x01
+	}
+	return ipmi.CompletionOK, []byte{c.Number, 0x80, status, 0x02, 0, 0, 0, 0}
+}
+
+// cipherSuites answers Get Channel Cipher Suites with the records of the
+// enabled cipher suites, listed by cipher suite.
+func (s *Simulator) cipherSuites(req []byte) (byte, []byte) {
+	if len(req) < 3 {
+		return 0xc7, nil
+	}
+	var records []byte
+	for _, id := range s.scenario.Channel.CipherSuites {
+		c, _ := ipmi.LookupCipherSuite(byte(id))
+		records = append(records, 0xc0, c.ID, c.Auth, 0x40|c.Integrity, 0x80|c.Confidentiality)
+	}
+
+	start := int(req[2]&0x3f) * 16
+	data := []byte{s.scenario.Channel.Number}
+	if start < len(records) {
+		end := start + 16
+		if end > len(records) {
+			end = len(records)
+		}
+		data = append(data, records[start:end]...)
+	}
+	return ipmi.CompletionOK, data
+}
+
+func (s *Simulator) setPrivilege(sess *session, req []byte) (byte, []byte) {
+	if sess == nil || len(req) < 1 {
+		return 0xc7, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	level := req[0] & 0x0f
+	if level == 0 {
+		return ipmi.CompletionOK, []byte{sess.privilege}
+	}
+	if level > sess.privilege {
+		return 0x81, nil
+	}
+	sess.privilege = level
+	return ipmi.CompletionOK, []byte{level}
+}
+
+func (s *Simulator) sdrInfo() (byte, []byte) {
+	n := len(s.sdr)
+	return ipmi.CompletionOK, []byte{0x51, byte(n), byte(n >> 8), 0xff, 0xff, 0, 0, 0, 0, 0, 0, 0, 0, 0x02}
+}
+
+func (s *Simulator) getSDR(req []byte) (byte, []byte) {
+	if len(req) < 6 {
+		return 0xc7, nil
+	}
+	reservation := binary.LittleEndian.Uint16(req)
+	id := binary.LittleEndian.Uint16(req[2:])
+	offset, count := int(req[4]), int(req[5])
+
+	s.mu.Lock()
+	current := s.reservation
+	s.mu.Unlock()
+	if offset > 0 && reservation != current {
+		return ipmi.CompletionInvalidReservation, nil
+	}
+
+	// Record ID 0 is the first record.
+	if id == 0 {
+		id = 1
+	}
+	if int(id) > len(s.sdr) {
+		return ipmi.CompletionNotPresent, nil
+	}
+	record := s.sdr[id-1]
+	next := id + 1
+	if int(next) > len(s.sdr) {
+		next = 0xffff
+	}
+
+	if offset > len(record) {
+		return ipmi.CompletionParameterOutOfRange, nil
+	}
+	end := offset + count
+	if count == 0xff || end > len(record) {
+		end = len(record)
+	}
+	return ipmi.CompletionOK, append([]byte{byte(next), byte(next >> 8)}, record[offset:end]...)
+}
+
+func (s *Simulator) sensor(number byte) *Sensor {
+	for i := range s.scenario.Sensors {
+		if s.scenario.Sensors[i].Number == number {
+			return &s.scenario.Sensors[i]
+		}
+	}
+	return nil
+}
+
+func (s *Simulator) fault(command string) *Fault {
+	for i := range s.scenario.Faults {
+		if s.scenario.Faults[i].Command == command {
+			return &s.scenario.Faults[i]
+		}
+	}
+	return nil
+}
+
+// applyFault delays the answer if required and reports whether an answer
+// is sent at all.
+func applyFault(f *Fault) bool {
+	if f == nil {
+		return true
+	}
+	switch f.Action {
+	case "drop":
+		return false
+	case "delay":
+		time.Sleep(f.delay)
+	}
+	return true
+}
+
+func randomUint32() uint32 {
+	var b [4]byte
+	rand.Read(b[:])
+	return binary.LittleEndian.Uint32(b[:])
+}
+
+// String returns a summary of the simulated BMC.
+func (s *Simulator) String() string {
+	d := s.scenario.Device
+	return fmt.Sprintf("BMC of manufacturer %d, product %d with %d sensors", d.ManufacturerID, d.ProductID, len(s.sdr))
+}
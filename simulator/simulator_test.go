@@ -0,0 +1,258 @@
+package simulator
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lovoo/ipmi_exporter/ipmi"
+)
+
+// start runs a simulator for the scenario file with the given faults and
+// returns its address and a function stopping it.
+func start(t *testing.T, faults ...Fault) (string, func()) {
+	scenario, err := LoadScenario("testdata/supermicro.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scenario.Faults = faults
+	sim, err := New(scenario)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go sim.Serve(conn)
+	return conn.LocalAddr().String(), func() { conn.Close() }
+}
+
+var admin = ipmi.Config{Username: "ADMIN", Password: "ADMIN", Timeout: 500 * time.Millisecond}
+
+func TestPing(t *testing.T) {
+	addr, stop := start(t)
+	defer stop()
+
+	pong, err := ipmi.Ping(addr, time.Second)
+	if err != nil {
+		t.Fatalf("ping failed: %v", err)
+	}
+	if !pong.SupportsIPMI() {
+		t.Error("want IPMI support in pong")
+	}
+}
+
+func TestAuthCapabilities(t *testing.T) {
+	addr, stop := start(t)
+	defer stop()
+
+	caps, err := ipmi.GetChannelAuthCapabilities(addr, time.Second)
+	if err != nil {
+		t.Fatalf("getting authentication capabilities failed: %v", err)
+	}
+	if caps.Channel != 1 || !caps.IPMIv20 || caps.AnonymousLogin || caps.NullUsernames {
+		t.Errorf("unexpected capabilities %+v", caps)
+	}
+}
+
+func TestSession(t *testing.T) {
+	for _, suite := range []byte{3, 17} {
+		addr, stop := start(t)
+
+		cfg := admin
+		cfg.CipherSuite = suite
+		s, err := ipmi.Dial(addr, cfg)
+		if err != nil {
+			t.Fatalf("cipher suite %d: opening session failed: %v", suite, err)
+		}
+
+		id, err := s.GetDeviceID()
+		if err != nil {
+			t.Fatalf("cipher suite %d: getting device ID failed: %v", suite, err)
+		}
+		if id.ManufacturerID != 10876 || id.ProductID != 2137 || id.FirmwareRevision != "3.88" || id.IPMIVersion != "2.0" {
+			t.Errorf("cipher suite %d: unexpected device ID %+v", suite, id)
+		}
+
+		if err := s.Close(); err != nil {
+			t.Errorf("cipher suite %d: closing session failed: %v", suite, err)
+		}
+		stop()
+	}
+}
+
+func TestSensors(t *testing.T) {
+	addr, stop := start(t)
+	defer stop()
+
+	s, err := ipmi.Dial(addr, admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	r, err := s.Raw(ipmi.NetFnStorage, ipmi.CmdReserveSDRRepository, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Read the header and the body of the second record separately.
+	header, err := s.Raw(ipmi.NetFnStorage, ipmi.CmdGetSDR, []byte{r[0], r[1], 2, 0, 0, 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(header, []byte{3, 0, 2, 0, 0x51, 0x01, 47}) {
+		t.Fatalf("unexpected SDR header % x", header)
+	}
+	body, err := s.Raw(ipmi.NetFnStorage, ipmi.CmdGetSDR, []byte{r[0], r[1], 2, 0, 5, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) != 2+47 || string(body[len(body)-4:]) != "FAN2" || body[2+2] != 65 {
+		t.Errorf("unexpected SDR body % x", body)
+	}
+	// M of the linearization.
+	if body[2+19] != 75 {
+		t.Errorf("want M 75, got %d", body[2+19])
+	}
+
+	reading, err := s.Raw(ipmi.NetFnSensor, ipmi.CmdGetSensorReading, []byte{65})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reading[0] != 40 {
+		t.Errorf("want raw reading 40, got %d", reading[0])
+	}
+
+	thresholds, err := s.Raw(ipmi.NetFnSensor, ipmi.CmdGetSensorThresholds, []byte{48})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(thresholds, []byte{0x12, 0, 175, 0, 0, 225, 0}) {
+		t.Errorf("unexpected thresholds % x", thresholds)
+	}
+
+	if _, err := s.Raw(ipmi.NetFnSensor, ipmi.CmdGetSensorReading, []byte{99}); err == nil {
+		t.Error("want error for missing sensor")
+	}
+
+	wd, err := s.Raw(ipmi.NetFnApp, ipmi.CmdGetWatchdogTimer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(wd, []byte{0x44, 0, 0, 0, 0x64, 0, 0x64, 0}) {
+		t.Errorf("unexpected fixed response % x", wd)
+	}
+}
+
+func TestAuthFailures(t *testing.T) {
+	addr, stop := start(t)
+	defer stop()
+
+	for _, tc := range []struct {
+		cfg    ipmi.Config
+		status byte
+	}{
+		{ipmi.Config{Username: "ADMIN", Password: "wrong"}, 0},
+		{ipmi.Config{Username: "nobody", Password: "ADMIN"}, ipmi.StatusUnauthorizedName},
+		{ipmi.Config{Username: "monitor", Password: "secret", Privilege: ipmi.PrivilegeAdministrator}, ipmi.StatusUnauthorizedRole},
+		{ipmi.Config{Username: "ADMIN", Password: "ADMIN", CipherSuite: 2}, ipmi.StatusNoCipherSuiteMatch},
+	} {
+		tc.cfg.Timeout = 500 * time.Millisecond
+		_, err := ipmi.Dial(addr, tc.cfg)
+		if tc.status == 0 {
+			if err != ipmi.ErrAuthFailed {
+				t.Errorf("%s: want authentication failure, got %v", tc.cfg.Username, err)
+			}
+			continue
+		}
+		if se, ok := err.(*ipmi.StatusError); !ok || se.Status != tc.status {
+			t.Errorf("%s: want status 0x%02x, got %v", tc.cfg.Username, tc.status, err)
+		}
+	}
+
+	cfg := ipmi.Config{Username: "monitor", Password: "secret", Privilege: ipmi.PrivilegeUser}
+	s, err := ipmi.Dial(addr, cfg)
+	if err != nil {
+		t.Fatalf("opening user session failed: %v", err)
+	}
+	s.Close()
+}
+
+func TestFaults(t *testing.T) {
+	for _, tc := range []struct {
+		fault Fault
+		check func(error) bool
+	}{
+		{
+			Fault{Command: "0x06 0x01", Action: "drop"},
+			func(err error) bool { return err == ipmi.ErrTimeout },
+		},
+		{
+			Fault{Command: "0x6 0x1", Action: "malformed"},
+			func(err error) bool { return err != nil && strings.Contains(err.Error(), "malformed") },
+		},
+		{
+			Fault{Command: "0x06 0x01", Action: "completion_code", CompletionCode: 0xc3},
+			func(err error) bool {
+				ce, ok := err.(*ipmi.CompletionError)
+				return ok && ce.Code == 0xc3
+			},
+		},
+		{
+			Fault{Command: "0x06 0x01", Action: "delay", Delay: "10ms"},
+			func(err error) bool { return err == nil },
+		},
+	} {
+		addr, stop := start(t, tc.fault)
+		s, err := ipmi.Dial(addr, admin)
+		if err != nil {
+			t.Fatalf("%s: opening session failed: %v", tc.fault.Action, err)
+		}
+		if _, err := s.GetDeviceID(); !tc.check(err) {
+			t.Errorf("%s: unexpected error %v", tc.fault.Action, err)
+		}
+		s.Close()
+		stop()
+	}
+}
+
+func TestSessionSetupFaults(t *testing.T) {
+	for _, stage := range []string{"open_session", "rakp1", "rakp3"} {
+		addr, stop := start(t, Fault{Command: stage, Action: "drop"})
+		if _, err := ipmi.Dial(addr, admin); err != ipmi.ErrTimeout {
+			t.Errorf("%s: want timeout, got %v", stage, err)
+		}
+		stop()
+
+		addr, stop = start(t, Fault{Command: stage, Action: "malformed"})
+		if _, err := ipmi.Dial(addr, admin); err == nil {
+			t.Errorf("%s: want error for malformed answer", stage)
+		}
+		stop()
+	}
+
+	addr, stop := start(t, Fault{Command: "ping", Action: "drop"})
+	defer stop()
+	if _, err := ipmi.Ping(addr, 200*time.Millisecond); err != ipmi.ErrTimeout {
+		t.Errorf("ping: want timeout, got %v", err)
+	}
+}
+
+func TestInvalidScenario(t *testing.T) {
+	for _, s := range []*Scenario{
+		{Sensors: []Sensor{{Name: "CPU Temp", Type: "temperature", Unit: "degrees C", Value: 300}}},
+		{Sensors: []Sensor{{Name: "CPU Temp", Type: "nope"}}},
+		{Users: []User{{Name: "root", Privilege: "god"}}},
+		{Faults: []Fault{{Command: "0x06 0x01", Action: "explode"}}},
+		{Responses: map[string]string{"0x06": "00"}},
+		{Channel: Channel{CipherSuites: []int{42}}},
+	} {
+		if _, err := New(s); err == nil {
+			t.Errorf("want error for scenario %+v", s)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lovoo/ipmi_exporter/collector"
+	"github.com/lovoo/ipmi_exporter/config"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// statusHistory is the number of recent collections shown on the status page,
+// and the number of probed addresses that are not named targets.
+const statusHistory = 100
+
+// probeStatus is a collection of a target with a module, which is empty for
+// the local BMC.
+type probeStatus struct {
+	// ID identifies the collection, 0 if the target was not collected.
+	ID         int
+	Target     string
+	Module     string
+	Collection *collector.Collection
+}
+
+// recentProbe is a recent collection with its trace, which is only kept for
+// the recent collections.
+type recentProbe struct {
+	*probeStatus
+	Trace *collector.Trace
+}
+
+// partStatus is the result of a part of a collection.
+type partStatus struct {
+	Name string
+	Err  error
+}
+
+// statusPage serves an HTML page with the last collection of each target and
+// the recent collections, like the recent probes of the blackbox exporter.
+type statusPage struct {
+	config *config.Config
+	local  *collector.Exporter
+	prober *prober
+	// path is the path of the status page.
+	path string
+	// probePath is the path of the probe endpoint.
+	probePath string
+	// links are the other pages of the exporter by title.
+	links map[string]string
+
+	mu     sync.Mutex
+	last   map[string]*probeStatus
+	recent []*recentProbe
+	nextID int
+}
+
+func newStatusPage(path, probePath string, c *config.Config, local *collector.Exporter, p *prober) *statusPage {
+	s := &statusPage{
+		path:      path,
+		probePath: probePath,
+		config:    c,
+		local:     local,
+		prober:    p,
+		links:     map[string]string{},
+		last:      map[string]*probeStatus{},
+	}
+	local.OnCollect = s.observer(config.LocalTarget, "")
+	local.TraceCollections = true
+	p.status = s
+	return s
+}
+
+// observer returns the function recording the collections of a target.
+func (s *statusPage) observer(target, module string) func(*collector.Collection) {
+	return func(c *collector.Collection) {
+		untraced := *c
+		untraced.Trace = nil
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.nextID++
+		p := &probeStatus{ID: s.nextID, Target: target, Module: module, Collection: &untraced}
+		s.last[module+"/"+target] = p
+		s.prune()
+		s.recent = append(s.recent, &recentProbe{p, c.Trace})
+		if len(s.recent) > statusHistory {
+			s.recent = s.recent[len(s.recent)-statusHistory:]
+		}
+	}
+}
+
+// prune forgets the last collections of the least recently probed addresses
+// that are not named targets, keeping statusHistory of them. It must be
+// called with s.mu held.
+func (s *statusPage) prune() {
+	var other []string
+	for key, p := range s.last {
+		if _, named := s.config.Target(p.Target); !named && p.Module != "" {
+			other = append(other, key)
+		}
+	}
+	if len(other) <= statusHistory {
+		return
+	}
+	sort.Slice(other, func(i, j int) bool {
+		return s.last[other[i]].Collection.Start.Before(s.last[other[j]].Collection.Start)
+	})
+	for _, key := range other[:len(other)-statusHistory] {
+		delete(s.last, key)
+	}
+}
+
+// targets returns the status of the local BMC, the named targets and the
+// other probed targets, in this order.
+func (s *statusPage) targets() []*probeStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var targets []*probeStatus
+	seen := map[string]bool{}
+	add := func(target, module string) {
+		key := module + "/" + target
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		if p, ok := s.last[key]; ok {
+			targets = append(targets, p)
+			return
+		}
+		targets = append(targets, &probeStatus{Target: target, Module: module})
+	}
+
+	add(config.LocalTarget, "")
+	for _, g := range s.config.ServiceDiscovery() {
+		module := g.Labels["module"]
+		if module == "" {
+			module = config.DefaultModule
+		}
+		add(g.Targets[0], module)
+	}
+	var other []string
+	for key := range s.last {
+		if !seen[key] {
+			other = append(other, key)
+		}
+	}
+	sort.Strings(other)
+	for _, key := range other {
+		p := s.last[key]
+		add(p.Target, p.Module)
+	}
+	return targets
+}
+
+func (s *statusPage) recentProbes() []*recentProbe {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	recent := make([]*recentProbe, len(s.recent))
+	for i, p := range s.recent {
+		recent[len(s.recent)-1-i] = p
+	}
+	return recent
+}
+
+func (s *statusPage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Links     map[string]string
+		ProbePath string
+		APIPrefix string
+		Now       time.Time
+		Targets   []*probeStatus
+		Recent    []*recentProbe
+	}{s.links, s.probePath, apiPrefix, time.Now(), s.targets(), s.recentProbes()}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusTemplate.Execute(w, data); err != nil {
+		log.Errorf("Error rendering status page: %v", err)
+	}
+}
+
+// serveTrace serves the trace of the recent collection given by the id
+// parameter as plain text.
+func (s *statusPage) serveTrace(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "invalid 'id' parameter", http.StatusBadRequest)
+		return
+	}
+	var probe *recentProbe
+	s.mu.Lock()
+	for _, p := range s.recent {
+		if p.ID == id {
+			probe = p
+		}
+	}
+	s.mu.Unlock()
+	if probe == nil || probe.Trace == nil {
+		http.Error(w, "the trace is no longer available", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "Collection of %s", probe.Target)
+	if probe.Module != "" {
+		fmt.Fprintf(w, " with module %s", probe.Module)
+	}
+	fmt.Fprintf(w, " at %s, %s:\n", probe.Collection.Start.Format("2006-01-02 15:04:05"), result(probe.Collection))
+	probe.Trace.WriteTo(w)
+}
+
+func result(c *collector.Collection) string {
+	if err := c.Err(); err != nil {
+		return "failed: " + err.Error()
+	}
+	return "succeeded"
+}
+
+// sameOrigin reports whether a request comes from a page of the exporter
+// rather than a page of another site, going by the headers browsers send
+// with cross-site requests. Requests without these headers are not sent by
+// browsers and cannot be forged by another site.
+func sameOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// probeNow collects the metrics of the target of the form and redirects back
+// to the status page. Only forms of the status page are accepted, so other
+// sites cannot make browsers probe targets.
+func (s *statusPage) probeNow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !sameOrigin(r) {
+		http.Error(w, "cross-origin request rejected", http.StatusForbidden)
+		return
+	}
+	target, module := r.FormValue("target"), r.FormValue("module")
+	exporter := s.local
+	if target != config.LocalTarget || module != "" {
+		var (
+			status int
+			err    error
+		)
+		exporter, status, err = s.prober.lookup(target, module)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter)
+	if _, err := registry.Gather(); err != nil {
+		log.With("target", target).With("module", module).Errorf("Error probing: %v", err)
+	}
+	http.Redirect(w, r, s.path, http.StatusSeeOther)
+}
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"ago": func(now, t time.Time) time.Duration {
+		d := now.Sub(t)
+		return d - d%time.Second
+	},
+	"milliseconds": func(d time.Duration) time.Duration {
+		return d - d%time.Millisecond
+	},
+	"parts": func(c *collector.Collection) []partStatus {
+		parts := make([]partStatus, 0, len(c.Parts))
+		for name, err := range c.Parts {
+			parts = append(parts, partStatus{name, err})
+		}
+		sort.Slice(parts, func(i, j int) bool { return parts[i].Name < parts[j].Name })
+		return parts
+	},
+}).Parse(`<html>
+<head>
+<title>IPMI Exporter</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+.ok { color: #080; }
+.failed { color: #c00; }
+</style>
+</head>
+<body>
+<h1>IPMI Exporter</h1>
+<p>{{range $title, $path := .Links}}<a href="{{$path}}">{{$title}}</a> {{end}}</p>
+<p>Remote BMCs are probed at <code>{{.ProbePath}}?target=&lt;host&gt;&amp;module=&lt;module&gt;</code>.
+Snapshots of the BMCs are served as JSON at <code>{{.APIPrefix}}&lt;host&gt;/&lt;sensors|sel|fru|bmc&gt;</code>.</p>
+
+<h2>Targets</h2>
+<table>
+<tr><th>Target</th><th>Module</th><th>Last collection</th><th>Duration</th><th>Parts</th><th>Last error</th><th>SDR cache age</th><th></th></tr>
+{{range .Targets}}<tr>
+<td>{{.Target}}</td>
+<td>{{.Module}}</td>
+{{with .Collection}}<td>{{.Start.Format "2006-01-02 15:04:05"}} ({{ago $.Now .Start}} ago)</td>
+<td>{{milliseconds .Duration}}</td>
+<td>{{range parts .}}{{if .Err}}<span class="failed" title="{{.Err}}">{{.Name}}</span>{{else}}<span class="ok">{{.Name}}</span>{{end}} {{end}}</td>
+<td>{{with .Err}}<span class="failed">{{.}}</span>{{end}}</td>
+<td>{{if .SDRCached.IsZero}}not cached{{else}}{{ago $.Now .SDRCached}}{{end}}</td>
+{{else}}<td>never</td><td></td><td></td><td></td><td></td>
+{{end}}<td><form method="post" action="/status/probe"><input type="hidden" name="target" value="{{.Target}}"><input type="hidden" name="module" value="{{.Module}}"><input type="submit" value="Probe now"></form>{{if .ID}} <a href="/status/trace?id={{.ID}}">Trace</a>{{end}}{{if .Module}} <a href="{{$.ProbePath}}?target={{.Target}}&amp;module={{.Module}}&amp;debug=true">Debug</a>{{end}}</td>
+</tr>
+{{end}}</table>
+
+<h2>Recent collections</h2>
+<table>
+<tr><th>Time</th><th>Target</th><th>Module</th><th>Duration</th><th>Result</th><th></th></tr>
+{{range .Recent}}<tr>
+<td>{{.Collection.Start.Format "2006-01-02 15:04:05"}}</td>
+<td>{{.Target}}</td>
+<td>{{.Module}}</td>
+<td>{{milliseconds .Collection.Duration}}</td>
+<td>{{with .Collection.Err}}<span class="failed">{{.}}</span>{{else}}<span class="ok">ok</span>{{end}}</td>
+<td>{{if .Trace}}<a href="/status/trace?id={{.ID}}">Trace</a>{{end}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,418 @@
+// Package web serves the HTTP endpoints of the exporter with TLS and basic
+// authentication configured by a web configuration file.
+//
+// The file uses the YAML format of the Prometheus exporter-toolkit, see
+// https://github.com/prometheus/exporter-toolkit/blob/master/docs/web-configuration.md:
+//
+//	tls_server_config:
+//	  cert_file: server.crt
+//	  key_file: server.key
+//	  client_auth_type: RequireAndVerifyClientCert
+//	  client_ca_file: ca.crt
+//	basic_auth_users:
+//	  prometheus: $2y$10$...
+//
+// The file is read and validated once by LoadConfig. The certificate and key
+// are read again for new connections when they changed, so renewed
+// certificates take effect without a restart.
+package web
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/log"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the web configuration.
+type Config struct {
+	TLSConfig  TLSConfig  `yaml:"tls_server_config"`
+	HTTPConfig HTTPConfig `yaml:"http_server_config"`
+	// Users maps user names to bcrypt hashes of their passwords.
+	Users map[string]string `yaml:"basic_auth_users"`
+}
+
+// TLSConfig configures TLS. TLS is enabled if a certificate is given. Relative
+// paths are relative to the directory of the configuration file.
+type TLSConfig struct {
+	CertFile          string   `yaml:"cert_file"`
+	KeyFile           string   `yaml:"key_file"`
+	ClientAuth        string   `yaml:"client_auth_type"`
+	ClientCAs         string   `yaml:"client_ca_file"`
+	ClientAllowedSANs []string `yaml:"client_allowed_sans"`
+	MinVersion        string   `yaml:"min_version"`
+	MaxVersion        string   `yaml:"max_version"`
+	CipherSuites      []string `yaml:"cipher_suites"`
+	CurvePreferences  []string `yaml:"curve_preferences"`
+	PreferServerCS    bool     `yaml:"prefer_server_cipher_suites"`
+}
+
+// HTTPConfig configures the HTTP responses.
+type HTTPConfig struct {
+	// HTTP2 is accepted for compatibility with the exporter-toolkit. HTTP/2
+	// is negotiated by the Go HTTP server over TLS.
+	HTTP2 *bool `yaml:"http2"`
+	// Headers are added to all responses, e.g. Strict-Transport-Security.
+	Headers map[string]string `yaml:"headers"`
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                           tls.NoClientCert,
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+var tlsVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	// tls.VersionTLS13, which older Go releases do not define.
+	"TLS13": 0x0304,
+}
+
+var curves = map[string]tls.CurveID{
+	"CurveP256": tls.CurveP256,
+	"CurveP384": tls.CurveP384,
+	"CurveP521": tls.CurveP521,
+	"X25519":    tls.X25519,
+}
+
+var cipherSuites = map[string]uint16{
+	"TLS_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":   tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384": tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":    tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305":  tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+}
+
+// LoadConfig reads and validates a web configuration file, including its
+// certificates and password hashes. Unknown fields are rejected.
+func LoadConfig(file string) (*Config, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := yaml.UnmarshalStrict(buf, &c); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", file, err)
+	}
+	dir := filepath.Dir(file)
+	for _, p := range []*string{&c.TLSConfig.CertFile, &c.TLSConfig.KeyFile, &c.TLSConfig.ClientCAs} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+	for user, hash := range c.Users {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("invalid password hash for user %s in %s: %v", user, file, err)
+		}
+	}
+	if _, err := c.TLSConfig.config(); err != nil {
+		return nil, fmt.Errorf("invalid TLS configuration in %s: %v", file, err)
+	}
+	return &c, nil
+}
+
+// enabled reports whether TLS is configured.
+func (c *TLSConfig) enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// config builds the TLS configuration. The certificate is loaded by
+// GetCertificate for each new connection.
+func (c *TLSConfig) config() (*tls.Config, error) {
+	if !c.enabled() {
+		if c.ClientCAs != "" || c.ClientAuth != "" {
+			return nil, errors.New("client authentication requires cert_file and key_file")
+		}
+		return nil, nil
+	}
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("cert_file and key_file must both be set")
+	}
+	certs := &certLoader{certFile: c.CertFile, keyFile: c.KeyFile}
+	if _, err := certs.load(); err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		GetCertificate:           certs.GetCertificate,
+		MinVersion:               tls.VersionTLS12,
+		PreferServerCipherSuites: c.PreferServerCS,
+	}
+
+	auth, ok := clientAuthTypes[c.ClientAuth]
+	if !ok {
+		return nil, fmt.Errorf("unknown client_auth_type %q", c.ClientAuth)
+	}
+	cfg.ClientAuth = auth
+	if c.ClientCAs != "" {
+		pem, err := ioutil.ReadFile(c.ClientCAs)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.ClientCAs)
+		}
+	} else if auth == tls.VerifyClientCertIfGiven || auth == tls.RequireAndVerifyClientCert {
+		return nil, fmt.Errorf("client_auth_type %s requires client_ca_file", c.ClientAuth)
+	}
+	if len(c.ClientAllowedSANs) > 0 {
+		if auth != tls.RequireAndVerifyClientCert {
+			return nil, errors.New("client_allowed_sans requires client_auth_type RequireAndVerifyClientCert")
+		}
+		cfg.VerifyPeerCertificate = allowedSANs(c.ClientAllowedSANs)
+	}
+
+	if c.MinVersion != "" {
+		if cfg.MinVersion, ok = tlsVersions[c.MinVersion]; !ok {
+			return nil, fmt.Errorf("unknown min_version %q", c.MinVersion)
+		}
+	}
+	if c.MaxVersion != "" {
+		if cfg.MaxVersion, ok = tlsVersions[c.MaxVersion]; !ok {
+			return nil, fmt.Errorf("unknown max_version %q", c.MaxVersion)
+		}
+		if cfg.MaxVersion < cfg.MinVersion {
+			return nil, fmt.Errorf("max_version %s is lower than the min_version", c.MaxVersion)
+		}
+	}
+	for _, name := range c.CipherSuites {
+		id, ok := cipherSuites[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		cfg.CipherSuites = append(cfg.CipherSuites, id)
+	}
+	for _, name := range c.CurvePreferences {
+		id, ok := curves[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown curve %q", name)
+		}
+		cfg.CurvePreferences = append(cfg.CurvePreferences, id)
+	}
+	return cfg, nil
+}
+
+// allowedSANs returns a function accepting client certificates with one of
+// the subject alternative names.
+func allowedSANs(sans []string) func([][]byte, [][]*x509.Certificate) error {
+	return func(_ [][]byte, chains [][]*x509.Certificate) error {
+		if len(chains) == 0 || len(chains[0]) == 0 {
+			return errors.New("no verified client certificate")
+		}
+		cert := chains[0][0]
+		var names []string
+		names = append(names, cert.DNSNames...)
+		names = append(names, cert.EmailAddresses...)
+		for _, ip := range cert.IPAddresses {
+			names = append(names, ip.String())
+		}
+		for _, allowed := range sans {
+			for _, name := range names {
+				if name == allowed {
+					return nil
+				}
+			}
+		}
+		return errors.New("client certificate has no allowed subject alternative name")
+	}
+}
+
+// certLoader loads the certificate for new connections, reading the files
+// again when they were modified. If the modified files cannot be loaded, the
+// previous certificate is used.
+type certLoader struct {
+	certFile, keyFile string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modified time.Time
+}
+
+func (l *certLoader) load() (*tls.Certificate, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var modified time.Time
+	for _, file := range []string{l.certFile, l.keyFile} {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return l.cert, err
+		}
+		if fi.ModTime().After(modified) {
+			modified = fi.ModTime()
+		}
+	}
+	if l.cert != nil && modified.Equal(l.modified) {
+		return l.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		return l.cert, err
+	}
+	l.cert, l.modified = &cert, modified
+	return l.cert, nil
+}
+
+// GetCertificate returns the current certificate.
+func (l *certLoader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, err := l.load()
+	if err != nil {
+		log.With("file", l.certFile).Errorf("Error reloading certificate: %v", err)
+		if cert == nil {
+			return nil, err
+		}
+	}
+	return cert, nil
+}
+
+// ListenAndServe listens on the address of server and serves it with the web
+// configuration c. Without a configuration, server is served with plain HTTP
+// and no authentication.
+func ListenAndServe(server *http.Server, c *Config) error {
+	addr := server.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return Serve(l, server, c)
+}
+
+// Serve serves server on l with the web configuration c, which may be nil.
+func Serve(l net.Listener, server *http.Server, c *Config) error {
+	if c == nil {
+		return server.Serve(l)
+	}
+
+	handler := server.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	dummy, err := dummyHash(c.Users)
+	if err != nil {
+		return err
+	}
+	server.Handler = &authHandler{
+		users:     c.Users,
+		dummyHash: dummy,
+		headers:   c.HTTPConfig.Headers,
+		handler:   handler,
+		cache:     map[[sha256.Size]byte]bool{},
+	}
+
+	if !c.TLSConfig.enabled() {
+		if len(c.Users) > 0 {
+			log.Warnln("Basic authentication is enabled without TLS, passwords are sent in clear text")
+		}
+		return server.Serve(l)
+	}
+
+	cfg, err := c.TLSConfig.config()
+	if err != nil {
+		return err
+	}
+	server.TLSConfig = cfg
+	return server.Serve(tls.NewListener(l, cfg))
+}
+
+// authHandler adds the configured headers to responses and requires basic
+// authentication for users if set.
+type authHandler struct {
+	users map[string]string
+	// dummyHash is compared with the password of unknown users, so they
+	// take as long to reject as known users with a wrong password.
+	dummyHash string
+	headers   map[string]string
+	handler   http.Handler
+
+	mu sync.Mutex
+	// cache remembers the outcome of bcrypt comparisons, which are slow
+	// by design, keyed by a hash of user, password hash and password.
+	cache map[[sha256.Size]byte]bool
+}
+
+func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for name, value := range h.headers {
+		w.Header().Set(name, value)
+	}
+	if len(h.users) == 0 {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	user, pass, ok := r.BasicAuth()
+	if ok {
+		hash, exists := h.users[user]
+		if !exists {
+			hash = h.dummyHash
+		}
+		if h.verify(user, hash, pass) && exists {
+			h.handler.ServeHTTP(w, r)
+			return
+		}
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="IPMI Exporter"`)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// dummyHash returns a bcrypt hash with the highest cost of the password
+// hashes of users, empty without users.
+func dummyHash(users map[string]string) (string, error) {
+	if len(users) == 0 {
+		return "", nil
+	}
+	cost := bcrypt.MinCost
+	for _, hash := range users {
+		if c, err := bcrypt.Cost([]byte(hash)); err == nil && c > cost {
+			cost = c
+		}
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte("dummy password"), cost)
+	return string(hash), err
+}
+
+func (h *authHandler) verify(user, hash, pass string) bool {
+	key := sha256.Sum256([]byte(user + "\x00" + hash + "\x00" + pass))
+	h.mu.Lock()
+	valid, ok := h.cache[key]
+	h.mu.Unlock()
+	if ok {
+		return valid
+	}
+
+	valid = bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+	h.mu.Lock()
+	if len(h.cache) >= 100 {
+		h.cache = map[[sha256.Size]byte]bool{}
+	}
+	h.cache[key] = valid
+	h.mu.Unlock()
+	return valid
+}
@@ -0,0 +1,290 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// writeCert writes a self-signed certificate with the given serial number and
+// its key to dir as name.crt and name.key.
+func writeCert(t *testing.T, dir, name string, serial int64) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, filepath.Join(dir, name+".crt"), string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	write(t, filepath.Join(dir, name+".key"), string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})))
+}
+
+func write(t *testing.T, file, content string) {
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func hash(t *testing.T, password string) string {
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(h)
+}
+
+// serve serves a handler answering "ok" with the web configuration file and
+// returns its address.
+func serve(t *testing.T, file string) (string, func()) {
+	c, err := LoadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})}
+	go Serve(l, server, c)
+	return l.Addr().String(), func() { l.Close() }
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipmi_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeCert(t, dir, "server", 1)
+	file := filepath.Join(dir, "web.yml")
+
+	write(t, file, `
+tls_server_config:
+  cert_file: server.crt
+  key_file: server.key
+  min_version: TLS12
+  max_version: TLS13
+  curve_preferences: [X25519, CurveP256]
+http_server_config:
+  http2: true
+  headers:
+    Strict-Transport-Security: max-age=31536000
+basic_auth_users:
+  prometheus: `+hash(t, "secret")+`
+`)
+	c, err := LoadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.TLSConfig.CertFile != filepath.Join(dir, "server.crt") {
+		t.Errorf("want certificate relative to the configuration, got %s", c.TLSConfig.CertFile)
+	}
+	if cfg, err := c.TLSConfig.config(); err != nil || cfg.MaxVersion != 0x0304 {
+		t.Errorf("want TLS 1.3 as max version, got %v", err)
+	}
+
+	// The JSON form is valid YAML.
+	write(t, file, `{"tls_server_config": {"cert_file": "server.crt", "key_file": "server.key"}}`)
+	if _, err := LoadConfig(file); err != nil {
+		t.Errorf("want JSON configuration accepted, got %v", err)
+	}
+
+	for _, content := range []string{
+		`tls_server_config: {cert_file: server.crt}`,
+		`tls_server_config: {cert_file: missing.crt, key_file: server.key}`,
+		`tls_server_config: {cert_file: server.crt, key_file: server.key, client_auth_type: Sometimes}`,
+		`tls_server_config: {cert_file: server.crt, key_file: server.key, client_auth_type: RequireAndVerifyClientCert}`,
+		`tls_server_config: {cert_file: server.crt, key_file: server.key, client_allowed_sans: [client]}`,
+		`tls_server_config: {cert_file: server.crt, key_file: server.key, min_version: SSL3}`,
+		`tls_server_config: {cert_file: server.crt, key_file: server.key, min_version: TLS13, max_version: TLS12}`,
+		`tls_server_config: {cert_file: server.crt, key_file: server.key, cipher_suites: [TLS_NULL]}`,
+		`tls_server_config: {cert_file: server.crt, key_file: server.key, curve_preferences: [CurveP1]}`,
+		`tls_server_config: {client_ca_file: server.crt}`,
+		`tls_server_config: {certfile: server.crt}`,
+		`basic_auth_users: {prometheus: plaintext}`,
+		`basic_auth_users: []`,
+		`basic_auth_users: {prometheus: [`,
+	} {
+		write(t, file, content)
+		if _, err := LoadConfig(file); err == nil {
+			t.Errorf("want error for %s", content)
+		}
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipmi_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "web.yml")
+	write(t, file, "basic_auth_users:\n  prometheus: "+hash(t, "secret")+"\nhttp_server_config:\n  headers:\n    X-Frame-Options: deny\n")
+
+	addr, stop := serve(t, file)
+	defer stop()
+
+	for _, tc := range []struct {
+		user, pass string
+		want       int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"prometheus", "wrong", http.StatusUnauthorized},
+		{"nobody", "secret", http.StatusUnauthorized},
+		{"prometheus", "secret", http.StatusOK},
+		{"prometheus", "secret", http.StatusOK},
+	} {
+		req, _ := http.NewRequest("GET", "http://"+addr+"/metrics", nil)
+		if tc.user != "" {
+			req.SetBasicAuth(tc.user, tc.pass)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s/%s: want status %d, got %d", tc.user, tc.pass, tc.want, resp.StatusCode)
+		}
+		if resp.Header.Get("X-Frame-Options") != "deny" {
+			t.Errorf("%s/%s: want configured header, got %v", tc.user, tc.pass, resp.Header)
+		}
+	}
+}
+
+func TestDummyHash(t *testing.T) {
+	h, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dummy, err := dummyHash(map[string]string{"prometheus": hash(t, "secret"), "grafana": string(h)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost, err := bcrypt.Cost([]byte(dummy)); err != nil || cost != bcrypt.MinCost+1 {
+		t.Errorf("want dummy hash with the highest cost %d, got %d (%v)", bcrypt.MinCost+1, cost, err)
+	}
+}
+
+// serial connects to addr and returns the serial number of the server
+// certificate.
+func serial(addr string, cfg *tls.Config) (int64, error) {
+	conn, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if err := conn.Handshake(); err != nil {
+		return 0, err
+	}
+	// With TLS 1.3 a rejected client certificate is only reported when
+	// reading.
+	conn.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		return 0, err
+	}
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), nil
+}
+
+func TestTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipmi_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeCert(t, dir, "server", 1)
+	file := filepath.Join(dir, "web.yml")
+	write(t, file, "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n")
+
+	addr, stop := serve(t, file)
+	defer stop()
+
+	insecure := &tls.Config{InsecureSkipVerify: true}
+	if s, err := serial(addr, insecure); err != nil || s != 1 {
+		t.Fatalf("want certificate 1, got %d, %v", s, err)
+	}
+
+	// Renewed certificates are loaded for new connections.
+	writeCert(t, dir, "server", 3)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "server.crt"), future, future)
+	if s, err := serial(addr, insecure); err != nil || s != 3 {
+		t.Errorf("want renewed certificate 3, got %d, %v", s, err)
+	}
+
+	// An invalid renewed certificate keeps the previous one.
+	write(t, filepath.Join(dir, "server.crt"), "invalid")
+	os.Chtimes(filepath.Join(dir, "server.crt"), future.Add(time.Minute), future.Add(time.Minute))
+	if s, err := serial(addr, insecure); err != nil || s != 3 {
+		t.Errorf("want previous certificate 3, got %d, %v", s, err)
+	}
+}
+
+func TestClientCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipmi_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeCert(t, dir, "server", 1)
+	writeCert(t, dir, "client", 2)
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	withCert := &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{cert}}
+
+	for _, tc := range []struct {
+		sans string
+		ok   bool
+	}{
+		{"", true},
+		{"\n  client_allowed_sans: [127.0.0.1]", true},
+		{"\n  client_allowed_sans: [other.example.com]", false},
+	} {
+		file := filepath.Join(dir, "web.yml")
+		write(t, file, "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n  client_auth_type: RequireAndVerifyClientCert\n  client_ca_file: client.crt"+tc.sans+"\n")
+		addr, stop := serve(t, file)
+
+		if _, err := serial(addr, &tls.Config{InsecureSkipVerify: true}); err == nil {
+			t.Errorf("%q: want error without client certificate", tc.sans)
+		}
+		if _, err := serial(addr, withCert); (err == nil) != tc.ok {
+			t.Errorf("%q: want connection with client certificate %v, got %v", tc.sans, tc.ok, err)
+		}
+		stop()
+	}
+}